@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuttapp/pinghist/dal"
+	"github.com/olekukonko/tablewriter"
+)
+
+// commands are run w/ pinghist <command> [flags], w/o one pinghist pings or queries
+var commands = map[string]func(args []string){
+	"migrate": migrateCommand,
+	"prune":   pruneCommand,
+	"hosts":   hostsCommand,
+}
+
+// openDB opens the db at path (the default path when "") & creates its buckets
+func openDB(path string) *dal.DAL {
+	if path == "" {
+		path = dal.DefaultPath()
+	}
+	db := dal.NewDALWithPath(path)
+	if err := db.Open(); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.CreateBuckets(); err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+	return db
+}
+
+// migrateCommand upgrades the db to the latest schema version, backing it up first
+func migrateCommand(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	path := fs.String("db", "", "The path of the database file to migrate, defaults to the one pinghist uses")
+	dryRun := fs.Bool("dry-run", false, "Show the migrations that would run w/o running them")
+	backup := fs.String("backup", "", "Where to back the database up to before migrating, defaults to <db>.<timestamp>.bak")
+	fs.Parse(args)
+
+	if *dryRun {
+		if err := migrateDryRun(*path); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db := openDB(*path)
+	defer db.Close()
+	if err := migrate(db, *dryRun, *backup); err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+// migrateDryRun lists the migrations the db at path (the default path when "")
+// has yet to run, it opens the db read-only so it's never written to
+func migrateDryRun(path string) error {
+	if path == "" {
+		path = dal.DefaultPath()
+	}
+	// bolt can't open a file it has yet to create read-only, it's a new db
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		fmt.Printf("%s is a new database, nothing to migrate\n", path)
+		return nil
+	}
+
+	db := dal.NewDALWithPath(path)
+	if err := db.OpenReadOnly(); err != nil {
+		return err
+	}
+	defer db.Close()
+	return migrate(db, true, "")
+}
+
+// migrate runs the pending migrations of db after backing it up to backup (a
+// path next to the db when ""), w/ dryRun it only lists them
+func migrate(db *dal.DAL, dryRun bool, backup string) error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pending := dal.PendingMigrations(version)
+	if len(pending) == 0 {
+		fmt.Printf("%s is up to date, schema version %d\n", db.Path(), version)
+		return nil
+	}
+
+	fmt.Printf("%s is at schema version %d, %d migration(s) to run:\n", db.Path(), version, len(pending))
+	for _, m := range pending {
+		fmt.Printf("  %d: %s\n", m.Version, m.Description)
+	}
+	if dryRun {
+		fmt.Println("Dry run, nothing was changed")
+		return nil
+	}
+
+	if backup == "" {
+		backup = fmt.Sprintf("%s.%s.bak", db.Path(), time.Now().Format("20060102-150405"))
+	}
+	if err := db.Backup(backup); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up to %s\n", backup)
+
+	for _, m := range pending {
+		start := time.Now()
+		if err := db.Migrate(m); err != nil {
+			return fmt.Errorf("%s, restore the backup at %s to undo the migrations that ran", err, backup)
+		}
+		fmt.Printf("Migrated to schema version %d in %s\n", m.Version, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// warnIfOutdated tells the user to migrate db when its schema is older than the
+// version this pinghist writes, it exits when the db is too old to be used
+func warnIfOutdated(db *dal.DAL) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if version < dal.ReadableSchemaVersion {
+		db.Close()
+		log.Fatalf("%s is at schema version %d, which this pinghist can't read, run pinghist migrate to upgrade it to %d", db.Path(), version, dal.SchemaVersion)
+	}
+	if version < dal.SchemaVersion {
+		fmt.Fprintf(os.Stderr, "%s is at schema version %d, run pinghist migrate to upgrade it to %d\n", db.Path(), version, dal.SchemaVersion)
+	}
+}
+
+// pruneCommand deletes the pings older than their retention
+func pruneCommand(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	path := fs.String("db", "", "The path of the database file to prune, defaults to the one pinghist uses")
+	dryRun := fs.Bool("dry-run", false, "Show what would be pruned w/o pruning it")
+	r := dal.DefaultRetention
+	addRetentionFlags(fs, &r)
+	fs.Parse(args)
+
+	db := openDB(*path)
+	defer db.Close()
+	warnIfOutdated(db)
+	if err := prune(db, r, *dryRun); err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+// prune deletes the pings of db older than r & lists what was deleted, w/
+// dryRun it only lists what would be
+func prune(db *dal.DAL, r dal.Retention, dryRun bool) error {
+	pruned, err := db.Prune(context.Background(), r, time.Now(), dryRun)
+	for _, pr := range pruned {
+		fmt.Println(formatPruned(pr, dryRun))
+	}
+	if err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		fmt.Println("Nothing to prune")
+	}
+	return nil
+}
+
+// prunedUnits are what the keys of each resolution are called
+var prunedUnits = map[string]string{
+	"pings":  "minutes of pings",
+	"hourly": "hourly rollups",
+	"daily":  "daily rollups",
+}
+
+// formatPruned returns a line about pr, ex: Pruned 60 minutes of pings of 10.0.0.1 from ... to ...
+func formatPruned(pr *dal.PrunedRange, dryRun bool) string {
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	unit, ok := prunedUnits[pr.Resolution]
+	if !ok {
+		unit = pr.Resolution
+	}
+	return fmt.Sprintf("%s %d %s of %s from %s to %s", verb, pr.Keys, unit, pr.Series,
+		pr.Start.Local().Format(timeFormat), pr.End.Local().Format(timeFormat))
+}
+
+// addRetentionFlags adds the flags that set r to fs
+func addRetentionFlags(fs *flag.FlagSet, r *dal.Retention) {
+	fs.Var(retentionFlag{&r.Pings}, "keeppings", "How long to keep every ping, ex: 30d, 0 keeps them forever")
+	fs.Var(retentionFlag{&r.Hourly}, "keephourly", "How long to keep the hourly rollups of pings, ex: 1y, 0 keeps them forever")
+	fs.Var(retentionFlag{&r.Daily}, "keepdaily", "How long to keep the daily rollups of pings, 0 keeps them forever")
+}
+
+// retentionSet returns whether any of the flags added by addRetentionFlags was
+// set on fs
+func retentionSet(fs *flag.FlagSet) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "keeppings", "keephourly", "keepdaily":
+			set = true
+		}
+	})
+	return set
+}
+
+const (
+	day  = 24 * time.Hour
+	year = 365 * day
+)
+
+// retentionFlag is a flag.Value for how long to keep pings, see parseRetention
+type retentionFlag struct{ d *time.Duration }
+
+func (rf retentionFlag) String() string {
+	if rf.d == nil {
+		return ""
+	}
+	switch d := *rf.d; {
+	case d == 0:
+		return "forever"
+	case d%year == 0:
+		return fmt.Sprintf("%dy", d/year)
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	default:
+		return d.String()
+	}
+}
+
+func (rf retentionFlag) Set(value string) error {
+	d, err := parseRetention(value)
+	if err != nil {
+		return err
+	}
+	*rf.d = d
+	return nil
+}
+
+var retentionUnits = map[string]time.Duration{"d": day, "y": year}
+
+// parseRetention parses a duration w/ days (d) & years (y, 365 days) on top of
+// the units of time.ParseDuration, ex: 30d. 0 & forever are forever
+func parseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "forever" || s == "0" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	var err error
+	if n := len(s); n > 0 && retentionUnits[s[n-1:]] != 0 {
+		var count int
+		count, err = strconv.Atoi(s[:n-1])
+		d = time.Duration(count) * retentionUnits[s[n-1:]]
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention %q, ex: 30d, 1y, 12h or forever", s)
+	}
+	return d, nil
+}
+
+// hostsCommand lists every host pinged w/ its pings, ever & in the last hour &
+// day, from the IPStats alone so it's quick however many pings there are
+func hostsCommand(args []string) {
+	fs := flag.NewFlagSet("hosts", flag.ExitOnError)
+	path := fs.String("db", "", "The path of the database file to list the hosts of, defaults to the one pinghist uses")
+	fs.Parse(args)
+
+	db := openDB(*path)
+	defer db.Close()
+	warnIfOutdated(db)
+	all, err := db.GetAllIPStats()
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+	WriteHosts(all, time.Now())
+}
+
+// WriteHosts prints a row per host, sorted by host
+func WriteHosts(all []*dal.IPStats, now time.Time) {
+	sort.Slice(all, func(i, j int) bool { return all[i].IP < all[j].IP })
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Host", "Last ping", "Received", "Lost",
+		"1h pings", "1h loss", "1h avg", "1h min", "1h max",
+		"24h pings", "24h loss", "24h avg", "24h min", "24h max",
+	})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, stats := range all {
+		table.Append(hostRow(stats, now))
+	}
+	table.Render()
+}
+
+// hostRow returns the row of WriteHosts of stats
+func hostRow(stats *dal.IPStats, now time.Time) []string {
+	row := []string{
+		stats.IP,
+		stats.LastPingTime.Local().Format(timeFormat),
+		fmt.Sprintf("%d", stats.Received),
+		fmt.Sprintf("%d", stats.Lost),
+	}
+	for _, w := range []dal.Window{stats.LastHour(now), stats.LastDay(now)} {
+		row = append(row, fmt.Sprintf("%d", w.Count))
+		if w.Count == 0 {
+			row = append(row, "-", "-", "-", "-")
+			continue
+		}
+		row = append(row, fmt.Sprintf("%.1f%%", w.Loss()))
+		if w.Count == w.Lost {
+			row = append(row, "-", "-", "-")
+			continue
+		}
+		row = append(row,
+			fmt.Sprintf("%.0f ms", w.Mean),
+			fmt.Sprintf("%.0f ms", w.Min),
+			fmt.Sprintf("%.0f ms", w.Max))
+	}
+	return row
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuttapp/pinghist/dal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_commands_unit(t *testing.T) {
+	Convey("parseRetention()", t, func() {
+		for s, d := range map[string]time.Duration{
+			"30d":     30 * 24 * time.Hour,
+			"1y":      365 * 24 * time.Hour,
+			"12h":     12 * time.Hour,
+			"forever": 0,
+			"0":       0,
+		} {
+			parsed, err := parseRetention(s)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldEqual, d)
+		}
+		for _, s := range []string{"", "d", "-1d", "10x", "-5h"} {
+			_, err := parseRetention(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("retentionFlag should print the way it's parsed", t, func() {
+		r := dal.DefaultRetention
+		So(retentionFlag{&r.Pings}.String(), ShouldEqual, "30d")
+		So(retentionFlag{&r.Hourly}.String(), ShouldEqual, "1y")
+		So(retentionFlag{&r.Daily}.String(), ShouldEqual, "forever")
+	})
+	Convey("retentionSet() should only be true once a -keep* flag is given", t, func() {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		r := dal.DefaultRetention
+		addRetentionFlags(fs, &r)
+		fs.String("h", "", "")
+		So(fs.Parse([]string{"-h", "10.0.0.1"}), ShouldBeNil)
+		So(retentionSet(fs), ShouldBeFalse)
+		So(fs.Parse([]string{"-keephourly", "90d"}), ShouldBeNil)
+		So(retentionSet(fs), ShouldBeTrue)
+	})
+	Convey("hostRow()", t, func() {
+		now := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+		stats := &dal.IPStats{IP: "10.0.0.1", LastPingTime: now, Received: 1000, Lost: 20}
+		Convey("should show the last hour & day", func() {
+			stats.AddPing(now.Add(-2*time.Hour), 40)
+			stats.AddPing(now.Add(-time.Minute), 10)
+			stats.AddPing(now, 20)
+			stats.AddPing(now, -1)
+			So(hostRow(stats, now), ShouldResemble, []string{
+				"10.0.0.1", now.Local().Format(timeFormat), "1003", "21",
+				"3", "33.3%", "15 ms", "10 ms", "20 ms",
+				"4", "25.0%", "23 ms", "10 ms", "40 ms",
+			})
+		})
+		Convey("should show - w/o pings", func() {
+			stats.AddPing(now, -1)
+			row := hostRow(stats, now.Add(2*time.Hour))
+			So(row[4:], ShouldResemble, []string{
+				"0", "-", "-", "-", "-",
+				"1", "100.0%", "-", "-", "-",
+			})
+		})
+	})
+}
+
+func Test_commands_integration(t *testing.T) {
+	Convey("migrate()", t, func() {
+		dir, err := ioutil.TempDir("", "pinghist")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		// a db w/o a schema version, like the ones of older versions of pinghist
+		db := openDB(filepath.Join(dir, "pinghist.db"))
+		defer db.Close()
+		So(db.Put("schema_version", []byte("1"), "meta"), ShouldBeNil)
+		So(db.SavePing("10.0.0.1", time.Now(), 1), ShouldBeNil)
+
+		Convey("should only list the migrations w/ dry run", func() {
+			So(migrate(db, true, ""), ShouldBeNil)
+			version, err := db.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+		})
+		Convey("shouldn't write to the db w/ dry run", func() {
+			path := db.Path()
+			db.Close()
+			for _, p := range []string{path, filepath.Join(dir, "empty.db")} {
+				if p != path {
+					So(ioutil.WriteFile(p, nil, 0600), ShouldBeNil)
+				}
+				before, err := ioutil.ReadFile(p)
+				So(err, ShouldBeNil)
+				stat, err := os.Stat(p)
+				So(err, ShouldBeNil)
+
+				So(migrateDryRun(p), ShouldBeNil)
+
+				after, err := ioutil.ReadFile(p)
+				So(err, ShouldBeNil)
+				So(after, ShouldResemble, before)
+				statAfter, err := os.Stat(p)
+				So(err, ShouldBeNil)
+				So(statAfter.ModTime(), ShouldHappenOnOrBetween, stat.ModTime(), stat.ModTime())
+			}
+		})
+		Convey("should back the db up & migrate it to the latest version", func() {
+			backup := filepath.Join(dir, "backup.db")
+			So(migrate(db, false, backup), ShouldBeNil)
+			version, err := db.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, dal.SchemaVersion)
+			_, err = os.Stat(backup)
+			So(err, ShouldBeNil)
+
+			So(migrate(db, false, backup), ShouldBeNil) // up to date, no backup needed
+		})
+	})
+}
+
+func Test_prune_integration(t *testing.T) {
+	Convey("prune()", t, func() {
+		dir, err := ioutil.TempDir("", "pinghist")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		db := openDB(filepath.Join(dir, "pinghist.db"))
+		defer db.Close()
+		old := time.Now().AddDate(0, 0, -2)
+		So(db.SavePing("10.0.0.1", old, 1), ShouldBeNil)
+		So(db.SavePing("10.0.0.1", time.Now(), 1), ShouldBeNil)
+		keep := dal.Retention{Pings: 24 * time.Hour}
+
+		Convey("should leave the pings w/ dry run", func() {
+			So(prune(db, keep, true), ShouldBeNil)
+			groups, err := db.GetPings("10.0.0.1", old, old.Add(time.Minute), time.Minute)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 1)
+		})
+		Convey("should delete the pings older than the retention", func() {
+			So(prune(db, keep, false), ShouldBeNil)
+			groups, err := db.GetPings("10.0.0.1", old, old.Add(time.Minute), time.Minute)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 0)
+			groups, err = db.GetPings("10.0.0.1", time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 2*time.Minute)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 1)
+		})
+	})
+
+	Convey("formatPruned()", t, func() {
+		start := time.Date(2015, time.January, 3, 17, 0, 0, 0, time.Local)
+		pr := &dal.PrunedRange{Series: "10.0.0.1", Resolution: "pings", Start: start, End: start.Add(time.Hour), Keys: 60}
+		So(formatPruned(pr, false), ShouldEqual, "Pruned 60 minutes of pings of 10.0.0.1 from 01/03/2015 05:00 pm to 01/03/2015 06:00 pm")
+		So(formatPruned(pr, true), ShouldStartWith, "Would prune 60")
+	})
+}
@@ -3,10 +3,11 @@ package dal
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"math"
+	"os"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -14,9 +15,11 @@ import (
 
 const (
 	// Bolt errors
-	BucketNotFoundError = "could not find bucket"
-	KeyNotFoundError    = "could not find key"
-	InvalidKeyError     = "Could not parse key"
+	DatabaseNotOpenError = "database is not open, call Open() first"
+	DatabaseLockedError  = "timed out waiting for the database lock, is another pinghist process using it?"
+	BucketNotFoundError  = "could not find bucket"
+	KeyNotFoundError     = "could not find key"
+	InvalidKeyError      = "Could not parse key"
 	// SavePing Errors
 	IPRequiredError             = "IP can't be empty string"
 	ResponseTimeOutOfRangeError = "Response time must be >= -1"
@@ -25,41 +28,142 @@ const (
 	InvalidByteLength        = "invaid # of bytes"
 	// GetPings Errors
 	KeyTimestampParsingError = "Can't parse key timestamp"
+	GroupByOutOfRangeError   = "groupBy must be > 0"
+	TooManyGroupsError       = "too many groups, use a larger groupBy or a shorter range"
 )
 
+// MaxGroups is the most groups GetPings returns, every groupBy between start &
+// the last ping is a group, even when it's empty
+const MaxGroups = 100000
+
+// OpenTimeout is how long Open waits for another process to release the db
+var OpenTimeout = 1 * time.Second
+
 type DAL struct {
-	path     string
+	path     string // dir of the db file, "" is the current dir
 	fileName string
+	db       *bolt.DB // nil until Open is called
 	ipStatsBucket,
-	pingsBucket string
+	historyBucket,
+	metaBucket,
+	targetsBucket,
+	targetIDsBucket,
+	hourlyBucket,
+	dailyBucket,
+	statsSlotsBucket,
+	pingsBucket,
+	legacyPingsBucket string // where pings were kept before schema version 3
 }
 
 // NewDAL creates a new Data Access Layer with defaults for all fields
 func NewDAL() *DAL {
 	dal := &DAL{
-		path:          "",
-		fileName:      "pinghist.db",
-		pingsBucket:   "pings_by_minute",
-		ipStatsBucket: "ip_stats",
+		path:             "",
+		fileName:         DefaultFileName,
+		pingsBucket:      "pings",
+		ipStatsBucket:    "ip_stats",
+		historyBucket:    "history",
+		metaBucket:       "meta",
+		targetsBucket:    "targets",
+		targetIDsBucket:  "target_ids",
+		hourlyBucket:     "rollups_by_hour",
+		dailyBucket:      "rollups_by_day",
+		statsSlotsBucket: "stats_slots",
+
+		legacyPingsBucket: "pings_by_minute",
 	}
 	return dal
 }
 
-func (dal *DAL) Buckets() []string {
-	return []string{dal.pingsBucket, dal.ipStatsBucket}
+// Open opens the bolt db, the handle is kept open and shared by every method
+// until Close is called. Bolt locks the file, so only one process can have the
+// db open at a time, Open gives up after OpenTimeout.
+func (dal *DAL) Open() error {
+	if dal.path != "" {
+		if err := os.MkdirAll(dal.path, 0700); err != nil {
+			return fmt.Errorf("dal.Open: %s", err)
+		}
+	}
+	if err := dal.open(&bolt.Options{Timeout: OpenTimeout}); err != nil {
+		return fmt.Errorf("dal.Open: %s", err)
+	}
+	return nil
+}
+
+// OpenReadOnly opens an existing db w/o ever writing to it, ex: for a dry run.
+// Other processes can read it too, but not write to it until it's closed.
+func (dal *DAL) OpenReadOnly() error {
+	if err := dal.open(&bolt.Options{Timeout: OpenTimeout, ReadOnly: true}); err != nil {
+		return fmt.Errorf("dal.OpenReadOnly: %s", err)
+	}
+	return nil
 }
 
-func (dal *DAL) CreateBuckets() {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
+func (dal *DAL) open(opts *bolt.Options) error {
+	db, err := bolt.Open(dal.Path(), 0600, opts)
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf("%s: %s", DatabaseLockedError, dal.Path())
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	dal.db = db
+	return nil
+}
+
+// Close closes the bolt db, it's safe to call Close more than once
+func (dal *DAL) Close() error {
+	if dal.db == nil {
+		return nil
 	}
-	defer db.Close()
-	boltBuckets := []string{dal.pingsBucket, dal.ipStatsBucket}
+	err := dal.db.Close()
+	dal.db = nil
+	return err
+}
+
+// update runs fn in a read-write transaction on the open db
+func (dal *DAL) update(fn func(*bolt.Tx) error) error {
+	if dal.db == nil {
+		return errors.New(DatabaseNotOpenError)
+	}
+	return dal.db.Update(fn)
+}
+
+// Update runs fn in a read-write transaction on the open db, everything fn saves
+// w/ the WithTransaction funcs is saved together or not at all
+func (dal *DAL) Update(fn func(*bolt.Tx) error) error {
+	return dal.update(fn)
+}
+
+// view runs fn in a read-only transaction on the open db
+func (dal *DAL) view(fn func(*bolt.Tx) error) error {
+	if dal.db == nil {
+		return errors.New(DatabaseNotOpenError)
+	}
+	return dal.db.View(fn)
+}
 
-	db.Update(func(tx *bolt.Tx) error {
-		// create buckets
-		for _, bucketName := range boltBuckets {
+func (dal *DAL) Buckets() []string {
+	return []string{dal.pingsBucket, dal.ipStatsBucket, dal.historyBucket, dal.metaBucket,
+		dal.targetsBucket, dal.targetIDsBucket, dal.hourlyBucket, dal.dailyBucket, dal.statsSlotsBucket}
+}
+
+// CreateBuckets creates the buckets that don't exist yet. A new db is stamped w/
+// the latest SchemaVersion, the dbs of older versions of pinghist are left at
+// the version they're at until they're migrated, see Migrate. Every version of
+// pinghist has had the ip_stats bucket, so a db w/o it is new
+func (dal *DAL) CreateBuckets() error {
+	err := dal.update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(dal.ipStatsBucket)) == nil {
+			meta, err := tx.CreateBucketIfNotExists([]byte(dal.metaBucket))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+			if err := putSchemaVersion(meta, SchemaVersion); err != nil {
+				return err
+			}
+		}
+		for _, bucketName := range dal.Buckets() {
 			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 			if err != nil {
 				return fmt.Errorf("create bucket: %s", err)
@@ -68,114 +172,200 @@ func (dal *DAL) CreateBuckets() {
 
 		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("dal.CreateBuckets: %s", err)
+	}
+	return nil
 }
 
 // SavePingWithTransaction will save a ping to bolt using the given bolt transaction
 func (dal *DAL) SavePingWithTransaction(ip string, startTime time.Time, responseTime float32, tx *bolt.Tx) error {
+	_, err := dal.savePingResWithTransaction(ip, startTime, NewPingRes(startTime, responseTime), tx)
+	return err
+}
+
+// savePingResWithTransaction appends pr to the minute of startTime & adds it to
+// its rollups, it returns the key of the minute
+func (dal *DAL) savePingResWithTransaction(ip string, startTime time.Time, pr *PingRes, tx *bolt.Tx) ([]byte, error) {
 	pings := tx.Bucket([]byte(dal.pingsBucket))
 	if pings == nil {
-		return fmt.Errorf("dal.SavePingWithTransaction: %s %s", BucketNotFoundError, dal.pingsBucket)
+		return nil, fmt.Errorf("dal.SavePingWithTransaction: %s %s", BucketNotFoundError, dal.pingsBucket)
 	}
 
-	key := GetPingKey(ip, startTime)
-	val := SerializePingRes(startTime, responseTime)
+	val, err := pr.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("dal.SavePingWithTransaction: %s", err)
+	}
+	id, err := dal.createTargetID(tx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("dal.SavePingWithTransaction: %s", err)
+	}
+	key := GetPingKey(id, startTime)
 
 	v := pings.Get(key)
 	if v != nil {
+		// the minute was saved w/ older records, which can't be mixed w/ v3 records
+		if v, err = upgradePingRes(v); err != nil {
+			return nil, fmt.Errorf("dal.SavePingWithTransaction: %s", err)
+		}
 		// Don't change the byte array that boltdb gives us, make our own new one
 		// + the extra room for the next value
-		newVal := make([]byte, 0, len(val)+PingResByteCount)
+		newVal := make([]byte, 0, len(v)+len(val)+PingResV3ByteCount)
 		newVal = append(newVal, v...)
 		newVal = append(newVal, val...)
 		val = newVal
 	}
 
-	err := pings.Put(key, val)
+	err = pings.Put(key, val)
 	if err != nil {
-		return fmt.Errorf("dal.SavePingWithTransaction: error writing key: %s", err)
+		return nil, fmt.Errorf("dal.SavePingWithTransaction: error writing key: %s", err)
 	}
 
-	return nil
+	var r Rollup
+	r.add(pr)
+	if err := dal.saveRollups(tx, id, startTime, &r); err != nil {
+		return nil, fmt.Errorf("dal.SavePingWithTransaction: %s", err)
+	}
+	return key, nil
 }
 
 // SavePing will save a ping to bolt
-// Pings are keyed by minute, so, every minute can store a max of 60 pings (1 p/sec)
-// The pings within a minute are stored as an array of bytes for fast
-// serialization/deserialization and to minimize the size of the value (see SerializePingRes)
+// Pings are keyed by minute, the pings within a minute are appended to an array of bytes
+// for fast serialization/deserialization and to minimize the size of the value (see PingRes)
+// There's no limit on the # of pings in a minute, so sub-second intervals work, each
+// ping records the ms it started at
 func (dal *DAL) SavePing(ip string, startTime time.Time, responseTime float32) error {
-	if len(ip) == 0 {
-		return fmt.Errorf("dal.SavePing: %s", IPRequiredError)
+	return dal.SavePingRes(ip, startTime, NewPingRes(startTime, responseTime))
+}
+
+// SaveLostPing saves a lost ping w/ the reason it was lost, code is the ICMP
+// code when it was lost to an ICMP error (0 otherwise)
+func (dal *DAL) SaveLostPing(ip string, startTime time.Time, reason FailReason, code uint8) error {
+	return dal.SavePingRes(ip, startTime, NewLostPingRes(startTime, reason, code))
+}
+
+// SavePingRes is SavePing w/ everything a record holds, ex: the TTL of the reply
+func (dal *DAL) SavePingRes(ip string, startTime time.Time, pr *PingRes) error {
+	if err := validatePingRes(ip, pr); err != nil {
+		return fmt.Errorf("dal.SavePing: %s", err)
 	}
-	if responseTime < -1 {
-		return fmt.Errorf("dal.SavePing: %s", ResponseTimeOutOfRangeError)
+	err := dal.update(func(tx *bolt.Tx) error {
+		return dal.savePingResAndStats(ip, startTime, pr, tx)
+	})
+	if err != nil {
+		return fmt.Errorf("dal.SavePing: %s", err)
+	}
+	return nil
+}
+
+// SavePingResWithTransaction is SavePingRes using the given bolt transaction, see Update
+func (dal *DAL) SavePingResWithTransaction(ip string, startTime time.Time, pr *PingRes, tx *bolt.Tx) error {
+	if err := validatePingRes(ip, pr); err != nil {
+		return fmt.Errorf("dal.SavePingResWithTransaction: %s", err)
+	}
+	if err := dal.savePingResAndStats(ip, startTime, pr, tx); err != nil {
+		return fmt.Errorf("dal.SavePingResWithTransaction: %s", err)
+	}
+	return nil
+}
+
+// validatePingRes returns error when pr can't be saved to ip
+func validatePingRes(ip string, pr *PingRes) error {
+	if pr.ResTime < -1 {
+		return errors.New(ResponseTimeOutOfRangeError)
 	}
+	if len(ip) == 0 {
+		return errors.New(IPRequiredError)
+	}
+	return nil
+}
 
-	db, err := bolt.Open(dal.fileName, 0600, nil)
+// savePingResAndStats saves pr & counts it in the IPStats of ip
+func (dal *DAL) savePingResAndStats(ip string, startTime time.Time, pr *PingRes, tx *bolt.Tx) error {
+	key, err := dal.savePingResWithTransaction(ip, startTime, pr, tx)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		statsBucket := tx.Bucket([]byte(dal.ipStatsBucket))
-		// update the stats for this IP
-		stats, err := dal.GetIPStatsFromBucket(ip, statsBucket)
-		if err != nil {
-			return err
-		}
 
-		pingKey := string(GetPingKey(ip, startTime))
-		if stats == nil {
-			stats = &IPStats{
-				IP:            ip,
-				FirstPingKey:  pingKey,
-				FirstPingTime: startTime,
-				LastPingKey:   pingKey,
-				LastPingTime:  startTime,
-			}
-		} else {
-			stats.LastPingKey = pingKey
-			stats.LastPingTime = startTime
-		}
+	statsBucket := tx.Bucket([]byte(dal.ipStatsBucket))
+	// update the stats for this IP
+	stats, err := dal.GetIPStatsFromBucket(ip, statsBucket)
+	if err != nil {
+		return err
+	}
 
-		err = dal.SaveIPStatsInBucket(stats, statsBucket)
-		if err != nil {
-			return err
+	pingKey := FormatPingKey(key)
+	if stats == nil {
+		stats = &IPStats{
+			IP:            ip,
+			FirstPingKey:  pingKey,
+			FirstPingTime: startTime,
+			LastPingKey:   pingKey,
+			LastPingTime:  startTime,
 		}
-
-		err = dal.SavePingWithTransaction(ip, startTime, responseTime, tx)
-		if err != nil {
-			return err
+	} else {
+		if stats.FirstPingKey == "" { // every ping was pruned, see pruneIPStats
+			stats.FirstPingKey = pingKey
 		}
+		stats.LastPingKey = pingKey
+		stats.LastPingTime = startTime
+	}
+	resTime := Round(float64(pr.ResTime), .5, 3)
+	stats.countPing(resTime)
+	id, _, err := ParsePingKey(key)
+	if err != nil {
+		return err
+	}
+	if err := dal.addPingSlots(tx, id, startTime, resTime); err != nil {
+		return err
+	}
 
-		return nil
-	})
-
-	return err
+	return dal.SaveIPStatsInBucket(stats, statsBucket)
 }
 
-// GetPingKey returns a key for the given ip and time, seconds and nanoseconds are removed
-// from pingStartTime in order to group pings by minute
-func GetPingKey(ip string, pingStartTime time.Time) []byte {
-	keyTimestamp := time.Date(pingStartTime.Year(), pingStartTime.Month(),
-		pingStartTime.Day(), pingStartTime.Hour(), pingStartTime.Minute(), 0, 0, pingStartTime.Location())
-
-	key := fmt.Sprintf("%s_%s", ip, keyTimestamp.Format(time.RFC3339))
+// PingKeyByteCount is the size of a ping key, a target ID + a minute
+const PingKeyByteCount = TargetIDByteCount + 4
+
+// GetPingKey returns the key of the minute of pingStartTime for the series w/
+// the given target ID, see createTargetID. The minute is the unix time in minutes,
+// big-endian, so the keys of a series sort by time no matter what time zone the
+// pings were saved in, or whether DST was in effect.
+// Format: 8 bytes
+// | 4 bytes   | 4 bytes
+// | target ID | unix minute
+func GetPingKey(id uint32, pingStartTime time.Time) []byte {
+	key := getPingKeyPrefix(id)[:PingKeyByteCount]
+	minute := pingStartTime.Unix() / 60
+	if pingStartTime.Unix() < 0 {
+		minute = 0 // keys can't go before 1970, this only matters to queries
+	} else if minute > math.MaxUint32 {
+		minute = math.MaxUint32
+	}
+	binary.BigEndian.PutUint32(key[TargetIDByteCount:], uint32(minute))
+	return key
+}
 
-	return []byte(key)
+// getPingKeyPrefix returns the prefix shared by every ping key of the given
+// target, w/ room for the minute
+func getPingKeyPrefix(id uint32) []byte {
+	key := make([]byte, TargetIDByteCount, PingKeyByteCount)
+	binary.BigEndian.PutUint32(key, id)
+	return key
 }
 
-func ParsePingKey(key []byte) (ip string, baseTime time.Time, err error) {
-	keyParts := bytes.Split(key, []byte("_"))
-	if len(keyParts) != 2 {
-		return "", time.Time{}, fmt.Errorf("ParsePingKey(): %s", InvalidKeyError)
+// ParsePingKey does the opposite of GetPingKey, baseTime is in UTC
+func ParsePingKey(key []byte) (id uint32, baseTime time.Time, err error) {
+	if len(key) != PingKeyByteCount {
+		return 0, time.Time{}, fmt.Errorf("ParsePingKey(): %s", InvalidKeyError)
 	}
-	ip = string(keyParts[0])
-	baseTime, err = time.Parse(time.RFC3339, string(keyParts[1]))
-	if err != nil {
-		return "", time.Time{}, err
-	}
-	return ip, baseTime, nil
+	id = binary.BigEndian.Uint32(key)
+	minute := binary.BigEndian.Uint32(key[TargetIDByteCount:])
+	return id, time.Unix(int64(minute)*60, 0).UTC(), nil
+}
+
+// FormatPingKey returns key as text, IPStats keep ping keys in this format
+func FormatPingKey(key []byte) string {
+	return hex.EncodeToString(key)
 }
 
 const (
@@ -184,14 +374,15 @@ const (
 	PingResTimeByteCount      = 4 // float32
 )
 
-// SerializePingRes converts startTime and resTime to a 7 byte array
+// SerializePingRes converts startTime and resTime to a 7 byte array, a v1
+// record. Pings are saved as v2 records, see PingRes
 // startTime is the time the ping was initated
 // resTime is the amount of time it took to return the ping packet
 // endTime = baseKey + startTime + resTime
 // Format: 7 bytes
-// | 1 byte  | 1 byte  | 4 bytes | 1 byte
-// | seconds | padding | resTime | padding
-// TODO Convert to PingRes struct w/ method MarshalBinary()
+// | 1 byte  | 1 byte     | 4 bytes | 1 byte
+// | seconds | FailReason | resTime | ICMP code
+// The reason & code are 0 for received pings & pings lost before they were recorded
 func SerializePingRes(startTime time.Time, resTime float32) []byte {
 	buff := make([]byte, PingResByteCount)
 	floatBytes := Float32bytes(resTime)
@@ -205,6 +396,29 @@ func SerializePingRes(startTime time.Time, resTime float32) []byte {
 	return buff
 }
 
+// SerializeLostPingRes is SerializePingRes for a lost ping (resTime = -1), w/ the
+// reason it was lost & the code of the ICMP error it was lost to
+func SerializeLostPingRes(startTime time.Time, reason FailReason, code uint8) []byte {
+	buff := SerializePingRes(startTime, -1)
+	buff[pingResReasonOffset] = byte(reason)
+	buff[pingResCodeOffset] = code
+	return buff
+}
+
+const (
+	pingResReasonOffset = 1
+	pingResCodeOffset   = 6
+)
+
+// DeserializeFailReason returns the reason a serialized ping (of any version)
+// was lost & the ICMP code it was lost to, see SerializeLostPingRes
+func DeserializeFailReason(data []byte) (FailReason, uint8) {
+	if len(data) == 0 || len(data) != pingResSize(data) {
+		return ReasonNone, 0
+	}
+	return FailReason(data[pingResReasonOffset]), data[pingResCodeOffset]
+}
+
 // DeserializePingRes does the opposite of SerializePingRes
 func DeserializePingRes(data []byte) (uint8, float64, error) {
 	if len(data) != PingResByteCount {
@@ -226,89 +440,114 @@ func StripNano(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
 }
 
+// StripMicro removes everything below the ms from t, the precision pings are saved w/
+func StripMicro(t time.Time) time.Time {
+	ns := t.Nanosecond() / int(time.Millisecond) * int(time.Millisecond)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), ns, t.Location())
+}
+
 // GetPings returns pings between the start time and end time, for the given IP,
 // grouped by the given duration.
-// Start and end time should be in UTC
-// gruupBy can be any valid time.Duration, ex: 1 * time.Hour
+// Start and end time can be in any location, pings are keyed by UTC minute
+// gruupBy can be any valid time.Duration, ex: 1 * time.Hour, or 100 * time.Millisecond.
+// Pings saved before v3 records only know the second they started in
+// When groupBy is a multiple of an hour or a day & start is on one, the groups are
+// made of the rollups of each hour or day (see Rollup), only the pings after the
+// last full hour or day before end are read one by one
 // Returns a summary for each PingGroup with avg and std deviation
 func (dal *DAL) GetPings(ipAddress string, start, end time.Time, groupBy time.Duration) ([]*PingGroup, error) {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close()
+	return dal.getPings(ipAddress, start, end, groupBy, true)
+}
 
-	// we don't care about nanoseconds when comparing to our group start/end times
-	groups := make([]*PingGroup, 0, 5)
-	start = StripNano(start)
-	end = StripNano(end)
-	// fmt.Printf("%s - %s\n", start.Format("01/02/06 3:04:05 pm"), end.Format("01/02/06 3:04:05 pm"))
+// getPings is GetPings, w/o useRollups every ping is read
+func (dal *DAL) getPings(ipAddress string, start, end time.Time, groupBy time.Duration, useRollups bool) ([]*PingGroup, error) {
+	// groups would never end
+	if groupBy <= 0 {
+		return nil, fmt.Errorf("dal.GetPings: %s", GroupByOutOfRangeError)
+	}
+	if end.Sub(start)/groupBy > MaxGroups {
+		return nil, fmt.Errorf("dal.GetPings: %s, %s from %s to %s is more than %d", TooManyGroupsError, groupBy, start, end, MaxGroups)
+	}
+	// pings are saved w/ ms precision, anything finer doesn't matter when comparing to our group start/end times
+	start = StripMicro(start)
+	end = StripMicro(end)
+	g := newGrouper(start, groupBy)
 
-	err = db.View(func(tx *bolt.Tx) error {
-		pings := tx.Bucket([]byte("pings_by_minute"))
+	err := dal.view(func(tx *bolt.Tx) error {
+		pings := tx.Bucket([]byte(dal.pingsBucket))
 		if pings == nil {
 			return fmt.Errorf("dal.GetPings: %s: %s", BucketNotFoundError, dal.pingsBucket)
 		}
-		c := pings.Cursor()
-
-		pre := []byte(ipAddress)
-		min := GetPingKey(ipAddress, start)
-		max := GetPingKey(ipAddress, end)
-		currGroup := NewPingGroup(start, start.Add(groupBy))
-
-		// fmt.Printf("GRPstart: %s \nGRP  end: %s\n", currGroup.Start.Format(time.RFC3339Nano), currGroup.End.Format(time.RFC3339Nano))
-		// fmt.Printf("min     : %s \n", min)
-		// fmt.Printf("max     : %s \n", max)
+		id, ok, err := dal.getTargetID(tx, ipAddress)
+		if err != nil {
+			return fmt.Errorf("dal.GetPings: %s", err)
+		}
+		if !ok {
+			return nil // nothing was ever saved to the series
+		}
 
-		for k, v := c.Seek(min); k != nil && bytes.HasPrefix(k, pre) && bytes.Compare(k, max) >= -1; k, v = c.Next() {
-			_, baseTime, err := ParsePingKey(k)
-			// fmt.Printf("baseTime: %s\n", baseTime.Format(time.RFC3339Nano))
-			if err != nil {
-				return fmt.Errorf("dal.GetPings: %s: %s", KeyTimestampParsingError, err)
+		pingsStart := start
+		if level, ok := dal.rollupLevelFor(start, groupBy); ok && useRollups {
+			if pingsStart = end.Truncate(level.period); pingsStart.Before(start) {
+				pingsStart = start
 			}
-
-			for i := 0; i < len(v); i += PingResByteCount {
-				secondsOffset, resTime, err := DeserializePingRes(v[i : i+PingResByteCount])
-				if err != nil {
-					return fmt.Errorf("dal.GetPings: %s", err)
-				}
-				pingTime := baseTime.Add(time.Duration(secondsOffset) * time.Second)
-				// fmt.Printf("pingTime: %s\n", pingTime.Format(time.RFC3339Nano))
-
-				// Make sure we don't go beyond our end time
-				if pingTime.Equal(end) || pingTime.After(end) {
-					break
-				}
-
-				// Keep creating groups until one fits our bucket, this is here
-				// because it's possible for a person to query a start time before there is any data
-				// So return empty groups to the consumer (no pings), there is definitely a better way.
-				// Why 50... because I pulled it out of my butt. Infinite loop protection, BRO
-				for x := 0; x < 50; x++ {
-					if pingTime.Equal(currGroup.Start) || (pingTime.After(currGroup.Start) && pingTime.Before(currGroup.End)) {
-						currGroup.addResTime(resTime)
-						break
-					} else {
-						currGroup.calcAvgAndStdDev()
-						groups = append(groups, currGroup)
-
-						currGroup = NewPingGroup(currGroup.End, currGroup.End.Add(groupBy))
-						// fmt.Printf("%s - %s\n", currGroup.Start.Format("01/02 3:04:05 pm"), currGroup.End.Format("01/02 3:04:05 pm"))
-					}
-				}
+			if err := dal.readRollups(tx, level, id, start, pingsStart, g); err != nil {
+				return fmt.Errorf("dal.GetPings: %s", err)
 			}
 		}
-
-		currGroup.calcAvgAndStdDev()
-		groups = append(groups, currGroup)
-		return nil
+		return readPings(pings, id, pingsStart, end, g)
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return groups, nil
+	return g.done(), nil
+}
+
+// readPings adds the pings of the target w/ the given ID between start and end to g
+func readPings(pings *bolt.Bucket, id uint32, start, end time.Time, g *grouper) error {
+	c := pings.Cursor()
+	pre := getPingKeyPrefix(id)
+	min := GetPingKey(id, start)
+	max := GetPingKey(id, end)
+
+	for k, v := c.Seek(min); k != nil && bytes.HasPrefix(k, pre) && bytes.Compare(k, max) <= 0; k, v = c.Next() {
+		_, baseTime, err := ParsePingKey(k)
+		if err != nil {
+			return fmt.Errorf("dal.GetPings: %s: %s", KeyTimestampParsingError, err)
+		}
+
+		size := pingResSize(v)
+		for i := 0; i < len(v); i += size {
+			if i+size > len(v) {
+				return fmt.Errorf("dal.GetPings: %s", InvalidByteLength)
+			}
+			var res PingRes
+			if err := res.UnmarshalBinary(v[i : i+size]); err != nil {
+				return fmt.Errorf("dal.GetPings: %s", err)
+			}
+			resTime := Round(float64(res.ResTime), .5, 3)
+			pingTime := baseTime.Add(res.Offset())
+
+			// Make sure we don't go beyond our end time
+			if pingTime.Equal(end) || pingTime.After(end) {
+				break
+			}
+			// the minute of start can have pings before it
+			if pingTime.Before(start) {
+				continue
+			}
+
+			pg := g.group(pingTime)
+			if pg == nil {
+				continue // out of order
+			}
+			pg.addPingRes(resTime, res.Reason)
+			pg.addTTL(res.TTL)
+		}
+	}
+	return nil
 }
 
 func Round(val float64, roundOn float64, places int) (newVal float64) {
@@ -341,10 +580,8 @@ func Float32bytes(float float32) []byte {
 }
 
 // Generic method to Put a key into bolt. Used for testing
-func (dal *DAL) Put(key string, val []byte, bucket string) {
-	db, _ := bolt.Open(dal.fileName, 0600, nil)
-	defer db.Close()
-	db.Update(func(tx *bolt.Tx) error {
+func (dal *DAL) Put(key string, val []byte, bucket string) error {
+	return dal.update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucket))
 		return bucket.Put([]byte(key), val)
 	})
@@ -352,10 +589,8 @@ func (dal *DAL) Put(key string, val []byte, bucket string) {
 
 // Generic method to Get a key from bolt. Used for testing
 func (dal *DAL) Get(key string, bucket string) []byte {
-	db, _ := bolt.Open(dal.fileName, 0600, nil)
-	defer db.Close()
 	var returnB []byte
-	db.Update(func(tx *bolt.Tx) error {
+	dal.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucket))
 		b := bucket.Get([]byte(key))
 		returnB = make([]byte, 0, len(b))
@@ -366,15 +601,8 @@ func (dal *DAL) Get(key string, bucket string) []byte {
 	return returnB
 }
 
-func (dal *DAL) DeleteBuckets() {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	db.Update(func(tx *bolt.Tx) error {
-		// create buckets
+func (dal *DAL) DeleteBuckets() error {
+	return dal.update(func(tx *bolt.Tx) error {
 		for _, name := range dal.Buckets() {
 			tx.DeleteBucket([]byte(name))
 		}
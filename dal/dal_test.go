@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"math/rand"
@@ -21,6 +22,56 @@ func Test_dal_unit(t *testing.T) {
 			bytes := SerializePingRes(startTime, resTime)
 			So(len(bytes), ShouldEqual, PingResByteCount)
 		})
+		Convey("Should store the reason & ICMP code of a lost ping in the padding", func() {
+			startTime := time.Date(2015, time.January, 1, 12, 30, 5, 0, time.UTC)
+			bytes := SerializeLostPingRes(startTime, ReasonNetUnreachable, 9)
+			secondsOffset, resTime, err := DeserializePingRes(bytes)
+			So(err, ShouldBeNil)
+			So(secondsOffset, ShouldEqual, 5)
+			So(resTime, ShouldEqual, -1)
+			reason, code := DeserializeFailReason(bytes)
+			So(reason, ShouldEqual, ReasonNetUnreachable)
+			So(code, ShouldEqual, 9)
+
+			reason, code = DeserializeFailReason(SerializePingRes(startTime, 1.0))
+			So(reason, ShouldEqual, ReasonNone)
+			So(code, ShouldEqual, 0)
+		})
+	})
+
+	Convey("ParsePingKey()", t, func() {
+		startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+
+		Convey("should parse the target ID & minute of a key", func() {
+			for _, id := range []uint32{1, 2, 1<<32 - 1} {
+				parsedID, baseTime, err := ParsePingKey(GetPingKey(id, startTime.Add(59*time.Second)))
+				So(err, ShouldBeNil)
+				So(parsedID, ShouldEqual, id)
+				So(baseTime, ShouldHappenOnOrBetween, startTime, startTime)
+			}
+		})
+		Convey("should return error when the key isn't 8 bytes", func() {
+			_, _, err := ParsePingKey([]byte("127.0.0.1_2015-01-01T12:30:00Z"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, InvalidKeyError)
+		})
+	})
+
+	Convey("GetPingKey()", t, func() {
+		Convey("should key the same minute the same in every location", func() {
+			startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+			So(GetPingKey(1, startTime), ShouldResemble, GetPingKey(1, startTime.In(time.FixedZone("", -7*60*60))))
+		})
+		Convey("should sort keys by time across a DST change", func() {
+			ny, err := time.LoadLocation("America/New_York")
+			So(err, ShouldBeNil)
+			// clocks go back from 2am EDT to 1am EST, 1:30 happens twice
+			edt := time.Date(2015, time.November, 1, 1, 30, 0, 0, ny)
+			est := edt.Add(time.Hour)
+			So(est.Hour(), ShouldEqual, 1)
+			So(bytes.Compare(GetPingKey(1, edt), GetPingKey(1, est)), ShouldEqual, -1)
+			So(bytes.Compare(GetPingKey(1, est), GetPingKey(1, edt.Add(61*time.Minute))), ShouldEqual, -1)
+		})
 	})
 
 	Convey("DeserializePingRes()", t, func() {
@@ -54,12 +105,38 @@ func Test_dal_integration(t *testing.T) {
 		// These are run before every sub test below, so every test has a brand new dal and
 		// empty set of buckets
 		dal := NewDAL()
+		err := dal.Open()
+		So(err, ShouldBeNil)
 		dal.DeleteBuckets()
 		dal.CreateBuckets()
 		Reset(func() {
+			dal.Close()
 			os.Remove(dal.fileName)
 		})
 
+		Convey("Open()", func() {
+			Convey("should return error when it can't open db", func() {
+				d := NewDAL()
+				d.fileName = ""
+				err := d.Open()
+				So(err, ShouldNotBeNil)
+			})
+			Convey("should time out when the db is already open", func() {
+				OpenTimeout = 10 * time.Millisecond
+				Reset(func() {
+					OpenTimeout = 1 * time.Second
+				})
+				d := NewDAL()
+				err := d.Open()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, DatabaseLockedError)
+			})
+			Convey("Close() should be safe to call twice", func() {
+				So(dal.Close(), ShouldBeNil)
+				So(dal.Close(), ShouldBeNil)
+			})
+		})
+
 		Convey("SavePing()", func() {
 			ip := "127.0.0.1"
 			startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC) // 2015-01-01 12:30:00 +0000 UTC
@@ -70,7 +147,7 @@ func Test_dal_integration(t *testing.T) {
 				So(err, ShouldBeNil)
 
 				keys := getAllPingKeys(dal)
-				So(keys[0], ShouldEqual, string(GetPingKey(ip, startTime)))
+				So(keys[0], ShouldEqual, string(getPingKey(dal, ip, startTime)))
 			})
 			Convey("should create 1 key when 2 pings are < 1 minute apart", func() {
 				startTime2 := startTime.Add(1 * time.Second) // add a second
@@ -82,7 +159,7 @@ func Test_dal_integration(t *testing.T) {
 				So(err, ShouldBeNil)
 
 				keys := getAllPingKeys(dal)
-				So(keys[0], ShouldEqual, string(GetPingKey(ip, startTime)))
+				So(keys[0], ShouldEqual, string(getPingKey(dal, ip, startTime)))
 			})
 			Convey("should create 2 keys when 2 pings are > 1 minute apart", func() {
 				startTime2 := startTime.Add(1 * time.Minute) // add a minute
@@ -94,8 +171,8 @@ func Test_dal_integration(t *testing.T) {
 				So(err, ShouldBeNil)
 
 				keys := getAllPingKeys(dal)
-				So(keys[0], ShouldEqual, string(GetPingKey(ip, startTime)))
-				So(keys[1], ShouldEqual, string(GetPingKey(ip, startTime2)))
+				So(keys[0], ShouldEqual, string(getPingKey(dal, ip, startTime)))
+				So(keys[1], ShouldEqual, string(getPingKey(dal, ip, startTime2)))
 			})
 			Convey("should create entry in ip_stats bucket for the given IP", func() {
 				err := dal.SavePing(ip, startTime, 1.0)
@@ -111,7 +188,7 @@ func Test_dal_integration(t *testing.T) {
 				ipStats, err := dal.GetIPStats(ip)
 				So(err, ShouldBeNil)
 				So(ipStats, ShouldNotBeNil)
-				pingKey := string(GetPingKey(ip, startTime))
+				pingKey := FormatPingKey(getPingKey(dal, ip, startTime))
 				So(ipStats.FirstPingKey, ShouldEqual, pingKey)
 				So(ipStats.FirstPingTime, ShouldHappenOnOrAfter, startTime)
 				So(ipStats.LastPingKey, ShouldEqual, pingKey)
@@ -127,7 +204,7 @@ func Test_dal_integration(t *testing.T) {
 
 				So(ipStats.FirstPingKey, ShouldEqual, pingKey)
 				So(ipStats.FirstPingTime, ShouldHappenOnOrAfter, startTime)
-				newLastPingKey := string(GetPingKey(ip, startTime2))
+				newLastPingKey := FormatPingKey(getPingKey(dal, ip, startTime2))
 				So(ipStats.LastPingKey, ShouldEqual, newLastPingKey)
 				So(ipStats.LastPingTime, ShouldHappenOnOrAfter, startTime2)
 			})
@@ -139,10 +216,11 @@ func Test_dal_integration(t *testing.T) {
 				err := dal.SavePing(ip, time.Now(), -2.0)
 				So(err.Error(), ShouldContainSubstring, ResponseTimeOutOfRangeError)
 			})
-			Convey("should return error when opening invalid db", func() {
+			Convey("should return error when db isn't open", func() {
 				d := &DAL{}
 				err := d.SavePing(ip, time.Now(), 1.0)
 				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, DatabaseNotOpenError)
 			})
 		})
 
@@ -167,6 +245,93 @@ func Test_dal_integration(t *testing.T) {
 				So(len(groups), ShouldEqual, 2)
 			})
 
+			Convey("should not return pings of an IP that starts with the given IP", func() {
+				seedTestDB(dal, "127.0.0.1", "01/03/15 04:00:00 pm", "01/03/15 04:01:00 pm")
+				seedTestDB(dal, "127.0.0.10", "01/03/15 04:00:00 pm", "01/03/15 04:01:00 pm")
+
+				start, _ := time.ParseInLocation(tfmt, "01/03/15 04:00:00 pm", time.UTC)
+				groups, err := dal.GetPings("127.0.0.1", start, start.Add(1*time.Minute), 1*time.Minute)
+				So(err, ShouldBeNil)
+				So(sumReceived(groups), ShouldEqual, 60)
+			})
+
+			Convey("should return pings of an IPv6 address w/ zone", func() {
+				ip6 := "fe80::1%en_0"
+				seedTestDB(dal, ip6, "01/03/15 04:00:00 pm", "01/03/15 04:02:00 pm")
+
+				start, _ := time.ParseInLocation(tfmt, "01/03/15 04:00:00 pm", time.UTC)
+				groups, err := dal.GetPings(ip6, start, start.Add(2*time.Minute), 1*time.Minute)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 2)
+				So(sumReceived(groups), ShouldEqual, 120)
+			})
+
+			Convey("should return every minute once when the query spans a DST change", func() {
+				ny, err := time.LoadLocation("America/New_York")
+				So(err, ShouldBeNil)
+				// clocks go back from 2am EDT to 1am EST, so 12am to 3am is 4 hours
+				start := time.Date(2015, time.November, 1, 0, 0, 0, 0, ny)
+				end := time.Date(2015, time.November, 1, 3, 0, 0, 0, ny)
+				So(end.Sub(start), ShouldEqual, 4*time.Hour)
+				for t := start; t.Before(end); t = t.Add(time.Minute) {
+					So(dal.SavePing(ip, t, 1), ShouldBeNil)
+				}
+
+				groups, err := dal.GetPings(ip, start, end, time.Minute)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 240)
+				for i, g := range groups {
+					So(g.Start, ShouldHappenOnOrBetween, start.Add(time.Duration(i)*time.Minute), start.Add(time.Duration(i)*time.Minute))
+					So(g.Received, ShouldEqual, 1)
+				}
+			})
+
+			Convey("should return 1 empty group for an IP w/o pings", func() {
+				start := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+				groups, err := dal.GetPings("10.9.9.9", start, start.Add(time.Hour), time.Minute)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 1)
+				So(groups[0].Received, ShouldEqual, 0)
+			})
+
+			Convey("should return error when groupBy isn't > 0", func() {
+				start := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+				So(dal.SavePing(ip, start, 1), ShouldBeNil)
+				for _, groupBy := range []time.Duration{0, -time.Minute} {
+					_, err := dal.GetPings(ip, start, start.Add(time.Hour), groupBy)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, GroupByOutOfRangeError)
+				}
+			})
+
+			Convey("should return error when the range has more than MaxGroups groups", func() {
+				start := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+				So(dal.SavePing(ip, start.Add(time.Hour), 1), ShouldBeNil)
+				_, err := dal.GetPings(ip, start, start.AddDate(0, 0, 1), time.Millisecond)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, TooManyGroupsError)
+
+				groups, err := dal.GetPings(ip, start, start.Add(MaxGroups*time.Millisecond), time.Millisecond)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 1)
+			})
+
+			Convey("should return more than 60 pings per minute w/ a sub-second interval", func() {
+				start, _ := time.ParseInLocation(tfmt, "01/03/15 04:00:00 pm", time.UTC)
+				for i := 0; i < 240; i++ { // every 250ms
+					err := dal.SavePing(ip, start.Add(time.Duration(i)*250*time.Millisecond), float32(i%10))
+					So(err, ShouldBeNil)
+				}
+
+				groups, err := dal.GetPings(ip, start, start.Add(1*time.Minute), 1*time.Second)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 60)
+				So(sumReceived(groups), ShouldEqual, 240)
+				for _, g := range groups {
+					So(g.Received, ShouldEqual, 4)
+				}
+			})
+
 			Convey("should return 24 groups, 1 hour in each group", func() {
 				seedTestDB(dal, ip, "01/03/15 04:00:00 pm", "01/04/15 06:00:00 pm")
 
@@ -240,50 +405,59 @@ func Test_dal_integration(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(len(groups), ShouldEqual, 1)
 				So(groups[0].Timedout, ShouldEqual, 2)
+				So(groups[0].LostBy[ReasonTimeout], ShouldEqual, 2)
 				// So(sumReceived(groups), ShouldEqual, 30)
 				// So(groups[0].Start, ShouldHappenOnOrAfter, start)
 				// So(groups[len(groups)-1].End, ShouldHappenOnOrBefore, endti)
 			})
 
+			Convey("should count lost pings by reason", func() {
+				start, _ := time.ParseInLocation(tfmt, "01/03/15 03:00:00 pm", time.UTC)
+
+				So(dal.SaveLostPing(ip, start, ReasonHostUnreachable, 1), ShouldBeNil)
+				So(dal.SaveLostPing(ip, start.Add(time.Second), ReasonHostUnreachable, 1), ShouldBeNil)
+				So(dal.SaveLostPing(ip, start.Add(2*time.Second), ReasonDNS, 0), ShouldBeNil)
+				So(dal.SavePing(ip, start.Add(3*time.Second), 1.5), ShouldBeNil)
+
+				groups, err := dal.GetPings(ip, start, start.Add(1*time.Minute), 1*time.Minute)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 1)
+				So(groups[0].Received, ShouldEqual, 1)
+				So(groups[0].Timedout, ShouldEqual, 3)
+				So(groups[0].LostBy[ReasonHostUnreachable], ShouldEqual, 2)
+				So(groups[0].LostBy[ReasonDNS], ShouldEqual, 1)
+			})
+
 			Convey("should return error when it can't find bucket", func() {
-				db, err := bolt.Open(dal.fileName, 0600, nil)
+				err := dal.DeleteBuckets()
 				So(err, ShouldBeNil)
-				err = db.Update(func(tx *bolt.Tx) error {
-					for _, name := range dal.Buckets() {
-						tx.DeleteBucket([]byte(name))
-					}
-					return nil
-				})
-				db.Close()
 				_, err = dal.GetPings("127.0.0.1", time.Now(), time.Now(), 1*time.Second)
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, BucketNotFoundError)
 			})
 
-			Convey("should return error when it can't open db ", func() {
-				dal.fileName = ""
+			Convey("should return error when db isn't open", func() {
+				dal.Close()
 				_, err := dal.GetPings("127.0.0.1", time.Now(), time.Now(), 1*time.Second)
 				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, DatabaseNotOpenError)
 			})
 
 			Convey("should return error when it deserialize key timestamp", func() {
 				ip := "127.0.0.1"
 				startTime := time.Now()
 
-				db, err := bolt.Open(dal.fileName, 0600, nil)
-				So(err, ShouldBeNil)
 				// add a garbage value to our pings bucket manually
-				err = db.Update(func(tx *bolt.Tx) error {
+				key := getPingKey(dal, ip, startTime)
+				err := dal.db.Update(func(tx *bolt.Tx) error {
 					pings, err := tx.CreateBucketIfNotExists([]byte(dal.pingsBucket))
 					So(err, ShouldBeNil)
-					key := GetPingKey(ip, startTime)
-					key = append(key, []byte("break-the-RFC3399-timestamp")...)
+					key = append(key, []byte("break-the-key-length")...)
 					return pings.Put(key, nil)
 				})
-				db.Close()
 
 				So(err, ShouldBeNil)
-				groups, err := dal.GetPings(ip, startTime, startTime, 1*time.Second)
+				groups, err := dal.GetPings(ip, startTime, startTime.Add(time.Minute), 1*time.Second)
 				So(groups, ShouldBeNil)
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, KeyTimestampParsingError)
@@ -293,18 +467,15 @@ func Test_dal_integration(t *testing.T) {
 				ip := "127.0.0.1"
 				startTime := time.Now()
 
-				db, err := bolt.Open(dal.fileName, 0600, nil)
-				So(err, ShouldBeNil)
 				// add a garbage value to our pings bucket manually
-				err = db.Update(func(tx *bolt.Tx) error {
+				key := getPingKey(dal, ip, startTime)
+				err := dal.db.Update(func(tx *bolt.Tx) error {
 					pings, err := tx.CreateBucketIfNotExists([]byte(dal.pingsBucket))
 					So(err, ShouldBeNil)
-					key := GetPingKey(ip, startTime)
 					val := make([]byte, 25)
 					val[0] = 60 // the seconds offset should be between 0-59...
 					return pings.Put(key, val)
 				})
-				db.Close()
 				So(err, ShouldBeNil)
 
 				groups, err := dal.GetPings(ip, startTime, startTime, 1*time.Second)
@@ -316,10 +487,7 @@ func Test_dal_integration(t *testing.T) {
 
 		Convey("SavePingWithTransaction()", func() {
 			Convey("should return error when key is too large", func() {
-				db, err := bolt.Open(dal.fileName, 0600, nil)
-				So(err, ShouldBeNil)
-				defer db.Close()
-				err = db.Update(func(tx *bolt.Tx) error {
+				err := dal.db.Update(func(tx *bolt.Tx) error {
 					b := make([]byte, 130000)
 					largeKey := string(b)
 					return dal.SavePingWithTransaction(largeKey, time.Time{}, 1.0, tx)
@@ -328,18 +496,10 @@ func Test_dal_integration(t *testing.T) {
 				So(err.Error(), ShouldContainSubstring, "key too large")
 			})
 			Convey("should return error when it can't find bucket", func() {
-				db, err := bolt.Open(dal.fileName, 0600, nil)
-				So(err, ShouldBeNil)
-				defer db.Close()
-				err = db.Update(func(tx *bolt.Tx) error {
-					for _, name := range dal.Buckets() {
-						tx.DeleteBucket([]byte(name))
-					}
-					return nil
-				})
+				err := dal.DeleteBuckets()
 				So(err, ShouldBeNil)
 
-				err = db.Update(func(tx *bolt.Tx) error {
+				err = dal.db.Update(func(tx *bolt.Tx) error {
 					return dal.SavePingWithTransaction("", time.Time{}, 1.0, tx)
 				})
 				So(err, ShouldNotBeNil)
@@ -350,6 +510,25 @@ func Test_dal_integration(t *testing.T) {
 	})
 }
 
+func Benchmark_dal_SavePing(b *testing.B) {
+	dal := NewDAL()
+	if err := dal.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(dal.fileName)
+	defer dal.Close()
+	dal.CreateBuckets()
+
+	startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := dal.SavePing("127.0.0.1", startTime.Add(time.Duration(i)*time.Second), 1.1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // func Test_dal_seed(t *testing.T) {
 // Convey("seed", t, func() {
 // 	fmt.Println()
@@ -388,19 +567,13 @@ func sumReceived(groups []*PingGroup) int {
 func seedTestDB(dal *DAL, ip, startTime, endTime string) {
 	const tfmt = "01/02/06 03:04:05 pm"
 
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	maxRes, minRes := float32(1500.0), float32(5.0)
 	rand.Seed(time.Now().UnixNano())
 
 	start, _ := time.ParseInLocation(tfmt, startTime, time.UTC)
 	end, _ := time.ParseInLocation(tfmt, endTime, time.UTC)
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	err := dal.db.Update(func(tx *bolt.Tx) error {
 		// pt == ping timestamp
 		for pt := start; pt.Sub(end) != 0; pt = pt.Add(1 * time.Second) {
 			resTime := rand.Float32()*(maxRes-minRes) + minRes
@@ -419,16 +592,22 @@ func seedTestDB(dal *DAL, ip, startTime, endTime string) {
 	}
 }
 
-func getAllPingKeys(dal *DAL) []string {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
+// getPingKey returns the key of the minute of t for series, the series is given a
+// target ID if it doesn't have one yet
+func getPingKey(dal *DAL, series string, t time.Time) []byte {
+	var key []byte
+	dal.db.Update(func(tx *bolt.Tx) error {
+		id, err := dal.createTargetID(tx, series)
+		key = GetPingKey(id, t)
+		return err
+	})
+	return key
+}
 
+func getAllPingKeys(dal *DAL) []string {
 	keys := []string{}
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("pings_by_minute"))
+	dal.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dal.pingsBucket))
 		c := b.Cursor()
 
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
@@ -442,7 +621,7 @@ func getAllPingKeys(dal *DAL) []string {
 }
 
 func writeTable(groups []*PingGroup) {
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{
@@ -0,0 +1,181 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// HistoryKeyByteCount is the size of a history key, a target ID + a time
+const HistoryKeyByteCount = TargetIDByteCount + 8
+
+// HistoryEntry is a value a series had from Time until the next entry, ex: the
+// answers of a DNS query. Only changes are saved, see SaveHistory
+type HistoryEntry struct {
+	Time  time.Time
+	Value string
+}
+
+// GetHistoryKey returns the key of a value the series w/ the given target ID had
+// starting at t, see createTargetID. Keys start w/ the ID like ping keys, so the
+// keys of a series never mix w/ the ones of a series it's a prefix of.
+// Format: 12 bytes
+// | 4 bytes   | 8 bytes
+// | target ID | unix time in ns
+func GetHistoryKey(id uint32, t time.Time) []byte {
+	key := getHistoryKeyPrefix(id)[:HistoryKeyByteCount]
+	ns := t.UnixNano()
+	if ns < 0 {
+		ns = 0 // keys can't go before 1970, this only matters to queries
+	}
+	binary.BigEndian.PutUint64(key[TargetIDByteCount:], uint64(ns))
+	return key
+}
+
+// getHistoryKeyPrefix returns the prefix shared by every history key of the
+// given target, w/ room for the time
+func getHistoryKeyPrefix(id uint32) []byte {
+	key := make([]byte, TargetIDByteCount, HistoryKeyByteCount)
+	binary.BigEndian.PutUint32(key, id)
+	return key
+}
+
+// ParseHistoryKey does the opposite of GetHistoryKey, t is in UTC
+func ParseHistoryKey(key []byte) (id uint32, t time.Time, err error) {
+	if len(key) != HistoryKeyByteCount {
+		return 0, time.Time{}, fmt.Errorf("ParseHistoryKey(): %s", InvalidKeyError)
+	}
+	id = binary.BigEndian.Uint32(key)
+	ns := binary.BigEndian.Uint64(key[TargetIDByteCount:])
+	return id, time.Unix(0, int64(ns)).UTC(), nil
+}
+
+// lastHistoryEntry returns the most recent entry of the target w/ the given ID,
+// nil if there isn't one
+func lastHistoryEntry(id uint32, bucket *bolt.Bucket) (*HistoryEntry, error) {
+	pre := getHistoryKeyPrefix(id)
+	c := bucket.Cursor()
+
+	// seek to the first key of the next target & step back
+	var k, v []byte
+	if id < math.MaxUint32 {
+		k, _ = c.Seek(getHistoryKeyPrefix(id + 1))
+	}
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, pre) {
+		return nil, nil
+	}
+
+	_, t, err := ParseHistoryKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryEntry{Time: t, Value: string(v)}, nil
+}
+
+// SaveHistory records that series had the given value at time t. Nothing is saved
+// when the value is the same as the last value saved, changed is true when it's saved.
+func (dal *DAL) SaveHistory(series string, t time.Time, value string) (changed bool, err error) {
+	if len(series) == 0 {
+		return false, fmt.Errorf("dal.SaveHistory: %s", IPRequiredError)
+	}
+
+	err = dal.update(func(tx *bolt.Tx) error {
+		changed, err = dal.saveHistory(series, t, value, tx)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("dal.SaveHistory: %s", err)
+	}
+	return changed, nil
+}
+
+// SaveHistoryWithTransaction is SaveHistory using the given bolt transaction, see Update
+func (dal *DAL) SaveHistoryWithTransaction(series string, t time.Time, value string, tx *bolt.Tx) (changed bool, err error) {
+	if len(series) == 0 {
+		return false, fmt.Errorf("dal.SaveHistoryWithTransaction: %s", IPRequiredError)
+	}
+	if changed, err = dal.saveHistory(series, t, value, tx); err != nil {
+		return false, fmt.Errorf("dal.SaveHistoryWithTransaction: %s", err)
+	}
+	return changed, nil
+}
+
+// saveHistory saves value unless it's the last value of series, see SaveHistory
+func (dal *DAL) saveHistory(series string, t time.Time, value string, tx *bolt.Tx) (changed bool, err error) {
+	bucket := tx.Bucket([]byte(dal.historyBucket))
+	if bucket == nil {
+		return false, fmt.Errorf("%s %s", BucketNotFoundError, dal.historyBucket)
+	}
+
+	id, err := dal.createTargetID(tx, series)
+	if err != nil {
+		return false, err
+	}
+	last, err := lastHistoryEntry(id, bucket)
+	if err != nil {
+		return false, err
+	}
+	if last != nil && last.Value == value {
+		return false, nil
+	}
+	return true, bucket.Put(GetHistoryKey(id, t), []byte(value))
+}
+
+// GetHistory returns the values series had between start and end, oldest first.
+// The first entry is the value series had at start, when there is one.
+func (dal *DAL) GetHistory(series string, start, end time.Time) ([]*HistoryEntry, error) {
+	entries := []*HistoryEntry{}
+	err := dal.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dal.historyBucket))
+		if bucket == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, dal.historyBucket)
+		}
+
+		id, ok, err := dal.getTargetID(tx, series)
+		if err != nil || !ok {
+			return err
+		}
+		pre := getHistoryKeyPrefix(id)
+		min := GetHistoryKey(id, start)
+		max := GetHistoryKey(id, end)
+		c := bucket.Cursor()
+
+		k, v := c.Seek(min)
+		if k == nil || !bytes.Equal(k, min) {
+			// the value in effect at start was saved before it
+			var pk, pv []byte
+			if k == nil {
+				pk, pv = c.Last()
+			} else {
+				pk, pv = c.Prev()
+			}
+			if pk != nil && bytes.HasPrefix(pk, pre) {
+				k, v = pk, pv
+			} else {
+				k, v = c.Seek(min)
+			}
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, pre) && bytes.Compare(k, max) < 0; k, v = c.Next() {
+			_, t, err := ParseHistoryKey(k)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &HistoryEntry{Time: t, Value: string(v)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dal.GetHistory: %s", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,128 @@
+package dal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_history_unit(t *testing.T) {
+	Convey("ParseHistoryKey()", t, func() {
+		Convey("should parse the key from GetHistoryKey", func() {
+			ts := time.Date(2015, time.January, 1, 12, 30, 0, 500, time.FixedZone("EST", -5*3600))
+			id, parsed, err := ParseHistoryKey(GetHistoryKey(7, ts))
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, 7)
+			So(parsed.Equal(ts), ShouldBeTrue)
+		})
+		Convey("should return error w/ an invalid key", func() {
+			_, _, err := ParseHistoryKey([]byte("dns:google.com@8.8.8.8:53_2015-01-01T12:30:00.000000000Z"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, InvalidKeyError)
+		})
+	})
+}
+
+func Test_history_integration(t *testing.T) {
+	Convey("History", t, func() {
+		dal := NewDAL()
+		err := dal.Open()
+		So(err, ShouldBeNil)
+		dal.DeleteBuckets()
+		dal.CreateBuckets()
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+
+		series := "dns:google.com@8.8.8.8:53"
+		t0 := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+
+		Convey("SaveHistory()", func() {
+			Convey("should only save values that changed", func() {
+				changed, err := dal.SaveHistory(series, t0, "NOERROR 10.0.0.1")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+
+				changed, err = dal.SaveHistory(series, t0.Add(1*time.Second), "NOERROR 10.0.0.1")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeFalse)
+
+				changed, err = dal.SaveHistory(series, t0.Add(2*time.Second), "NOERROR 10.0.0.2")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+
+				entries, err := dal.GetHistory(series, t0, t0.Add(1*time.Minute))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+			})
+			Convey("should keep series that share a prefix separate", func() {
+				dal.SaveHistory(series, t0, "NOERROR 10.0.0.1")
+				changed, err := dal.SaveHistory(series+"0", t0, "NOERROR 10.0.0.1")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+			})
+			Convey("should keep a series separate from the ones it's a prefix of w/ an _", func() {
+				dal.SaveHistory("http://a/b", t0, "1.2.3.4")
+				dal.SaveHistory("http://a/b_c", t0.Add(time.Minute), "5.6.7.8")
+
+				// the last value of http://a/b is still 1.2.3.4, not the one of http://a/b_c
+				changed, err := dal.SaveHistory("http://a/b", t0.Add(2*time.Minute), "1.2.3.4")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeFalse)
+				changed, err = dal.SaveHistory("http://a/b", t0.Add(3*time.Minute), "5.6.7.8")
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+
+				entries, err := dal.GetHistory("http://a/b", t0.Add(90*time.Second), t0.Add(time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(entries[0].Value, ShouldEqual, "1.2.3.4")
+				So(entries[1].Value, ShouldEqual, "5.6.7.8")
+
+				entries, err = dal.GetHistory("http://a/b_c", t0, t0.Add(time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].Value, ShouldEqual, "5.6.7.8")
+			})
+			Convey("should return error w/ blank series", func() {
+				_, err := dal.SaveHistory("", t0, "")
+				So(err.Error(), ShouldContainSubstring, IPRequiredError)
+			})
+		})
+
+		Convey("GetHistory()", func() {
+			dal.SaveHistory(series, t0, "a")
+			dal.SaveHistory(series, t0.Add(10*time.Minute), "b")
+			dal.SaveHistory(series, t0.Add(20*time.Minute), "c")
+			dal.SaveHistory("other", t0.Add(5*time.Minute), "z")
+
+			Convey("should include the value in effect at start", func() {
+				entries, err := dal.GetHistory(series, t0.Add(15*time.Minute), t0.Add(1*time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(entries[0].Value, ShouldEqual, "b")
+				So(entries[1].Value, ShouldEqual, "c")
+			})
+			Convey("should not include values saved at or after end", func() {
+				entries, err := dal.GetHistory(series, t0, t0.Add(20*time.Minute))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(entries[0].Value, ShouldEqual, "a")
+				So(entries[1].Value, ShouldEqual, "b")
+			})
+			Convey("should return nothing before the first value", func() {
+				entries, err := dal.GetHistory(series, t0.Add(-1*time.Hour), t0)
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 0)
+			})
+			Convey("should return nothing for an unknown series", func() {
+				entries, err := dal.GetHistory("nope", t0, t0.Add(1*time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -1,7 +1,10 @@
 package dal
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -16,13 +19,195 @@ const (
 
 // IPStats keep track of useful summary info about a particular IP address
 type IPStats struct {
-	IP            string    // The ip address
-	FirstPingKey  string    // first key of pings_by_minute bucket
-	FirstPingTime time.Time // The timestamp of the first ping attempt
-	LastPingKey   string    // last key ...
-	LastPingTime  time.Time // The timestamp of the last ping attempt
-	Received      uint64
-	Lost          uint64
+	IP            string      // The ip address
+	FirstPingKey  string      // first key of the pings bucket, see FormatPingKey, "" once every ping is pruned
+	FirstPingTime time.Time   // The timestamp of the first ping attempt, even once it's pruned
+	LastPingKey   string      // last key ...
+	LastPingTime  time.Time   // The timestamp of the last ping attempt
+	Received      uint64      // # of pings received, ever
+	Lost          uint64      // # of pings lost, ever
+	Minutes       []StatsSlot `json:"-"` // the last hour, a slot per minute, see LastHour & loadSlots
+	Hours         []StatsSlot `json:"-"` // the last day, a slot per hour, see LastDay & loadSlots
+}
+
+const (
+	minuteSlotCount = 60
+	hourSlotCount   = 24
+)
+
+// StatsSlot sums the pings of a minute or an hour. The slots of every IPStats
+// are saved in their own bucket, a key per slot, so saving a ping only rewrites
+// the slot of its minute & of its hour, see addPingSlots.
+type StatsSlot struct {
+	Start    int64 // unix time of the minute or hour
+	Received uint64
+	Lost     uint64
+	Sum      float64 // of the response times of the received pings, ms
+	Min      float64
+	Max      float64
+}
+
+// StatsSlotByteCount is the size of a saved StatsSlot, see MarshalBinary
+var StatsSlotByteCount = binary.Size(StatsSlot{})
+
+// MarshalBinary returns the fields of ss, little-endian
+func (ss *StatsSlot) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(StatsSlotByteCount)
+	if err := binary.Write(&b, binary.LittleEndian, ss); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary does the opposite of MarshalBinary
+func (ss *StatsSlot) UnmarshalBinary(data []byte) error {
+	if len(data) != StatsSlotByteCount {
+		return errors.New(InvalidByteLength)
+	}
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, ss)
+}
+
+// add adds a ping to the slot, resTime < 0 is lost
+func (ss *StatsSlot) add(resTime float64) {
+	if resTime < 0 {
+		ss.Lost++
+		return
+	}
+	ss.merge(StatsSlot{Received: 1, Sum: resTime, Min: resTime, Max: resTime})
+}
+
+// merge adds the pings of o to the slot
+func (ss *StatsSlot) merge(o StatsSlot) {
+	if o.Received > 0 {
+		if ss.Received == 0 || o.Min < ss.Min {
+			ss.Min = o.Min
+		}
+		if o.Max > ss.Max {
+			ss.Max = o.Max
+		}
+	}
+	ss.Received += o.Received
+	ss.Lost += o.Lost
+	ss.Sum += o.Sum
+}
+
+// mergeNewer merges o into the slot when they're the same period, the slot is
+// reset first when o is newer & o is dropped when it's older
+func (ss *StatsSlot) mergeNewer(o StatsSlot) {
+	if ss.Start > o.Start {
+		return
+	}
+	if ss.Start < o.Start {
+		*ss = StatsSlot{Start: o.Start}
+	}
+	ss.merge(o)
+}
+
+// AddPing counts a ping that started at t, resTime < 0 is lost
+func (s *IPStats) AddPing(t time.Time, resTime float64) {
+	s.countPing(resTime)
+	for _, r := range s.rings() {
+		*r.slots = mergeSlot(*r.slots, r.count, r.period, pingSlot(t, r.period, resTime))
+	}
+}
+
+// countPing counts a ping in Received or Lost, w/o adding it to the slots
+func (s *IPStats) countPing(resTime float64) {
+	if resTime < 0 {
+		s.Lost++
+	} else {
+		s.Received++
+	}
+}
+
+// pingSlot returns the slot of period of a ping that started at t
+func pingSlot(t time.Time, period time.Duration, resTime float64) StatsSlot {
+	slot := StatsSlot{Start: t.Truncate(period).Unix()}
+	slot.add(resTime)
+	return slot
+}
+
+// slotRing is a ring of count slots of period, see IPStats.Minutes
+type slotRing struct {
+	slots  *[]StatsSlot
+	count  int
+	period time.Duration
+}
+
+// rings returns the rings of s, minutes first
+func (s *IPStats) rings() []slotRing {
+	return []slotRing{
+		{&s.Minutes, minuteSlotCount, time.Minute},
+		{&s.Hours, hourSlotCount, time.Hour},
+	}
+}
+
+// mergeSlot merges o into the slot of its period in slots, a ring of count
+// periods, see StatsSlot.mergeNewer
+func mergeSlot(slots []StatsSlot, count int, period time.Duration, o StatsSlot) []StatsSlot {
+	if len(slots) != count {
+		slots = make([]StatsSlot, count)
+	}
+	slots[slotIndex(o.Start, count, period)].mergeNewer(o)
+	return slots
+}
+
+// slotIndex returns the index of the slot of the period that starts at start in
+// a ring of count periods
+func slotIndex(start int64, count int, period time.Duration) int {
+	return int(start / int64(period/time.Second) % int64(count))
+}
+
+// Window is a rolling aggregate of pings, see LastHour
+type Window struct {
+	Count uint64  // # of pings, received or lost
+	Lost  uint64  // # of pings lost
+	Mean  float64 // of the response times of the received pings, ms
+	Min   float64
+	Max   float64
+}
+
+// Loss returns the % of pings that were lost, 0 when there weren't any
+func (w Window) Loss() float64 {
+	if w.Count == 0 {
+		return 0
+	}
+	return float64(w.Lost) / float64(w.Count) * 100
+}
+
+// LastHour returns the pings of the minute of now & the 59 before it
+func (s *IPStats) LastHour(now time.Time) Window {
+	return slotsWindow(s.Minutes, minuteSlotCount, time.Minute, now)
+}
+
+// LastDay returns the pings of the hour of now & the 23 before it
+func (s *IPStats) LastDay(now time.Time) Window {
+	return slotsWindow(s.Hours, hourSlotCount, time.Hour, now)
+}
+
+// ringStart returns the start of the oldest period of a ring of count periods
+// whose newest is the one of now
+func ringStart(count int, period time.Duration, now time.Time) time.Time {
+	return now.Truncate(period).Add(-time.Duration(count-1) * period)
+}
+
+// slotsWindow sums the slots of the ring of count periods whose newest is the
+// one of now
+func slotsWindow(slots []StatsSlot, count int, period time.Duration, now time.Time) Window {
+	since, until := ringStart(count, period, now).Unix(), now.Unix()
+	var sum StatsSlot
+	for _, slot := range slots {
+		if slot.Start < since || slot.Start > until {
+			continue
+		}
+		sum.merge(slot)
+	}
+	w := Window{Count: sum.Received + sum.Lost, Lost: sum.Lost, Min: sum.Min, Max: sum.Max}
+	if sum.Received > 0 {
+		w.Mean = sum.Sum / float64(sum.Received)
+	}
+	return w
 }
 
 type ByLastPingTime []*IPStats
@@ -32,15 +217,12 @@ func (a ByLastPingTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByLastPingTime) Less(i, j int) bool { return a[i].LastPingTime.Before(a[j].LastPingTime) }
 
 func (dal *DAL) GetAllIPStats() ([]*IPStats, error) {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close()
-
 	allStats := []*IPStats{}
-	err = db.View(func(tx *bolt.Tx) error {
+	err := dal.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(dal.ipStatsBucket))
+		if b == nil {
+			return fmt.Errorf("dal.GetAllIPStats: %s %s", BucketNotFoundError, dal.ipStatsBucket)
+		}
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
@@ -49,6 +231,9 @@ func (dal *DAL) GetAllIPStats() ([]*IPStats, error) {
 			if err != nil {
 				return nil
 			}
+			if err := dal.loadSlots(tx, &s); err != nil {
+				return fmt.Errorf("dal.GetAllIPStats: %s: %s", s.IP, err)
+			}
 			allStats = append(allStats, &s)
 		}
 		return nil
@@ -58,19 +243,17 @@ func (dal *DAL) GetAllIPStats() ([]*IPStats, error) {
 }
 
 func (dal *DAL) GetIPStats(ip string) (*IPStats, error) {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close()
-
 	var ipStats *IPStats
-	err = db.View(func(tx *bolt.Tx) error {
+	err := dal.view(func(tx *bolt.Tx) error {
+		var err error
 		bucket := tx.Bucket([]byte(dal.ipStatsBucket))
 		ipStats, err = dal.GetIPStatsFromBucket(ip, bucket)
-		if err != nil {
+		if err != nil || ipStats == nil {
 			return err
 		}
+		if err := dal.loadSlots(tx, ipStats); err != nil {
+			return fmt.Errorf("dal.GetIPStats: %s", err)
+		}
 		return nil
 	})
 
@@ -98,13 +281,7 @@ func (dal *DAL) GetIPStatsFromBucket(ip string, bucket *bolt.Bucket) (*IPStats,
 }
 
 func (dal *DAL) SaveIPStats(stats *IPStats) error {
-	db, err := bolt.Open(dal.fileName, 0600, nil)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
+	err := dal.update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(dal.ipStatsBucket))
 		return dal.SaveIPStatsInBucket(stats, bucket)
 	})
@@ -130,3 +307,105 @@ func (dal *DAL) SaveIPStatsInBucket(stats *IPStats, bucket *bolt.Bucket) error {
 
 	return bucket.Put([]byte(stats.IP), b)
 }
+
+// SlotKeyByteCount is the size of the key of a StatsSlot
+const SlotKeyByteCount = TargetIDByteCount + 2
+
+// getSlotKey returns the key of slot i of ring r of the target w/ the given ID,
+// the rings are in the order of IPStats.rings
+// Format: 6 bytes
+// | 4 bytes   | 1 byte | 1 byte
+// | target ID | ring   | slot
+func getSlotKey(id uint32, r, i int) []byte {
+	key := make([]byte, SlotKeyByteCount)
+	binary.BigEndian.PutUint32(key, id)
+	key[TargetIDByteCount] = byte(r)
+	key[TargetIDByteCount+1] = byte(i)
+	return key
+}
+
+// addPingSlots adds a ping that started at t to the slots of its minute & hour
+// of the target w/ the given ID
+func (dal *DAL) addPingSlots(tx *bolt.Tx, id uint32, t time.Time, resTime float64) error {
+	bucket := tx.Bucket([]byte(dal.statsSlotsBucket))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, dal.statsSlotsBucket)
+	}
+	for r, ring := range (&IPStats{}).rings() {
+		o := pingSlot(t, ring.period, resTime)
+		key := getSlotKey(id, r, slotIndex(o.Start, ring.count, ring.period))
+
+		var slot StatsSlot
+		if v := bucket.Get(key); v != nil {
+			if err := slot.UnmarshalBinary(v); err != nil {
+				return fmt.Errorf("%s: slot %x", err, key)
+			}
+		}
+		slot.mergeNewer(o)
+		v, err := slot.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSlots saves every slot of stats as the slots of the target w/ the given ID
+func (dal *DAL) saveSlots(tx *bolt.Tx, id uint32, stats *IPStats) error {
+	bucket := tx.Bucket([]byte(dal.statsSlotsBucket))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, dal.statsSlotsBucket)
+	}
+	for r, ring := range stats.rings() {
+		slots := *ring.slots
+		if len(slots) != ring.count {
+			slots = make([]StatsSlot, ring.count)
+		}
+		for i := range slots {
+			v, err := slots[i].MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(getSlotKey(id, r, i), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSlots reads the Minutes & Hours of stats from the slots of its target
+func (dal *DAL) loadSlots(tx *bolt.Tx, stats *IPStats) error {
+	id, ok, err := dal.getTargetID(tx, stats.IP)
+	if err != nil || !ok {
+		return err
+	}
+	bucket := tx.Bucket([]byte(dal.statsSlotsBucket))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, dal.statsSlotsBucket)
+	}
+
+	rings := stats.rings()
+	pre := getSlotKey(id, 0, 0)[:TargetIDByteCount]
+	c := bucket.Cursor()
+	for k, v := c.Seek(pre); k != nil && bytes.HasPrefix(k, pre); k, v = c.Next() {
+		if len(k) != SlotKeyByteCount || int(k[TargetIDByteCount]) >= len(rings) {
+			return fmt.Errorf("%s: slot %x", InvalidKeyError, k)
+		}
+		ring := rings[k[TargetIDByteCount]]
+		i := int(k[TargetIDByteCount+1])
+		if i >= ring.count {
+			return fmt.Errorf("%s: slot %x", InvalidKeyError, k)
+		}
+		if len(*ring.slots) != ring.count {
+			*ring.slots = make([]StatsSlot, ring.count)
+		}
+		if err := (*ring.slots)[i].UnmarshalBinary(v); err != nil {
+			return fmt.Errorf("%s: slot %x", err, k)
+		}
+	}
+	return nil
+}
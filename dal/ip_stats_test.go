@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -25,14 +26,68 @@ func Test_ip_stats_unit(t *testing.T) {
 			So(stats[2].IP, ShouldEqual, "3")
 		})
 	})
+
+	Convey("IPStats.AddPing()", t, func() {
+		start := time.Date(2015, time.January, 1, 12, 0, 0, 0, time.UTC)
+		stats := &IPStats{}
+		for i := 0; i < 90; i++ {
+			resTime := float64(i % 10)
+			if i%15 == 0 {
+				resTime = -1
+			}
+			stats.AddPing(start.Add(time.Duration(i)*time.Minute), resTime)
+		}
+
+		Convey("should count every ping, ever", func() {
+			So(stats.Received, ShouldEqual, 84)
+			So(stats.Lost, ShouldEqual, 6)
+		})
+		Convey("LastHour() should only count the last 60 minutes", func() {
+			w := stats.LastHour(start.Add(89*time.Minute + 30*time.Second))
+			So(w.Count, ShouldEqual, 60)
+			So(w.Lost, ShouldEqual, 4)
+			So(w.Loss(), ShouldAlmostEqual, 100*4/60.0)
+			So(w.Min, ShouldEqual, 0)
+			So(w.Max, ShouldEqual, 9)
+
+			w = stats.LastHour(start.Add(120 * time.Minute))
+			So(w.Count, ShouldEqual, 29)
+			So(stats.LastHour(start.Add(3*time.Hour)), ShouldResemble, Window{})
+		})
+		Convey("LastDay() should count the hours of the last day", func() {
+			w := stats.LastDay(start.Add(2 * time.Hour))
+			So(w.Count, ShouldEqual, 90)
+			So(w.Mean, ShouldAlmostEqual, 390.0/84)
+			So(stats.LastDay(start.Add(24*time.Hour)).Count, ShouldEqual, 30)
+			So(stats.LastDay(start.Add(25*time.Hour)), ShouldResemble, Window{})
+		})
+		Convey("StatsSlot should marshal to a fixed size & back", func() {
+			b, err := stats.Minutes[5].MarshalBinary()
+			So(err, ShouldBeNil)
+			So(len(b), ShouldEqual, StatsSlotByteCount)
+			var slot StatsSlot
+			So(slot.UnmarshalBinary(b), ShouldBeNil)
+			So(slot, ShouldResemble, stats.Minutes[5])
+			So(slot.UnmarshalBinary(b[1:]), ShouldNotBeNil)
+		})
+		Convey("should drop a ping older than its slot, but count it", func() {
+			stats.AddPing(start.Add(-24*time.Hour), 1)
+			So(stats.Received, ShouldEqual, 85)
+			So(stats.LastHour(start.Add(89*time.Minute)).Count, ShouldEqual, 60)
+			So(stats.LastDay(start.Add(89*time.Minute)).Count, ShouldEqual, 90)
+		})
+	})
 }
 
 func Test_ip_stats_integration(t *testing.T) {
 	Convey("IPStats", t, func() {
 		dal := NewDAL()
+		err := dal.Open()
+		So(err, ShouldBeNil)
 		dal.DeleteBuckets()
 		dal.CreateBuckets()
 		Reset(func() {
+			dal.Close()
 			os.Remove(dal.fileName)
 		})
 
@@ -40,8 +95,8 @@ func Test_ip_stats_integration(t *testing.T) {
 		ip := "127.0.0.1"
 		stats := &IPStats{
 			IP:           ip,
-			FirstPingKey: string(GetPingKey(ip, now)),
-			LastPingKey:  string(GetPingKey(ip, now)),
+			FirstPingKey: FormatPingKey(GetPingKey(1, now)),
+			LastPingKey:  FormatPingKey(GetPingKey(1, now)),
 			Received:     1,
 			Lost:         2,
 		}
@@ -57,10 +112,11 @@ func Test_ip_stats_integration(t *testing.T) {
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, IPRequiredError)
 			})
-			Convey("should return error when it can't open db ", func() {
-				dal.fileName = ""
+			Convey("should return error when db isn't open", func() {
+				dal.Close()
 				err := dal.SaveIPStats(stats)
 				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, DatabaseNotOpenError)
 			})
 			Convey("should return error when bucket doesn't exist", func() {
 				dal.DeleteBuckets()
@@ -107,10 +163,11 @@ func Test_ip_stats_integration(t *testing.T) {
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, IPRequiredError)
 			})
-			Convey("should return error when it can't open db ", func() {
-				dal.fileName = ""
+			Convey("should return error when db isn't open", func() {
+				dal.Close()
 				_, err := dal.GetIPStats(stats.IP)
 				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, DatabaseNotOpenError)
 			})
 			Convey("should return error when bucket doesn't exist", func() {
 				dal.DeleteBuckets()
@@ -126,6 +183,38 @@ func Test_ip_stats_integration(t *testing.T) {
 			})
 		})
 
+		Convey("SavePing() should count the pings of an IP", func() {
+			// a fixed time so the pings never straddle a minute or an hour
+			at := time.Date(2015, time.January, 1, 12, 30, 10, 0, time.UTC)
+			So(dal.SavePing(ip, at, 10), ShouldBeNil)
+			So(dal.SavePing(ip, at.Add(time.Second), 20), ShouldBeNil)
+			So(dal.SavePing(ip, at.Add(2*time.Second), -1), ShouldBeNil)
+
+			saved, err := dal.GetIPStats(ip)
+			So(err, ShouldBeNil)
+			So(saved.Received, ShouldEqual, 2)
+			So(saved.Lost, ShouldEqual, 1)
+			So(saved.LastHour(at), ShouldResemble, Window{Count: 3, Lost: 1, Mean: 15, Min: 10, Max: 20})
+			So(saved.LastDay(at), ShouldResemble, saved.LastHour(at))
+
+			all, err := dal.GetAllIPStats()
+			So(err, ShouldBeNil)
+			So(all[0].LastHour(at), ShouldResemble, saved.LastHour(at))
+		})
+
+		Convey("SavePing() should only save the slots of the minute & hour of a ping", func() {
+			at := time.Date(2015, time.January, 1, 12, 30, 10, 0, time.UTC)
+			So(dal.SavePing(ip, at, 10), ShouldBeNil)
+			So(dal.SavePing(ip, at.Add(time.Minute), 20), ShouldBeNil)
+
+			dal.view(func(tx *bolt.Tx) error {
+				So(tx.Bucket([]byte(dal.statsSlotsBucket)).Stats().KeyN, ShouldEqual, 3)
+				v := tx.Bucket([]byte(dal.ipStatsBucket)).Get([]byte(ip))
+				So(string(v), ShouldNotContainSubstring, "Minutes")
+				return nil
+			})
+		})
+
 		Convey("GetAllIPStats()", func() {
 			Convey("should insert 3 and return 3 IPSstats", func() {
 				// save 3 stats
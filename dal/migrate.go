@@ -0,0 +1,505 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	MigrationOrderError = "migrations must be run in order"
+	SchemaVersionError  = "Could not parse the schema version"
+)
+
+// schemaVersionKey is the key of the schema version in the meta bucket
+const schemaVersionKey = "schema_version"
+
+// Migration upgrades the db from the version before it to Version
+type Migration struct {
+	Version     int    // the schema version of the db once Migrate has run
+	Description string // what changes, shown by pinghist migrate
+	Migrate     func(dal *DAL) error
+}
+
+// migrations are every Migration in order. The dbs of versions of pinghist
+// before the meta bucket are version 1
+var migrations = []Migration{
+	{
+		Version:     2,
+		Description: "Rewrite every ping as a v3 record w/ its ms offset, TTL & size",
+		Migrate: func(dal *DAL) error {
+			_, err := dal.upgradePings(dal.legacyPingsBucket)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "Key pings by target ID & UTC minute, so they sort by time across DST & time zone changes",
+		Migrate: func(dal *DAL) error {
+			_, err := dal.migratePingKeys()
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "Roll every ping up by hour & day, for fast queries grouped by an hour or more",
+		Migrate: func(dal *DAL) error {
+			_, err := dal.RebuildRollups()
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "Count the pings of every host, ever & in the last hour & day, for pinghist hosts",
+		Migrate: func(dal *DAL) error {
+			return dal.recountIPStats(time.Now())
+		},
+	},
+	{
+		Version:     6,
+		Description: "Key the history of DNS answers & trace paths by target ID, so a series never reads the history of one it's a prefix of",
+		Migrate: func(dal *DAL) error {
+			_, err := dal.migrateHistoryKeys()
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "Keep the last hour & day of every host in their own bucket, so saving a ping only rewrites its minute & hour",
+		Migrate: func(dal *DAL) error {
+			return dal.recountIPStats(time.Now())
+		},
+	},
+}
+
+// SchemaVersion is the version of the dbs this version of pinghist writes
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// ReadableSchemaVersion is the oldest schema version this pinghist can use,
+// older dbs have to be migrated first, ex: v3 dbs have no rollups to read
+const ReadableSchemaVersion = 4
+
+// PendingMigrations returns the migrations a db at version has yet to run, in order
+func PendingMigrations(version int) []Migration {
+	pending := []Migration{}
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// SchemaVersion returns the schema version of the db, 1 if it was created
+// before the schema version was recorded. A db w/o buckets is new, CreateBuckets
+// stamps it w/ the latest version.
+func (dal *DAL) SchemaVersion() (int, error) {
+	version := 1
+	err := dal.view(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(dal.metaBucket))
+		if meta == nil {
+			if tx.Bucket([]byte(dal.ipStatsBucket)) == nil {
+				version = SchemaVersion
+			}
+			return nil
+		}
+		v := meta.Get([]byte(schemaVersionKey))
+		if v == nil {
+			return nil
+		}
+		var err error
+		version, err = strconv.Atoi(string(v))
+		if err != nil {
+			return fmt.Errorf("%s: %s", SchemaVersionError, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("dal.SchemaVersion: %s", err)
+	}
+	return version, nil
+}
+
+// Migrate runs m & records that the db is at m.Version, the db must be at the
+// version before it. Migrations can't be rolled back, see Backup
+func (dal *DAL) Migrate(m Migration) error {
+	version, err := dal.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != m.Version-1 {
+		return fmt.Errorf("dal.Migrate: %s, the db is at version %d, can't migrate to %d", MigrationOrderError, version, m.Version)
+	}
+
+	if err := m.Migrate(dal); err != nil {
+		return fmt.Errorf("dal.Migrate: version %d: %s", m.Version, err)
+	}
+
+	err = dal.update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(dal.metaBucket))
+		if err != nil {
+			return err
+		}
+		return putSchemaVersion(meta, m.Version)
+	})
+	if err != nil {
+		return fmt.Errorf("dal.Migrate: %s", err)
+	}
+	return nil
+}
+
+// putSchemaVersion records the schema version in the meta bucket
+func putSchemaVersion(meta *bolt.Bucket, version int) error {
+	return meta.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// Backup copies the db to path while it stays open, path must not exist
+func (dal *DAL) Backup(path string) error {
+	err := dal.view(func(tx *bolt.Tx) error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.WriteTo(f); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		return f.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("dal.Backup: %s", err)
+	}
+	return nil
+}
+
+// legacyPingKeySeparator separates the series from the timestamp of a legacy ping key
+const legacyPingKeySeparator = "_"
+
+// getLegacyPingKey returns the key pings were saved under before schema version
+// 3, the minute of pingStartTime in its location
+// Format: <ip>_<RFC3339 timestamp>, ex: 127.0.0.1_2015-01-01T12:30:00Z
+func getLegacyPingKey(ip string, pingStartTime time.Time) []byte {
+	keyTimestamp := time.Date(pingStartTime.Year(), pingStartTime.Month(),
+		pingStartTime.Day(), pingStartTime.Hour(), pingStartTime.Minute(), 0, 0, pingStartTime.Location())
+
+	return []byte(ip + legacyPingKeySeparator + keyTimestamp.Format(time.RFC3339))
+}
+
+// parseLegacyPingKey does the opposite of getLegacyPingKey. The key is split on the
+// last separator, RFC3339 timestamps never contain one but IPv6 zones (fe80::1%en_0) can
+func parseLegacyPingKey(key []byte) (ip string, baseTime time.Time, err error) {
+	i := bytes.LastIndex(key, []byte(legacyPingKeySeparator))
+	if i <= 0 {
+		return "", time.Time{}, fmt.Errorf("parseLegacyPingKey(): %s", InvalidKeyError)
+	}
+	baseTime, err = time.Parse(time.RFC3339, string(key[i+len(legacyPingKeySeparator):]))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return string(key[:i]), baseTime, nil
+}
+
+// migratePingKeys moves the pings of the legacy bucket to the pings bucket, see
+// GetPingKey, & points the keys of every IPStats at it. Minutes saved in
+// different time zones that turn out to be the same minute are merged. Keys are
+// moved in batches, the legacy bucket is deleted once it's empty. It returns the
+// # of keys that were moved.
+func (dal *DAL) migratePingKeys() (moved int, err error) {
+	type kv struct{ k, v []byte }
+	for done := false; !done; {
+		var batch []kv
+		err = dal.update(func(tx *bolt.Tx) error {
+			legacy := tx.Bucket([]byte(dal.legacyPingsBucket))
+			if legacy == nil {
+				done = true
+				return nil
+			}
+			pings := tx.Bucket([]byte(dal.pingsBucket))
+			if pings == nil {
+				return fmt.Errorf("%s %s", BucketNotFoundError, dal.pingsBucket)
+			}
+
+			// moved keys are deleted, so every batch starts at the first key
+			c := legacy.Cursor()
+			for k, v := c.First(); k != nil && len(batch) < upgradeBatchSize; k, v = c.Next() {
+				batch = append(batch, kv{append([]byte{}, k...), append([]byte{}, v...)})
+			}
+
+			for _, e := range batch {
+				series, t, err := parseLegacyPingKey(e.k)
+				if err != nil {
+					return fmt.Errorf("%s: %s", e.k, err)
+				}
+				id, err := dal.createTargetID(tx, series)
+				if err != nil {
+					return err
+				}
+				v, err := upgradePingRes(e.v)
+				if err != nil {
+					return fmt.Errorf("%s: %s", e.k, err)
+				}
+
+				key := GetPingKey(id, t)
+				if saved := pings.Get(key); saved != nil {
+					if saved, err = upgradePingRes(saved); err != nil {
+						return fmt.Errorf("%s: %s", e.k, err)
+					}
+					v = sortPingRes(append(append([]byte{}, saved...), v...))
+				}
+				if err := pings.Put(key, v); err != nil {
+					return err
+				}
+				if err := legacy.Delete(e.k); err != nil {
+					return err
+				}
+			}
+
+			if len(batch) < upgradeBatchSize {
+				done = true
+				if err := dal.migrateIPStatsKeys(tx); err != nil {
+					return err
+				}
+				return tx.DeleteBucket([]byte(dal.legacyPingsBucket))
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, fmt.Errorf("dal.migratePingKeys: %s", err)
+		}
+		moved += len(batch)
+	}
+	return moved, nil
+}
+
+// sortPingRes sorts the v3 records of v by the time they started at
+func sortPingRes(v []byte) []byte {
+	records := make([][]byte, 0, len(v)/PingResV3ByteCount)
+	for i := 0; i+PingResV3ByteCount <= len(v); i += PingResV3ByteCount {
+		records = append(records, v[i:i+PingResV3ByteCount])
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return binary.LittleEndian.Uint16(records[i][pingResMillisOffset:]) <
+			binary.LittleEndian.Uint16(records[j][pingResMillisOffset:])
+	})
+	return bytes.Join(records, nil)
+}
+
+// migrateIPStatsKeys rewrites the ping keys of every IPStats w/ GetPingKey
+func (dal *DAL) migrateIPStatsKeys(tx *bolt.Tx) error {
+	bucket := tx.Bucket([]byte(dal.ipStatsBucket))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, dal.ipStatsBucket)
+	}
+
+	var all []*IPStats
+	err := bucket.ForEach(func(k, v []byte) error {
+		var stats IPStats
+		if err := json.Unmarshal(v, &stats); err != nil {
+			return fmt.Errorf("%s: %s: %s", IPStatsDerserializationError, k, err)
+		}
+		all = append(all, &stats)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, stats := range all {
+		id, err := dal.createTargetID(tx, stats.IP)
+		if err != nil {
+			return err
+		}
+		stats.FirstPingKey = FormatPingKey(GetPingKey(id, stats.FirstPingTime))
+		stats.LastPingKey = FormatPingKey(GetPingKey(id, stats.LastPingTime))
+		if err := dal.SaveIPStatsInBucket(stats, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recountIPStats recounts the pings of every IPStats as of now, ever from the
+// daily rollups, the last day from the hourly ones & the last hour from the
+// pings. Pings whose daily rollups were pruned aren't counted.
+func (dal *DAL) recountIPStats(now time.Time) error {
+	err := dal.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dal.ipStatsBucket))
+		if bucket == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, dal.ipStatsBucket)
+		}
+
+		var all []*IPStats
+		err := bucket.ForEach(func(k, v []byte) error {
+			var stats IPStats
+			if err := json.Unmarshal(v, &stats); err != nil {
+				return fmt.Errorf("%s: %s: %s", IPStatsDerserializationError, k, err)
+			}
+			all = append(all, &stats)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, stats := range all {
+			stats.Received, stats.Lost = 0, 0
+			stats.Minutes, stats.Hours = nil, nil
+			id, ok, err := dal.getTargetID(tx, stats.IP)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := dal.recountTarget(tx, id, stats, now); err != nil {
+					return fmt.Errorf("%s: %s", stats.IP, err)
+				}
+				if err := dal.saveSlots(tx, id, stats); err != nil {
+					return fmt.Errorf("%s: %s", stats.IP, err)
+				}
+			}
+			if err := dal.SaveIPStatsInBucket(stats, bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("dal.recountIPStats: %s", err)
+	}
+	return nil
+}
+
+// recountTarget counts the pings of the target w/ the given ID into stats, see
+// recountIPStats
+func (dal *DAL) recountTarget(tx *bolt.Tx, id uint32, stats *IPStats, now time.Time) error {
+	err := forEachKey(tx, dal.dailyBucket, id, time.Time{}, func(start time.Time, v []byte) error {
+		var r Rollup
+		if err := r.UnmarshalBinary(v); err != nil {
+			return err
+		}
+		stats.Received += uint64(r.Received)
+		stats.Lost += uint64(r.Lost)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ring := range stats.rings() {
+		bucket, rollup := dal.hourlyBucket, unmarshalRollup
+		if ring.period == time.Minute {
+			bucket, rollup = dal.pingsBucket, rollupPings
+		}
+		err := forEachKey(tx, bucket, id, ringStart(ring.count, ring.period, now), func(start time.Time, v []byte) error {
+			r, err := rollup(v)
+			if err != nil {
+				return err
+			}
+			*ring.slots = mergeSlot(*ring.slots, ring.count, ring.period, r.statsSlot(start))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachKey calls fn w/ the start & value of every key of the target w/ the
+// given ID in bucket, from the minute of since on
+func forEachKey(tx *bolt.Tx, bucketName string, id uint32, since time.Time, fn func(start time.Time, v []byte) error) error {
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, bucketName)
+	}
+	pre := getPingKeyPrefix(id)
+	c := bucket.Cursor()
+	for k, v := c.Seek(GetPingKey(id, since)); k != nil && bytes.HasPrefix(k, pre); k, v = c.Next() {
+		_, start, err := ParsePingKey(k)
+		if err != nil {
+			return fmt.Errorf("%s: %x", err, k)
+		}
+		if err := fn(start, v); err != nil {
+			return fmt.Errorf("%s: %x", err, k)
+		}
+	}
+	return nil
+}
+
+// legacyHistoryTimeFormat is the timestamp of a legacy history key
+const legacyHistoryTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// getLegacyHistoryKey returns the key history was saved under before schema
+// version 6
+// Format: <series>_<UTC timestamp>, ex: dns:google.com@8.8.8.8:53_2015-01-01T12:30:00.000000000Z
+func getLegacyHistoryKey(series string, t time.Time) []byte {
+	return []byte(series + legacyPingKeySeparator + t.UTC().Format(legacyHistoryTimeFormat))
+}
+
+// parseLegacyHistoryKey does the opposite of getLegacyHistoryKey, the key is
+// split on the last separator as the series can contain one
+func parseLegacyHistoryKey(key []byte) (series string, t time.Time, err error) {
+	i := bytes.LastIndex(key, []byte(legacyPingKeySeparator))
+	if i <= 0 {
+		return "", time.Time{}, fmt.Errorf("parseLegacyHistoryKey(): %s", InvalidKeyError)
+	}
+	t, err = time.Parse(legacyHistoryTimeFormat, string(key[i+len(legacyPingKeySeparator):]))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("parseLegacyHistoryKey(): %s: %s", InvalidKeyError, err)
+	}
+	return string(key[:i]), t, nil
+}
+
+// migrateHistoryKeys rekeys every legacy history key w/ GetHistoryKey. Keys
+// saved by this version before the db was migrated are left as they are. It
+// returns the # of keys that were moved.
+func (dal *DAL) migrateHistoryKeys() (moved int, err error) {
+	err = dal.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dal.historyBucket))
+		if bucket == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, dal.historyBucket)
+		}
+
+		type kv struct{ k, v []byte }
+		var legacy []kv
+		err := bucket.ForEach(func(k, v []byte) error {
+			if len(k) != HistoryKeyByteCount { // legacy keys are always longer
+				legacy = append(legacy, kv{append([]byte{}, k...), append([]byte{}, v...)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range legacy {
+			series, t, err := parseLegacyHistoryKey(e.k)
+			if err != nil {
+				return fmt.Errorf("%s: %s", e.k, err)
+			}
+			id, err := dal.createTargetID(tx, series)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(GetHistoryKey(id, t), e.v); err != nil {
+				return err
+			}
+			if err := bucket.Delete(e.k); err != nil {
+				return err
+			}
+		}
+		moved = len(legacy)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("dal.migrateHistoryKeys: %s", err)
+	}
+	return moved, nil
+}
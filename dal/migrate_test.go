@@ -0,0 +1,181 @@
+package dal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_migrate_unit(t *testing.T) {
+	Convey("PendingMigrations()", t, func() {
+		So(len(PendingMigrations(1)), ShouldEqual, len(migrations))
+		So(PendingMigrations(SchemaVersion), ShouldBeEmpty)
+		for i, m := range migrations {
+			So(m.Version, ShouldEqual, i+2) // every version has a migration, in order
+		}
+	})
+}
+
+func Test_migrate_integration(t *testing.T) {
+	Convey("migrate", t, func() {
+		dal := NewDAL()
+		So(dal.Open(), ShouldBeNil)
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+
+		Convey("SchemaVersion() of a db w/o buckets should be the latest version", func() {
+			version, err := dal.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, SchemaVersion)
+		})
+		Convey("CreateBuckets() should stamp a new db w/ the latest version", func() {
+			So(dal.CreateBuckets(), ShouldBeNil)
+			version, err := dal.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, SchemaVersion)
+		})
+
+		Convey("given a db of a version of pinghist w/o a schema version", func() {
+			startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+			phx := time.FixedZone("MST", -7*60*60)
+			err := dal.db.Update(func(tx *bolt.Tx) error {
+				pings, err := tx.CreateBucket([]byte(dal.legacyPingsBucket))
+				if err != nil {
+					return err
+				}
+				// the same minute saved in 2 time zones, ex: the laptop pinghist runs on moved
+				if err := pings.Put(getLegacyPingKey("10.0.0.1", startTime), SerializePingRes(startTime.Add(30*time.Second), 2)); err != nil {
+					return err
+				}
+				if err := pings.Put(getLegacyPingKey("10.0.0.1", startTime.In(phx)), SerializePingRes(startTime.Add(10*time.Second), 4)); err != nil {
+					return err
+				}
+				if err := pings.Put(getLegacyPingKey("10.0.0.1", startTime.Add(time.Minute)), SerializePingRes(startTime, 6)); err != nil {
+					return err
+				}
+
+				stats, err := tx.CreateBucket([]byte(dal.ipStatsBucket))
+				if err != nil {
+					return err
+				}
+				return dal.SaveIPStatsInBucket(&IPStats{
+					IP:            "10.0.0.1",
+					FirstPingKey:  string(getLegacyPingKey("10.0.0.1", startTime)),
+					FirstPingTime: startTime,
+					LastPingKey:   string(getLegacyPingKey("10.0.0.1", startTime.Add(time.Minute))),
+					LastPingTime:  startTime.Add(time.Minute),
+				}, stats)
+			})
+			So(err, ShouldBeNil)
+			So(dal.CreateBuckets(), ShouldBeNil)
+
+			Convey("SchemaVersion() should be 1", func() {
+				version, err := dal.SchemaVersion()
+				So(err, ShouldBeNil)
+				So(version, ShouldEqual, 1)
+			})
+			Convey("Migrate() should run every migration in order", func() {
+				for _, m := range PendingMigrations(1) {
+					So(dal.Migrate(m), ShouldBeNil)
+				}
+				version, err := dal.SchemaVersion()
+				So(err, ShouldBeNil)
+				So(version, ShouldEqual, SchemaVersion)
+
+				groups, err := dal.GetPings("10.0.0.1", startTime, startTime.Add(2*time.Minute), 20*time.Second)
+				So(err, ShouldBeNil)
+				So(len(groups), ShouldEqual, 4)
+				So(groups[0].Received, ShouldEqual, 1)
+				So(groups[0].AvgTime, ShouldEqual, 4) // merged in the order the pings started
+				So(groups[1].Received, ShouldEqual, 1)
+				So(groups[1].AvgTime, ShouldEqual, 2)
+				So(groups[3].Received, ShouldEqual, 1)
+				So(groups[3].AvgTime, ShouldEqual, 6)
+
+				stats, err := dal.GetIPStats("10.0.0.1")
+				So(err, ShouldBeNil)
+				So(stats.FirstPingKey, ShouldEqual, FormatPingKey(getPingKey(dal, "10.0.0.1", startTime)))
+				So(stats.LastPingKey, ShouldEqual, FormatPingKey(getPingKey(dal, "10.0.0.1", startTime.Add(time.Minute))))
+				So(stats.Received, ShouldEqual, 3)
+				So(stats.Lost, ShouldEqual, 0)
+				dal.view(func(tx *bolt.Tx) error {
+					So(tx.Bucket([]byte(dal.legacyPingsBucket)), ShouldBeNil)
+					return nil
+				})
+			})
+			Convey("recountIPStats() should count the pings SavePing() would have", func() {
+				for _, m := range PendingMigrations(1) {
+					So(dal.Migrate(m), ShouldBeNil)
+				}
+				now := time.Now().Truncate(time.Minute)
+				for i := 0; i < 3*60; i++ {
+					resTime := float32(i % 7)
+					if i%10 == 0 {
+						resTime = -1
+					}
+					So(dal.SavePing("10.0.0.1", now.Add(time.Duration(i-150)*time.Minute+time.Second), resTime), ShouldBeNil)
+				}
+				saved, err := dal.GetIPStats("10.0.0.1")
+				So(err, ShouldBeNil)
+				So(saved.Received, ShouldEqual, 3+162)
+				So(saved.Lost, ShouldEqual, 18)
+
+				So(dal.recountIPStats(now.Add(30*time.Minute)), ShouldBeNil)
+				recounted, err := dal.GetIPStats("10.0.0.1")
+				So(err, ShouldBeNil)
+				So(recounted.Received, ShouldEqual, saved.Received)
+				So(recounted.Lost, ShouldEqual, saved.Lost)
+				So(recounted.LastHour(now.Add(30*time.Minute)), ShouldResemble, saved.LastHour(now.Add(30*time.Minute)))
+				So(recounted.LastDay(now.Add(30*time.Minute)), ShouldResemble, saved.LastDay(now.Add(30*time.Minute)))
+				So(recounted.LastDay(now.Add(30*time.Minute)).Count, ShouldEqual, 180)
+			})
+			Convey("migrateHistoryKeys() should rekey the legacy history by target ID", func() {
+				err := dal.db.Update(func(tx *bolt.Tx) error {
+					history := tx.Bucket([]byte(dal.historyBucket))
+					if err := history.Put(getLegacyHistoryKey("http://a/b", startTime), []byte("a")); err != nil {
+						return err
+					}
+					return history.Put(getLegacyHistoryKey("http://a/b_c", startTime.Add(time.Minute)), []byte("b"))
+				})
+				So(err, ShouldBeNil)
+				_, err = dal.SaveHistory("http://a/b", startTime.Add(2*time.Minute), "c")
+				So(err, ShouldBeNil)
+
+				moved, err := dal.migrateHistoryKeys()
+				So(err, ShouldBeNil)
+				So(moved, ShouldEqual, 2)
+				entries, err := dal.GetHistory("http://a/b", startTime, startTime.Add(time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(entries[0].Value, ShouldEqual, "a")
+				So(entries[1].Value, ShouldEqual, "c")
+				entries, err = dal.GetHistory("http://a/b_c", startTime, startTime.Add(time.Hour))
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].Time, ShouldHappenOnOrBetween, startTime.Add(time.Minute), startTime.Add(time.Minute))
+			})
+			Convey("Migrate() should return error when a migration is skipped", func() {
+				err := dal.Migrate(Migration{Version: 3, Migrate: func(*DAL) error { return nil }})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, MigrationOrderError)
+			})
+			Convey("Backup() should copy the db, but not over another file", func() {
+				backup := dal.fileName + ".bak"
+				defer os.Remove(backup)
+				So(dal.Backup(backup), ShouldBeNil)
+				So(dal.Backup(backup), ShouldNotBeNil)
+
+				copied := NewDALWithPath(backup)
+				So(copied.Open(), ShouldBeNil)
+				defer copied.Close()
+				v := copied.Get(string(getLegacyPingKey("10.0.0.1", startTime)), copied.legacyPingsBucket)
+				So(len(v), ShouldEqual, PingResByteCount)
+			})
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package dal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultFileName = "pinghist.db"
+	// PathEnvVar overrides the default location of the db
+	PathEnvVar = "PINGHIST_DB"
+)
+
+// DefaultPath returns the location of the db when one isn't given explicitly.
+// In order of precedence:
+//
+//	$PINGHIST_DB
+//	$XDG_DATA_HOME/pinghist/pinghist.db
+//	~/.local/share/pinghist/pinghist.db
+//	./pinghist.db, only if the home dir can't be found
+func DefaultPath() string {
+	if p := os.Getenv(PathEnvVar); p != "" {
+		return p
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil || home == "" {
+			return DefaultFileName
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dataHome, "pinghist", DefaultFileName)
+}
+
+// NewDALWithPath creates a new Data Access Layer that stores its data in the
+// file at dbPath, the parent dir is created on Open if it doesn't exist
+func NewDALWithPath(dbPath string) *DAL {
+	dal := NewDAL()
+	dal.path, dal.fileName = filepath.Split(dbPath)
+	return dal
+}
+
+// Path returns the location of the db file
+func (dal *DAL) Path() string {
+	return filepath.Join(dal.path, dal.fileName)
+}
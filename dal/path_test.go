@@ -0,0 +1,68 @@
+package dal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_path_unit(t *testing.T) {
+	Convey("DefaultPath()", t, func() {
+		env, xdg, home := os.Getenv(PathEnvVar), os.Getenv("XDG_DATA_HOME"), os.Getenv("HOME")
+		Reset(func() {
+			os.Setenv(PathEnvVar, env)
+			os.Setenv("XDG_DATA_HOME", xdg)
+			os.Setenv("HOME", home)
+		})
+		os.Setenv(PathEnvVar, "")
+		os.Setenv("XDG_DATA_HOME", "")
+		os.Setenv("HOME", "/home/pinghist")
+
+		Convey("should use $PINGHIST_DB when it's set", func() {
+			os.Setenv(PathEnvVar, "/tmp/custom.db")
+			os.Setenv("XDG_DATA_HOME", "/xdg")
+			So(DefaultPath(), ShouldEqual, "/tmp/custom.db")
+		})
+		Convey("should use $XDG_DATA_HOME when it's set", func() {
+			os.Setenv("XDG_DATA_HOME", "/xdg")
+			So(DefaultPath(), ShouldEqual, "/xdg/pinghist/pinghist.db")
+		})
+		Convey("should fall back to ~/.local/share", func() {
+			So(DefaultPath(), ShouldEqual, "/home/pinghist/.local/share/pinghist/pinghist.db")
+		})
+	})
+
+	Convey("NewDALWithPath()", t, func() {
+		Convey("should split the path into dir and file name", func() {
+			dal := NewDALWithPath("/var/lib/pinghist/history.db")
+			So(dal.path, ShouldEqual, "/var/lib/pinghist/")
+			So(dal.fileName, ShouldEqual, "history.db")
+			So(dal.Path(), ShouldEqual, "/var/lib/pinghist/history.db")
+		})
+		Convey("should use the current dir when there's no dir", func() {
+			dal := NewDALWithPath("history.db")
+			So(dal.Path(), ShouldEqual, "history.db")
+		})
+	})
+}
+
+func Test_path_integration(t *testing.T) {
+	Convey("Open()", t, func() {
+		dir := filepath.Join(os.TempDir(), "pinghist_path_test")
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		Convey("should create the parent dir of the db", func() {
+			dal := NewDALWithPath(filepath.Join(dir, "nested", "pinghist.db"))
+			err := dal.Open()
+			So(err, ShouldBeNil)
+			defer dal.Close()
+
+			_, err = os.Stat(dal.Path())
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -5,19 +5,51 @@ import (
 	"time"
 )
 
-// PingGroup is used to summarize the output of the pings_by_minute bucket
+// PingGroup is used to summarize the output of the pings bucket
 type PingGroup struct {
 	Start     time.Time
 	End       time.Time
-	Received  int     // # of ping packets received
-	Timedout  int     // # packets timed out
-	TotalTime float64 // sum of resTime of all received
-	AvgTime   float64 // TotalTime / Recieved
-	StdDev    float64 // for AvgTime
+	Received  int                // # of ping packets received
+	Timedout  int                // # packets lost, for any reason
+	LostBy    map[FailReason]int // # packets lost by reason, pings lost before reasons were recorded are timeouts
+	TotalTime float64            // sum of resTime of all received
+	AvgTime   float64            // TotalTime / Recieved
+	StdDev    float64            // for AvgTime
 	MaxTime   float64
 	MinTime   float64
-	keys      []string  // used for debugging
-	resTimes  []float64 // Response times, used to calc std dev, nil after calling calcAvgAndStdDev()
+	MinTTL    uint8 // of the replies, 0 when none of them had one
+	MaxTTL    uint8
+	Histogram [HistogramBucketCount]int // # received by response time, see HistogramBounds
+	keys      []string                  // used for debugging
+	resTimes  []float64                 // Response times, used to calc std dev, nil after calling calcAvgAndStdDev()
+}
+
+// addPingRes adds a ping to the group, lost pings (resTime < 0) are counted by reason
+func (pg *PingGroup) addPingRes(resTime float64, reason FailReason) {
+	pg.addResTime(resTime)
+	if resTime >= 0 {
+		return
+	}
+	if reason == ReasonNone {
+		reason = ReasonTimeout // every lost ping was a timeout before reasons were recorded
+	}
+	if pg.LostBy == nil {
+		pg.LostBy = map[FailReason]int{}
+	}
+	pg.LostBy[reason]++
+}
+
+// addTTL adds the TTL of a reply to the group, 0 is unknown
+func (pg *PingGroup) addTTL(ttl uint8) {
+	if ttl == 0 {
+		return
+	}
+	if pg.MinTTL == 0 || ttl < pg.MinTTL {
+		pg.MinTTL = ttl
+	}
+	if ttl > pg.MaxTTL {
+		pg.MaxTTL = ttl
+	}
 }
 
 // addResTime will add a ping response time to group
@@ -32,6 +64,7 @@ func (pg *PingGroup) addResTime(resTime float64) {
 			pg.MaxTime = resTime
 		}
 		pg.resTimes = append(pg.resTimes, resTime)
+		pg.Histogram[histogramBucket(resTime)]++
 	} else {
 		pg.Timedout++
 	}
@@ -58,6 +91,48 @@ func (pg *PingGroup) calcAvgAndStdDev() {
 	pg.resTimes = nil // free this mem
 }
 
+// Merge adds the pings of o to pg, ex: the pings of another period. The avg & std
+// dev of both groups must have been calculated, they're combined w/o the response
+// times w/ the parallel algorithm:
+// https://en.wikipedia.org/wiki/Algorithms_for_calculating_variance#Parallel_algorithm
+func (pg *PingGroup) Merge(o *PingGroup) {
+	if o.Start.Before(pg.Start) {
+		pg.Start = o.Start
+	}
+	if o.End.After(pg.End) {
+		pg.End = o.End
+	}
+
+	if o.Received > 0 {
+		if pg.Received == 0 || o.MinTime < pg.MinTime {
+			pg.MinTime = o.MinTime
+		}
+		if o.MaxTime > pg.MaxTime {
+			pg.MaxTime = o.MaxTime
+		}
+		n, on := float64(pg.Received), float64(o.Received)
+		delta := o.AvgTime - pg.AvgTime
+		sumDiffSq := pg.StdDev*pg.StdDev*n + o.StdDev*o.StdDev*on + delta*delta*n*on/(n+on)
+		pg.Received += o.Received
+		pg.TotalTime += o.TotalTime
+		pg.AvgTime = pg.TotalTime / float64(pg.Received)
+		pg.StdDev = math.Sqrt(sumDiffSq / float64(pg.Received))
+	}
+
+	pg.addTTL(o.MinTTL)
+	pg.addTTL(o.MaxTTL)
+	for i, n := range o.Histogram {
+		pg.Histogram[i] += n
+	}
+	pg.Timedout += o.Timedout
+	for reason, n := range o.LostBy {
+		if pg.LostBy == nil {
+			pg.LostBy = map[FailReason]int{}
+		}
+		pg.LostBy[reason] += n
+	}
+}
+
 func NewPingGroup(start, end time.Time) *PingGroup {
 	pg := &PingGroup{
 		Start:     start,
@@ -71,3 +146,60 @@ func NewPingGroup(start, end time.Time) *PingGroup {
 	}
 	return pg
 }
+
+// grouper puts pings into consecutive groups of groupBy, from start. Pings &
+// rollups must be added in the order they started
+type grouper struct {
+	groupBy time.Duration
+	groups  []*PingGroup
+	curr    *PingGroup
+	rolled  *PingGroup // the rollups of curr, merged into it once its avg & std dev are calculated
+}
+
+func newGrouper(start time.Time, groupBy time.Duration) *grouper {
+	return &grouper{groupBy: groupBy, curr: NewPingGroup(start, start.Add(groupBy))}
+}
+
+// group returns the group of a ping that started at t, creating the groups
+// before it, even empty ones. It's nil when t is before the current group.
+func (g *grouper) group(t time.Time) *PingGroup {
+	if t.Before(g.curr.Start) {
+		return nil
+	}
+	for !t.Before(g.curr.End) {
+		g.next()
+	}
+	return g.curr
+}
+
+// addRollup adds the pings of a rollup, which must fit in a group
+func (g *grouper) addRollup(r *PingGroup) {
+	if g.group(r.Start) == nil {
+		return
+	}
+	if g.rolled == nil {
+		g.rolled = NewPingGroup(g.curr.Start, g.curr.End)
+	}
+	g.rolled.Merge(r)
+}
+
+// next finishes the current group & starts the one after it
+func (g *grouper) next() {
+	g.finish()
+	g.curr = NewPingGroup(g.curr.End, g.curr.End.Add(g.groupBy))
+}
+
+func (g *grouper) finish() {
+	g.curr.calcAvgAndStdDev()
+	if g.rolled != nil {
+		g.curr.Merge(g.rolled)
+		g.rolled = nil
+	}
+	g.groups = append(g.groups, g.curr)
+}
+
+// done finishes the current group & returns every group
+func (g *grouper) done() []*PingGroup {
+	g.finish()
+	return g.groups
+}
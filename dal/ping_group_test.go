@@ -73,5 +73,45 @@ func Test_ping_group_unit(t *testing.T) {
 			})
 		})
 
+		Convey("Merge()", func() {
+			Convey("should be the same as one group w/ the pings of both", func() {
+				resTimes := []float64{10.190, 17.039, -1, 14.165, 13.950, 40.791, 9.689, -1, 15.019}
+				all := NewPingGroup(time.Now(), time.Now())
+				a := NewPingGroup(time.Now(), time.Now())
+				b := NewPingGroup(time.Now(), time.Now())
+				for i, resTime := range resTimes {
+					all.addPingRes(resTime, ReasonTimeout)
+					if i < 4 {
+						a.addPingRes(resTime, ReasonTimeout)
+					} else {
+						b.addPingRes(resTime, ReasonTimeout)
+					}
+				}
+				all.calcAvgAndStdDev()
+				a.calcAvgAndStdDev()
+				b.calcAvgAndStdDev()
+
+				a.Merge(b)
+				So(a.Received, ShouldEqual, all.Received)
+				So(a.Timedout, ShouldEqual, all.Timedout)
+				So(a.LostBy[ReasonTimeout], ShouldEqual, 2)
+				So(a.MinTime, ShouldEqual, all.MinTime)
+				So(a.MaxTime, ShouldEqual, all.MaxTime)
+				So(a.AvgTime, ShouldAlmostEqual, all.AvgTime, 1e-9)
+				So(a.StdDev, ShouldAlmostEqual, all.StdDev, 1e-9)
+			})
+			Convey("should keep the min of a group w/o received pings", func() {
+				b := NewPingGroup(time.Now(), time.Now())
+				b.addResTime(5)
+				b.calcAvgAndStdDev()
+				pg.addResTime(-1)
+				pg.calcAvgAndStdDev()
+
+				pg.Merge(b)
+				So(pg.MinTime, ShouldEqual, 5)
+				So(pg.AvgTime, ShouldEqual, 5)
+				So(pg.Timedout, ShouldEqual, 1)
+			})
+		})
 	})
 }
@@ -0,0 +1,223 @@
+package dal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Every ping is saved as a fixed size record appended to the value of the key
+// of its minute, see GetPingKey. Version 1 records (see SerializePingRes) have
+// no marker, later versions set the high bits of their first byte, the seconds
+// offset of v1 & v2 never uses them. The records of a key are all the same
+// version, the older records of a key are upgraded when a v3 record is
+// appended to it, see UpgradePings to upgrade every key.
+//
+// v2: 10 bytes
+// | 1 byte         | 1 byte     | 4 bytes | 1 byte    | 1 byte | 2 bytes
+// | 0x80 | seconds | FailReason | resTime | ICMP code | TTL    | size
+//
+// v3: 12 bytes, the ms offset is the ms into the minute the ping started
+// | 1 byte | 1 byte     | 4 bytes | 1 byte    | 1 byte | 2 bytes | 2 bytes
+// | 0xC0   | FailReason | resTime | ICMP code | TTL    | size    | ms offset
+const (
+	PingResV2ByteCount = 10
+	PingResV3ByteCount = 12
+
+	pingResVersionMask  = 0xC0
+	pingResV2Marker     = 0x80
+	pingResV3Marker     = 0xC0
+	pingResTTLOffset    = 7
+	pingResSizeOffset   = 8
+	pingResMillisOffset = 10
+)
+
+// PingRes is one ping of a minute
+type PingRes struct {
+	Second  uint8      // the second of the minute the ping started in
+	Milli   uint16     // the ms of the second the ping started in, 0 for records before v3
+	ResTime float32    // ms, -1 when lost
+	Reason  FailReason // why the ping was lost, ReasonNone when it wasn't
+	Code    uint8      // the code of the ICMP error the ping was lost to, if any
+	TTL     uint8      // of the reply, 0 when unknown
+	Size    uint16     // bytes of the reply, 0 when unknown
+}
+
+// NewPingRes returns the PingRes of a ping started at startTime
+func NewPingRes(startTime time.Time, resTime float32) *PingRes {
+	return &PingRes{
+		Second:  uint8(startTime.Second()),
+		Milli:   uint16(startTime.Nanosecond() / int(time.Millisecond)),
+		ResTime: resTime,
+	}
+}
+
+// NewLostPingRes returns the record of a ping lost for reason, code is the ICMP
+// code when it was lost to an ICMP error (0 otherwise)
+func NewLostPingRes(startTime time.Time, reason FailReason, code uint8) *PingRes {
+	pr := NewPingRes(startTime, -1)
+	pr.Reason, pr.Code = reason, code
+	return pr
+}
+
+// Offset returns how far into its minute the ping started
+func (pr *PingRes) Offset() time.Duration {
+	return time.Duration(pr.Second)*time.Second + time.Duration(pr.Milli)*time.Millisecond
+}
+
+// MarshalBinary returns pr as a v3 record
+func (pr *PingRes) MarshalBinary() ([]byte, error) {
+	if pr.Second > 59 || pr.Milli > 999 {
+		return nil, errors.New(TimeDeserializationError)
+	}
+	b := make([]byte, PingResV3ByteCount)
+	b[0] = pingResV3Marker
+	b[pingResReasonOffset] = byte(pr.Reason)
+	copy(b[pingResReasonOffset+1:], Float32bytes(pr.ResTime))
+	b[pingResCodeOffset] = pr.Code
+	b[pingResTTLOffset] = pr.TTL
+	binary.LittleEndian.PutUint16(b[pingResSizeOffset:], pr.Size)
+	binary.LittleEndian.PutUint16(b[pingResMillisOffset:], uint16(pr.Offset()/time.Millisecond))
+	return b, nil
+}
+
+// UnmarshalBinary reads a v1, v2 or v3 record into pr, v1 records have no TTL
+// or size & records before v3 no ms
+func (pr *PingRes) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || len(data) != pingResSize(data) {
+		return errors.New(InvalidByteLength)
+	}
+
+	*pr = PingRes{
+		ResTime: Float32frombytes(data[pingResReasonOffset+1 : pingResReasonOffset+1+PingResTimeByteCount]),
+		Reason:  FailReason(data[pingResReasonOffset]),
+		Code:    data[pingResCodeOffset],
+	}
+	switch len(data) {
+	case PingResByteCount:
+		pr.Second = data[0]
+	case PingResV2ByteCount:
+		pr.Second = data[0] &^ pingResV2Marker
+	case PingResV3ByteCount:
+		ms := binary.LittleEndian.Uint16(data[pingResMillisOffset:])
+		if ms >= 60000 {
+			return errors.New(TimeDeserializationError)
+		}
+		pr.Second, pr.Milli = uint8(ms/1000), ms%1000
+	}
+	if pr.Second > 59 {
+		return errors.New(TimeDeserializationError)
+	}
+	if len(data) >= PingResV2ByteCount {
+		pr.TTL = data[pingResTTLOffset]
+		pr.Size = binary.LittleEndian.Uint16(data[pingResSizeOffset:])
+	}
+	return nil
+}
+
+// pingResSize returns the size of the records of v, the value of a ping key
+func pingResSize(v []byte) int {
+	if len(v) == 0 {
+		return PingResByteCount
+	}
+	switch v[0] & pingResVersionMask {
+	case pingResV3Marker:
+		return PingResV3ByteCount
+	case pingResV2Marker:
+		return PingResV2ByteCount
+	}
+	return PingResByteCount
+}
+
+// upgradePingRes returns the records of v, the value of a ping key, as v3 records
+func upgradePingRes(v []byte) ([]byte, error) {
+	size := pingResSize(v)
+	if size == PingResV3ByteCount {
+		return v, nil
+	}
+	if len(v)%size != 0 {
+		return nil, errors.New(InvalidByteLength)
+	}
+
+	upgraded := make([]byte, 0, len(v)/size*PingResV3ByteCount+PingResV3ByteCount)
+	for i := 0; i < len(v); i += size {
+		var pr PingRes
+		if err := pr.UnmarshalBinary(v[i : i+size]); err != nil {
+			return nil, err
+		}
+		b, err := pr.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		upgraded = append(upgraded, b...)
+	}
+	return upgraded, nil
+}
+
+// upgradeBatchSize is the max # of keys UpgradePings rewrites per transaction
+const upgradeBatchSize = 1000
+
+// UpgradePings rewrites every key of the pings bucket w/ records older than v3
+// as v3 records, so they can be read w/o upgrading them first. Keys are also
+// upgraded one at a time when a ping is saved to them, this upgrades the rest.
+// It returns the # of keys that were upgraded. Keys are rewritten in batches,
+// the ones upgraded before an error stay upgraded.
+func (dal *DAL) UpgradePings() (int, error) {
+	upgraded, err := dal.upgradePings(dal.pingsBucket)
+	if err != nil {
+		return upgraded, fmt.Errorf("dal.UpgradePings: %s", err)
+	}
+	return upgraded, nil
+}
+
+// upgradePings is UpgradePings for the given bucket, dbs before schema version
+// 3 keep their pings in another bucket, see migratePingKeys. There's nothing to
+// upgrade when the bucket doesn't exist
+func (dal *DAL) upgradePings(bucket string) (upgraded int, err error) {
+	type kv struct{ k, v []byte }
+	var next []byte // the first key of the next batch
+	for done := false; !done; {
+		var batch []kv
+		err = dal.update(func(tx *bolt.Tx) error {
+			pings := tx.Bucket([]byte(bucket))
+			if pings == nil {
+				done = true
+				return nil
+			}
+
+			batch = batch[:0]
+			c := pings.Cursor()
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			for ; k != nil && len(batch) < upgradeBatchSize; k, v = c.Next() {
+				if len(v) == 0 || pingResSize(v) == PingResV3ByteCount {
+					continue
+				}
+				uv, err := upgradePingRes(v)
+				if err != nil {
+					return fmt.Errorf("%s: %s", k, err)
+				}
+				batch = append(batch, kv{append([]byte{}, k...), uv})
+			}
+			done = k == nil
+			next = append([]byte{}, k...)
+
+			for _, e := range batch {
+				if err := pings.Put(e.k, e.v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return upgraded, err
+		}
+		upgraded += len(batch)
+	}
+	return upgraded, nil
+}
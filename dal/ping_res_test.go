@@ -0,0 +1,158 @@
+package dal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ping_res_unit(t *testing.T) {
+	Convey("PingRes", t, func() {
+		startTime := time.Date(2015, time.January, 1, 12, 30, 5, 0, time.UTC)
+
+		Convey("should marshal & unmarshal a v3 record", func() {
+			pr := &PingRes{Second: 59, Milli: 999, ResTime: 1.5, Reason: ReasonTTLExceeded, Code: 1, TTL: 57, Size: 1008}
+			b, err := pr.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(len(b), ShouldEqual, PingResV3ByteCount)
+			So(b[0], ShouldEqual, pingResV3Marker)
+
+			var got PingRes
+			So(got.UnmarshalBinary(b), ShouldBeNil)
+			So(got, ShouldResemble, *pr)
+			reason, code := DeserializeFailReason(b)
+			So(reason, ShouldEqual, ReasonTTLExceeded)
+			So(code, ShouldEqual, 1)
+		})
+		Convey("should unmarshal a v2 record w/o ms", func() {
+			fb := Float32bytes(1.5)
+			var got PingRes
+			So(got.UnmarshalBinary([]byte{pingResV2Marker | 5, 0, fb[0], fb[1], fb[2], fb[3], 0, 57, 64, 0}), ShouldBeNil)
+			So(got, ShouldResemble, PingRes{Second: 5, ResTime: 1.5, TTL: 57, Size: 64})
+		})
+		Convey("should unmarshal a v1 record w/o a TTL or size", func() {
+			var got PingRes
+			So(got.UnmarshalBinary(SerializeLostPingRes(startTime, ReasonNetUnreachable, 9)), ShouldBeNil)
+			So(got, ShouldResemble, PingRes{Second: 5, ResTime: -1, Reason: ReasonNetUnreachable, Code: 9})
+		})
+		Convey("should return error w/ the wrong # of bytes for the version", func() {
+			var got PingRes
+			So(got.UnmarshalBinary(SerializePingRes(startTime, 1)[:6]), ShouldNotBeNil)
+			b, _ := NewPingRes(startTime, 1).MarshalBinary()
+			So(got.UnmarshalBinary(b[:PingResV2ByteCount]), ShouldNotBeNil)
+			So(got.UnmarshalBinary(nil), ShouldNotBeNil)
+		})
+		Convey("should return error w/ an offset past the minute", func() {
+			b, _ := NewPingRes(startTime, 1).MarshalBinary()
+			b[pingResMillisOffset], b[pingResMillisOffset+1] = 0x60, 0xea // 60000
+			var got PingRes
+			So(got.UnmarshalBinary(b), ShouldNotBeNil)
+			_, err := (&PingRes{Milli: 1000}).MarshalBinary()
+			So(err, ShouldNotBeNil)
+		})
+		Convey("upgradePingRes() should convert every v1 record to v3", func() {
+			v := append(SerializePingRes(startTime, 1.5), SerializeLostPingRes(startTime.Add(time.Second), ReasonDNS, 0)...)
+			upgraded, err := upgradePingRes(v)
+			So(err, ShouldBeNil)
+			So(len(upgraded), ShouldEqual, 2*PingResV3ByteCount)
+			So(pingResSize(upgraded), ShouldEqual, PingResV3ByteCount)
+
+			var got PingRes
+			So(got.UnmarshalBinary(upgraded[PingResV3ByteCount:]), ShouldBeNil)
+			So(got, ShouldResemble, PingRes{Second: 6, ResTime: -1, Reason: ReasonDNS})
+		})
+	})
+}
+
+func Test_ping_res_integration(t *testing.T) {
+	Convey("PingRes", t, func() {
+		dal := NewDAL()
+		So(dal.Open(), ShouldBeNil)
+		So(dal.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+		ip := "10.0.0.1"
+		startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+
+		Convey("should upgrade the v1 records of a minute when a v3 record is saved to it", func() {
+			key := getPingKey(dal, ip, startTime)
+			err := dal.db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte(dal.pingsBucket)).Put(key, SerializePingRes(startTime, 2))
+			})
+			So(err, ShouldBeNil)
+
+			pr := NewPingRes(startTime.Add(time.Second), 4)
+			pr.TTL, pr.Size = 57, 64
+			So(dal.SavePingRes(ip, startTime.Add(time.Second), pr), ShouldBeNil)
+			So(len(dal.Get(string(key), dal.pingsBucket)), ShouldEqual, 2*PingResV3ByteCount)
+
+			groups, err := dal.GetPings(ip, startTime, startTime.Add(time.Minute), time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 2)
+			So(groups[0].AvgTime, ShouldEqual, 3)
+			So(groups[0].MinTTL, ShouldEqual, 57)
+			So(groups[0].MaxTTL, ShouldEqual, 57)
+		})
+		Convey("GetPings() should return the range of TTLs of each group", func() {
+			for i, ttl := range []uint8{57, 57, 58, 0} {
+				pr := NewPingRes(startTime, 1)
+				pr.TTL = ttl
+				So(dal.SavePingRes(ip, startTime.Add(time.Duration(i)*time.Minute), pr), ShouldBeNil)
+			}
+			groups, err := dal.GetPings(ip, startTime, startTime.Add(4*time.Minute), 2*time.Minute)
+			So(err, ShouldBeNil)
+			So(len(groups), ShouldEqual, 2)
+			So(groups[0].MinTTL, ShouldEqual, 57)
+			So(groups[0].MaxTTL, ShouldEqual, 57)
+			So(groups[1].MinTTL, ShouldEqual, 58)
+			So(groups[1].MaxTTL, ShouldEqual, 58)
+		})
+		Convey("GetPings() should group pings by the ms they started at", func() {
+			for i := 0; i < 10; i++ {
+				So(dal.SavePing(ip, startTime.Add(time.Duration(i)*100*time.Millisecond), float32(i)), ShouldBeNil)
+			}
+			groups, err := dal.GetPings(ip, startTime, startTime.Add(time.Second), 500*time.Millisecond)
+			So(err, ShouldBeNil)
+			So(len(groups), ShouldEqual, 2)
+			So(groups[0].Received, ShouldEqual, 5)
+			So(groups[0].MaxTime, ShouldEqual, 4)
+			So(groups[1].Received, ShouldEqual, 5)
+			So(groups[1].MinTime, ShouldEqual, 5)
+		})
+		Convey("UpgradePings() should rewrite every key w/ older records as v3", func() {
+			v2, _ := NewPingRes(startTime, 3).MarshalBinary()
+			v2 = v2[:PingResV2ByteCount]
+			v2[0], v2[pingResTTLOffset], v2[pingResSizeOffset], v2[pingResSizeOffset+1] = pingResV2Marker|2, 57, 64, 0
+			key1, key2 := getPingKey(dal, ip, startTime), getPingKey(dal, ip, startTime.Add(time.Minute))
+			err := dal.db.Update(func(tx *bolt.Tx) error {
+				pings := tx.Bucket([]byte(dal.pingsBucket))
+				if err := pings.Put(key1, SerializePingRes(startTime, 2)); err != nil {
+					return err
+				}
+				return pings.Put(key2, v2)
+			})
+			So(err, ShouldBeNil)
+			So(dal.SavePing(ip, startTime.Add(2*time.Minute), 4), ShouldBeNil)
+
+			upgraded, err := dal.UpgradePings()
+			So(err, ShouldBeNil)
+			So(upgraded, ShouldEqual, 2)
+			for i := 0; i < 3; i++ {
+				So(len(dal.Get(string(getPingKey(dal, ip, startTime.Add(time.Duration(i)*time.Minute))), dal.pingsBucket)), ShouldEqual, PingResV3ByteCount)
+			}
+			groups, err := dal.GetPings(ip, startTime, startTime.Add(3*time.Minute), time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 3)
+			So(groups[0].MaxTTL, ShouldEqual, 57)
+
+			upgraded, err = dal.UpgradePings()
+			So(err, ShouldBeNil)
+			So(upgraded, ShouldEqual, 0)
+		})
+	})
+}
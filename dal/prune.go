@@ -0,0 +1,193 @@
+package dal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Retention is how long pings are kept at each resolution, 0 keeps them forever
+type Retention struct {
+	Pings  time.Duration // every ping
+	Hourly time.Duration // the hourly rollups
+	Daily  time.Duration // the daily rollups
+}
+
+// DefaultRetention keeps pings for 30 days, their hourly rollups for a year &
+// their daily rollups forever
+var DefaultRetention = Retention{Pings: 30 * 24 * time.Hour, Hourly: 365 * 24 * time.Hour}
+
+// PrunedRange is a range of a series deleted from one resolution, see Prune
+type PrunedRange struct {
+	Series     string
+	Resolution string    // pings, hourly or daily
+	Start      time.Time // of the first key deleted
+	End        time.Time // of the period of the last key deleted
+	Keys       int       // # of minutes, hours or days deleted
+}
+
+// pruneLevel is a resolution Prune deletes from
+type pruneLevel struct {
+	resolution string
+	bucket     string
+	period     time.Duration // of a key
+	keep       time.Duration
+}
+
+// Prune deletes the keys of every resolution that ended longer than its retention
+// before now, w/ dryRun nothing is deleted. It returns the ranges it deleted, by
+// series & resolution. The IPStats of a series point at its first ping left, or
+// at nothing when none are, FirstPingTime stays the time of the first ping ever.
+// When ctx is done Prune stops between batches & returns what it deleted so far
+// w/ ctx.Err().
+func (dal *DAL) Prune(ctx context.Context, r Retention, now time.Time, dryRun bool) ([]*PrunedRange, error) {
+	type target struct {
+		id     uint32
+		series string
+	}
+	var targets []target
+	err := dal.view(func(tx *bolt.Tx) error {
+		ids := tx.Bucket([]byte(dal.targetIDsBucket))
+		if ids == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, dal.targetIDsBucket)
+		}
+		return ids.ForEach(func(k, v []byte) error {
+			if len(k) != TargetIDByteCount {
+				return fmt.Errorf("%s: target %x", InvalidByteLength, k)
+			}
+			targets = append(targets, target{binary.BigEndian.Uint32(k), string(v)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dal.Prune: %s", err)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].series < targets[j].series })
+
+	levels := []pruneLevel{
+		{"pings", dal.pingsBucket, time.Minute, r.Pings},
+		{"hourly", dal.hourlyBucket, time.Hour, r.Hourly},
+		{"daily", dal.dailyBucket, 24 * time.Hour, r.Daily},
+	}
+	pruned := []*PrunedRange{}
+	for _, level := range levels {
+		if level.keep <= 0 {
+			continue
+		}
+		for _, t := range targets {
+			id, series := t.id, t.series
+			pr, err := dal.pruneTarget(ctx, level, id, now.Add(-level.keep), dryRun)
+			if pr != nil {
+				pr.Series = series
+				pruned = append(pruned, pr)
+				if level.bucket == dal.pingsBucket && !dryRun {
+					if err := dal.pruneIPStats(id, series); err != nil {
+						return pruned, fmt.Errorf("dal.Prune: %s: %s", series, err)
+					}
+				}
+			}
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				return pruned, err
+			}
+			if err != nil {
+				return pruned, fmt.Errorf("dal.Prune: %s %s: %s", series, level.resolution, err)
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// pruneTarget deletes the keys of level of the target w/ the given ID whose
+// period ended by cutoff, in batches. It returns nil when there were none, w/
+// an error it returns what the batches before it deleted.
+func (dal *DAL) pruneTarget(ctx context.Context, level pruneLevel, id uint32, cutoff time.Time, dryRun bool) (*PrunedRange, error) {
+	var pr *PrunedRange
+	run := dal.update
+	if dryRun {
+		run = dal.view
+	}
+
+	pre := getPingKeyPrefix(id)
+	next := pre // the first key of the next batch
+	for done := false; !done; {
+		if err := ctx.Err(); err != nil {
+			return pr, err
+		}
+		var before *PrunedRange // what's deleted once a failed batch is rolled back
+		if pr != nil {
+			b := *pr
+			before = &b
+		}
+		err := run(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(level.bucket))
+			if bucket == nil {
+				return fmt.Errorf("%s %s", BucketNotFoundError, level.bucket)
+			}
+
+			var batch [][]byte
+			c := bucket.Cursor()
+			k, _ := c.Seek(next)
+			for ; k != nil && bytes.HasPrefix(k, pre) && len(batch) < upgradeBatchSize; k, _ = c.Next() {
+				_, start, err := ParsePingKey(k)
+				if err != nil {
+					return fmt.Errorf("%s: %x", err, k)
+				}
+				end := start.Add(level.period)
+				if end.After(cutoff) {
+					break
+				}
+				if pr == nil {
+					pr = &PrunedRange{Resolution: level.resolution, Start: start}
+				}
+				pr.End = end
+				pr.Keys++
+				batch = append(batch, append([]byte{}, k...))
+			}
+			done = k == nil || len(batch) < upgradeBatchSize
+			next = append([]byte{}, k...)
+
+			if dryRun {
+				return nil
+			}
+			for _, k := range batch {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return before, err
+		}
+	}
+	return pr, nil
+}
+
+// pruneIPStats points the FirstPingKey of the IPStats of series at its first
+// ping left, the keys are "" when there are none
+func (dal *DAL) pruneIPStats(id uint32, series string) error {
+	return dal.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dal.ipStatsBucket))
+		stats, err := dal.GetIPStatsFromBucket(series, bucket)
+		if err != nil || stats == nil {
+			return err
+		}
+		pings := tx.Bucket([]byte(dal.pingsBucket))
+		if pings == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, dal.pingsBucket)
+		}
+
+		pre := getPingKeyPrefix(id)
+		if k, _ := pings.Cursor().Seek(pre); k != nil && bytes.HasPrefix(k, pre) {
+			stats.FirstPingKey = FormatPingKey(k)
+		} else {
+			stats.FirstPingKey, stats.LastPingKey = "", ""
+		}
+		return dal.SaveIPStatsInBucket(stats, bucket)
+	})
+}
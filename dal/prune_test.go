@@ -0,0 +1,114 @@
+package dal
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_prune_integration(t *testing.T) {
+	Convey("Prune()", t, func() {
+		dal := NewDAL()
+		So(dal.Open(), ShouldBeNil)
+		So(dal.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+
+		// a ping every 10 minutes for 3 days
+		start := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+		now := start.Add(72 * time.Hour)
+		err := dal.db.Update(func(tx *bolt.Tx) error {
+			for t := start; t.Before(now); t = t.Add(10 * time.Minute) {
+				if _, err := dal.savePingResWithTransaction("10.0.0.1", t, NewPingRes(t, 1), tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(dal.SavePing("10.0.0.1", start, 1), ShouldBeNil) // for its IPStats
+		keep := Retention{Pings: 24 * time.Hour, Hourly: 48 * time.Hour}
+
+		Convey("should delete the keys older than their retention & return the ranges", func() {
+			pruned, err := dal.Prune(context.Background(), keep, now, false)
+			So(err, ShouldBeNil)
+			So(len(pruned), ShouldEqual, 2)
+			So(pruned[0].Series, ShouldEqual, "10.0.0.1")
+			So(pruned[0].Resolution, ShouldEqual, "pings")
+			So(pruned[0].Keys, ShouldEqual, 48*6)
+			So(pruned[0].Start, ShouldHappenOnOrBetween, start, start)
+			So(pruned[0].End, ShouldHappenOnOrBetween, now.Add(-24*time.Hour-9*time.Minute), now.Add(-24*time.Hour-9*time.Minute))
+			So(pruned[1].Resolution, ShouldEqual, "hourly")
+			So(pruned[1].Keys, ShouldEqual, 24)
+
+			groups, err := dal.getPings("10.0.0.1", start, now, 24*time.Hour, false)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 0)
+			So(groups[2].Received, ShouldEqual, 6*24)
+
+			// the daily rollups are kept forever
+			groups, err = dal.GetPings("10.0.0.1", start, now, 24*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 6*24+1)
+
+			pruned, err = dal.Prune(context.Background(), keep, now, false)
+			So(err, ShouldBeNil)
+			So(pruned, ShouldBeEmpty)
+		})
+		Convey("should point FirstPingKey at the first ping left", func() {
+			_, err := dal.Prune(context.Background(), keep, now, false)
+			So(err, ShouldBeNil)
+			stats, err := dal.GetIPStats("10.0.0.1")
+			So(err, ShouldBeNil)
+			So(stats.FirstPingKey, ShouldEqual, FormatPingKey(getPingKey(dal, "10.0.0.1", now.Add(-24*time.Hour))))
+			So(stats.FirstPingTime, ShouldHappenOnOrBetween, start, start)
+
+			_, err = dal.Prune(context.Background(), Retention{Pings: time.Minute}, now.Add(time.Hour), false)
+			So(err, ShouldBeNil)
+			stats, err = dal.GetIPStats("10.0.0.1")
+			So(err, ShouldBeNil)
+			So(stats.FirstPingKey, ShouldEqual, "")
+			So(stats.LastPingKey, ShouldEqual, "")
+		})
+		Convey("should let the next ping saved set both keys once every ping is pruned", func() {
+			_, err := dal.Prune(context.Background(), Retention{Pings: time.Minute}, now.Add(time.Hour), false)
+			So(err, ShouldBeNil)
+			later := now.Add(2 * time.Hour)
+			So(dal.SavePing("10.0.0.1", later, 1), ShouldBeNil)
+
+			stats, err := dal.GetIPStats("10.0.0.1")
+			So(err, ShouldBeNil)
+			key := FormatPingKey(getPingKey(dal, "10.0.0.1", later))
+			So(stats.FirstPingKey, ShouldEqual, key)
+			So(stats.LastPingKey, ShouldEqual, key)
+			So(stats.FirstPingTime, ShouldHappenOnOrBetween, start, start)
+		})
+		Convey("should stop w/ ctx.Err() once ctx is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			pruned, err := dal.Prune(ctx, keep, now, false)
+			So(err, ShouldEqual, context.Canceled)
+			So(pruned, ShouldBeEmpty)
+
+			groups, err := dal.getPings("10.0.0.1", start, now, 24*time.Hour, false)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 6*24+1)
+		})
+		Convey("shouldn't delete anything w/ dry run", func() {
+			pruned, err := dal.Prune(context.Background(), keep, now, true)
+			So(err, ShouldBeNil)
+			So(len(pruned), ShouldEqual, 2)
+			So(pruned[0].Keys, ShouldEqual, 48*6)
+
+			pruned, err = dal.Prune(context.Background(), keep, now, true)
+			So(err, ShouldBeNil)
+			So(len(pruned), ShouldEqual, 2)
+		})
+	})
+}
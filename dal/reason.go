@@ -0,0 +1,50 @@
+package dal
+
+import "strconv"
+
+// FailReason is why a ping was lost, it's stored in byte 1 of the ping's record
+// (see SerializeLostPingRes). The values are stored, never renumber them
+type FailReason uint8
+
+const (
+	ReasonNone            FailReason = iota // received, or lost before reasons were recorded
+	ReasonTimeout                           // no reply in time
+	ReasonHostUnreachable                   // ex: ICMP host unreachable
+	ReasonNetUnreachable                    // ex: ICMP net unreachable, no route
+	ReasonTTLExceeded                       // a router dropped the ping b/c its TTL ran out
+	ReasonDNS                               // the host didn't resolve
+	ReasonPermission                        // not permitted to send the ping
+	ReasonLocal                             // any other error on this side
+	ReasonRejected                          // the target answered w/ an error, ex: connection refused
+)
+
+// FailReasons are the reasons a ping is lost, in the order they're shown
+var FailReasons = []FailReason{
+	ReasonTimeout,
+	ReasonHostUnreachable,
+	ReasonNetUnreachable,
+	ReasonTTLExceeded,
+	ReasonDNS,
+	ReasonPermission,
+	ReasonLocal,
+	ReasonRejected,
+}
+
+var failReasonNames = map[FailReason]string{
+	ReasonNone:            "none",
+	ReasonTimeout:         "timeout",
+	ReasonHostUnreachable: "host unreachable",
+	ReasonNetUnreachable:  "net unreachable",
+	ReasonTTLExceeded:     "ttl exceeded",
+	ReasonDNS:             "dns",
+	ReasonPermission:      "permission",
+	ReasonLocal:           "local error",
+	ReasonRejected:        "rejected",
+}
+
+func (fr FailReason) String() string {
+	if name, ok := failReasonNames[fr]; ok {
+		return name
+	}
+	return "reason " + strconv.Itoa(int(fr))
+}
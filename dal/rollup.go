@@ -0,0 +1,335 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Every ping is also added to the rollup of its hour & its day, so queries that
+// group pings by an hour or more can read a rollup instead of every ping, see
+// GetPings. Rollups are keyed like pings, by target ID & the UTC minute their
+// period starts at (see GetPingKey), days are UTC days.
+const (
+	RollupReasonCount    = 16 // rollups count lost pings by FailReason up to this
+	HistogramBucketCount = 13
+
+	rollupVersion = 1
+)
+
+// HistogramBounds are the upper bounds of the buckets of a histogram of response
+// times, in ms, the last bucket has no upper bound
+var HistogramBounds = [HistogramBucketCount - 1]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// histogramBucket returns the bucket of a histogram resTime is counted in
+func histogramBucket(resTime float64) int {
+	for i, bound := range HistogramBounds {
+		if resTime < bound {
+			return i
+		}
+	}
+	return len(HistogramBounds)
+}
+
+// Rollup summarizes the pings of a target in an hour or a day
+type Rollup struct {
+	Received  uint32
+	Lost      uint32
+	Sum       float64 // of the response times of the received pings, ms
+	SumSq     float64 // of the squares of the response times, for the std dev
+	Min       float64
+	Max       float64
+	MinTTL    uint8 // of the replies, 0 when none of them had one
+	MaxTTL    uint8
+	LostBy    [RollupReasonCount]uint32    // # lost by FailReason, see PingGroup.LostBy
+	Histogram [HistogramBucketCount]uint32 // # received by response time, see HistogramBounds
+}
+
+// RollupByteCount is the size of a rollup, a version byte + the fields of Rollup
+var RollupByteCount = 1 + binary.Size(Rollup{})
+
+// add adds a ping to r, resTime is rounded like GetPings rounds it
+func (r *Rollup) add(pr *PingRes) {
+	resTime := Round(float64(pr.ResTime), .5, 3)
+	if resTime < 0 {
+		r.Lost++
+		reason := pr.Reason
+		if reason == ReasonNone {
+			reason = ReasonTimeout // see PingGroup.addPingRes
+		}
+		if int(reason) < RollupReasonCount {
+			r.LostBy[reason]++
+		}
+		return
+	}
+
+	r.merge(&Rollup{
+		Received: 1,
+		Sum:      resTime,
+		SumSq:    resTime * resTime,
+		Min:      resTime,
+		Max:      resTime,
+		MinTTL:   pr.TTL,
+		MaxTTL:   pr.TTL,
+	})
+	r.Histogram[histogramBucket(resTime)]++
+}
+
+// merge adds the pings of o to r
+func (r *Rollup) merge(o *Rollup) {
+	if o.Received > 0 {
+		if r.Received == 0 || o.Min < r.Min {
+			r.Min = o.Min
+		}
+		if o.Max > r.Max {
+			r.Max = o.Max
+		}
+	}
+	r.Received += o.Received
+	r.Lost += o.Lost
+	r.Sum += o.Sum
+	r.SumSq += o.SumSq
+	if o.MinTTL != 0 && (r.MinTTL == 0 || o.MinTTL < r.MinTTL) {
+		r.MinTTL = o.MinTTL
+	}
+	if o.MaxTTL > r.MaxTTL {
+		r.MaxTTL = o.MaxTTL
+	}
+	for i, n := range o.LostBy {
+		r.LostBy[i] += n
+	}
+	for i, n := range o.Histogram {
+		r.Histogram[i] += n
+	}
+}
+
+// PingGroup returns r as the group of pings between start and end, w/ its avg &
+// std dev calculated
+func (r *Rollup) PingGroup(start, end time.Time) *PingGroup {
+	pg := NewPingGroup(start, end)
+	pg.resTimes = nil
+	pg.Received = int(r.Received)
+	pg.Timedout = int(r.Lost)
+	pg.TotalTime = r.Sum
+	pg.MinTime, pg.MaxTime = r.Min, r.Max
+	pg.MinTTL, pg.MaxTTL = r.MinTTL, r.MaxTTL
+	for reason, n := range r.LostBy {
+		if n == 0 {
+			continue
+		}
+		if pg.LostBy == nil {
+			pg.LostBy = map[FailReason]int{}
+		}
+		pg.LostBy[FailReason(reason)] = int(n)
+	}
+	for i, n := range r.Histogram {
+		pg.Histogram[i] = int(n)
+	}
+
+	if r.Received > 0 {
+		n := float64(r.Received)
+		pg.AvgTime = r.Sum / n
+		// float error can make the variance of identical times slightly negative
+		pg.StdDev = math.Sqrt(math.Max(r.SumSq/n-pg.AvgTime*pg.AvgTime, 0))
+	}
+	return pg
+}
+
+// MarshalBinary returns r as a version byte followed by its fields, little-endian
+func (r *Rollup) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(RollupByteCount)
+	b.WriteByte(rollupVersion)
+	if err := binary.Write(&b, binary.LittleEndian, r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary does the opposite of MarshalBinary
+func (r *Rollup) UnmarshalBinary(data []byte) error {
+	if len(data) != RollupByteCount || data[0] != rollupVersion {
+		return errors.New(InvalidByteLength)
+	}
+	return binary.Read(bytes.NewReader(data[1:]), binary.LittleEndian, r)
+}
+
+// unmarshalRollup returns the rollup saved as v
+func unmarshalRollup(v []byte) (*Rollup, error) {
+	var r Rollup
+	return &r, r.UnmarshalBinary(v)
+}
+
+// rollupPings returns the rollup of the records of a ping key
+func rollupPings(v []byte) (*Rollup, error) {
+	size := pingResSize(v)
+	if len(v)%size != 0 {
+		return nil, errors.New(InvalidByteLength)
+	}
+	var r Rollup
+	for i := 0; i < len(v); i += size {
+		var pr PingRes
+		if err := pr.UnmarshalBinary(v[i : i+size]); err != nil {
+			return nil, err
+		}
+		r.add(&pr)
+	}
+	return &r, nil
+}
+
+// statsSlot returns r as the StatsSlot of the period that starts at start
+func (r *Rollup) statsSlot(start time.Time) StatsSlot {
+	return StatsSlot{
+		Start:    start.Unix(),
+		Received: uint64(r.Received),
+		Lost:     uint64(r.Lost),
+		Sum:      r.Sum,
+		Min:      r.Min,
+		Max:      r.Max,
+	}
+}
+
+// rollupLevel is a resolution pings are rolled up at
+type rollupLevel struct {
+	bucket string
+	period time.Duration
+}
+
+// rollupLevels returns every rollupLevel, coarsest first
+func (dal *DAL) rollupLevels() []rollupLevel {
+	return []rollupLevel{
+		{dal.dailyBucket, 24 * time.Hour},
+		{dal.hourlyBucket, time.Hour},
+	}
+}
+
+// rollupLevelFor returns the coarsest rollupLevel groups of groupBy from start
+// can be made of, ok is false when they can't be made of any
+func (dal *DAL) rollupLevelFor(start time.Time, groupBy time.Duration) (level rollupLevel, ok bool) {
+	for _, level := range dal.rollupLevels() {
+		if groupBy%level.period == 0 && start.Truncate(level.period).Equal(start) {
+			return level, true
+		}
+	}
+	return rollupLevel{}, false
+}
+
+// saveRollups adds the pings of r, which started in the minute of startTime, to
+// every rollup of the target w/ the given ID
+func (dal *DAL) saveRollups(tx *bolt.Tx, id uint32, startTime time.Time, r *Rollup) error {
+	for _, level := range dal.rollupLevels() {
+		bucket := tx.Bucket([]byte(level.bucket))
+		if bucket == nil {
+			return fmt.Errorf("%s %s", BucketNotFoundError, level.bucket)
+		}
+		key := GetPingKey(id, startTime.Truncate(level.period))
+
+		var saved Rollup
+		if v := bucket.Get(key); v != nil {
+			if err := saved.UnmarshalBinary(v); err != nil {
+				return fmt.Errorf("%s: %x", err, key)
+			}
+		}
+		saved.merge(r)
+		v, err := saved.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRollups adds the rollups of level of the target w/ the given ID that
+// start between start and end to g
+func (dal *DAL) readRollups(tx *bolt.Tx, level rollupLevel, id uint32, start, end time.Time, g *grouper) error {
+	bucket := tx.Bucket([]byte(level.bucket))
+	if bucket == nil {
+		return fmt.Errorf("%s %s", BucketNotFoundError, level.bucket)
+	}
+
+	pre := getPingKeyPrefix(id)
+	max := GetPingKey(id, end)
+	c := bucket.Cursor()
+	for k, v := c.Seek(GetPingKey(id, start)); k != nil && bytes.HasPrefix(k, pre) && bytes.Compare(k, max) < 0; k, v = c.Next() {
+		_, periodStart, err := ParsePingKey(k)
+		if err != nil {
+			return fmt.Errorf("%s: %s", KeyTimestampParsingError, err)
+		}
+		var r Rollup
+		if err := r.UnmarshalBinary(v); err != nil {
+			return fmt.Errorf("%s: %x", err, k)
+		}
+		g.addRollup(r.PingGroup(periodStart, periodStart.Add(level.period)))
+	}
+	return nil
+}
+
+// RebuildRollups rebuilds every rollup from the pings bucket, it returns the # of
+// ping keys read. Keys are read in batches, the rollups are incomplete until
+// every batch is done.
+func (dal *DAL) RebuildRollups() (read int, err error) {
+	err = dal.update(func(tx *bolt.Tx) error {
+		for _, level := range dal.rollupLevels() {
+			if tx.Bucket([]byte(level.bucket)) != nil {
+				if err := tx.DeleteBucket([]byte(level.bucket)); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket([]byte(level.bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("dal.RebuildRollups: %s", err)
+	}
+
+	var next []byte // the first key of the next batch
+	for done := false; !done; {
+		n := 0
+		err = dal.update(func(tx *bolt.Tx) error {
+			pings := tx.Bucket([]byte(dal.pingsBucket))
+			if pings == nil {
+				return fmt.Errorf("%s %s", BucketNotFoundError, dal.pingsBucket)
+			}
+
+			c := pings.Cursor()
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			for ; k != nil && n < upgradeBatchSize; k, v = c.Next() {
+				id, baseTime, err := ParsePingKey(k)
+				if err != nil {
+					return fmt.Errorf("%s: %x", err, k)
+				}
+				// the pings of a key are in the same minute, so the same rollups
+				r, err := rollupPings(v)
+				if err != nil {
+					return fmt.Errorf("%s: %x", err, k)
+				}
+				if err := dal.saveRollups(tx, id, baseTime, r); err != nil {
+					return err
+				}
+				n++
+			}
+			done = k == nil
+			next = append([]byte{}, k...)
+			return nil
+		})
+		if err != nil {
+			return read, fmt.Errorf("dal.RebuildRollups: %s", err)
+		}
+		read += n
+	}
+	return read, nil
+}
@@ -0,0 +1,200 @@
+package dal
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_rollup_unit(t *testing.T) {
+	Convey("Rollup", t, func() {
+		startTime := time.Date(2015, time.January, 1, 12, 30, 0, 0, time.UTC)
+		var r Rollup
+		for i, resTime := range []float32{4, 2, 600, -1} {
+			pr := NewPingRes(startTime.Add(time.Duration(i)*time.Second), resTime)
+			pr.TTL = uint8(56 + i)
+			r.add(pr)
+		}
+		lost := NewPingRes(startTime, -1)
+		lost.Reason = ReasonDNS
+		r.add(lost)
+
+		Convey("add() should count, sum & bucket the pings", func() {
+			So(r.Received, ShouldEqual, 3)
+			So(r.Lost, ShouldEqual, 2)
+			So(r.LostBy[ReasonTimeout], ShouldEqual, 1)
+			So(r.LostBy[ReasonDNS], ShouldEqual, 1)
+			So(r.Min, ShouldEqual, 2)
+			So(r.Max, ShouldEqual, 600)
+			So(r.MinTTL, ShouldEqual, 56)
+			So(r.MaxTTL, ShouldEqual, 58)
+			So(r.Histogram[histogramBucket(2)], ShouldEqual, 2) // 2 & 4 are both 2-5ms
+			So(r.Histogram[histogramBucket(600)], ShouldEqual, 1)
+		})
+		Convey("should marshal & unmarshal", func() {
+			b, err := r.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(len(b), ShouldEqual, RollupByteCount)
+			var r2 Rollup
+			So(r2.UnmarshalBinary(b), ShouldBeNil)
+			So(r2, ShouldResemble, r)
+
+			So(r2.UnmarshalBinary(b[1:]), ShouldNotBeNil)
+		})
+		Convey("PingGroup() should calc the same avg & std dev as the pings", func() {
+			pg := NewPingGroup(startTime, startTime.Add(time.Hour))
+			for _, resTime := range []float64{4, 2, 600} {
+				pg.addPingRes(resTime, ReasonNone)
+			}
+			pg.calcAvgAndStdDev()
+
+			rpg := r.PingGroup(startTime, startTime.Add(time.Hour))
+			So(rpg.Received, ShouldEqual, pg.Received)
+			So(rpg.AvgTime, ShouldAlmostEqual, pg.AvgTime)
+			So(rpg.StdDev, ShouldAlmostEqual, pg.StdDev)
+			So(rpg.Histogram, ShouldResemble, pg.Histogram)
+			So(rpg.Timedout, ShouldEqual, 2)
+			So(rpg.LostBy[ReasonDNS], ShouldEqual, 1)
+		})
+	})
+
+	Convey("rollupLevelFor()", t, func() {
+		dal := NewDAL()
+		day := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		level, ok := dal.rollupLevelFor(day, 24*time.Hour)
+		So(ok, ShouldBeTrue)
+		So(level.bucket, ShouldEqual, dal.dailyBucket)
+
+		level, ok = dal.rollupLevelFor(day.Add(time.Hour), 24*time.Hour)
+		So(ok, ShouldBeTrue)
+		So(level.bucket, ShouldEqual, dal.hourlyBucket)
+
+		level, ok = dal.rollupLevelFor(day, 90*time.Minute)
+		So(ok, ShouldBeFalse)
+		level, ok = dal.rollupLevelFor(day.Add(30*time.Minute), time.Hour)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func Test_rollup_integration(t *testing.T) {
+	Convey("rollups", t, func() {
+		dal := NewDAL()
+		So(dal.Open(), ShouldBeNil)
+		So(dal.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+		ip := "10.0.0.1"
+		start := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		// every 10s for 2 days & a bit, w/ a lost ping every 7th
+		rnd := rand.New(rand.NewSource(1))
+		end := start.Add(49*time.Hour + 30*time.Minute)
+		err := dal.db.Update(func(tx *bolt.Tx) error {
+			for i, t := 0, start; t.Before(end); i, t = i+1, t.Add(10*time.Second) {
+				pr := NewPingRes(t, float32(rnd.Intn(100000))/1000)
+				if i%7 == 0 {
+					pr.ResTime, pr.Reason = -1, ReasonHostUnreachable
+				}
+				if _, err := dal.savePingResWithTransaction(ip, t, pr, tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+
+		shouldMatchPings := func(groupBy time.Duration) {
+			rolled, err := dal.GetPings(ip, start, end, groupBy)
+			So(err, ShouldBeNil)
+			pinged, err := dal.getPings(ip, start, end, groupBy, false)
+			So(err, ShouldBeNil)
+			So(len(rolled), ShouldEqual, len(pinged))
+			for i, g := range rolled {
+				So(g.Start, ShouldHappenOnOrBetween, pinged[i].Start, pinged[i].Start)
+				So(g.Received, ShouldEqual, pinged[i].Received)
+				So(g.Timedout, ShouldEqual, pinged[i].Timedout)
+				So(g.LostBy, ShouldResemble, pinged[i].LostBy)
+				So(g.MinTime, ShouldEqual, pinged[i].MinTime)
+				So(g.MaxTime, ShouldEqual, pinged[i].MaxTime)
+				So(g.AvgTime, ShouldAlmostEqual, pinged[i].AvgTime, 1e-9)
+				So(g.StdDev, ShouldAlmostEqual, pinged[i].StdDev, 1e-6)
+				So(g.Histogram, ShouldResemble, pinged[i].Histogram)
+			}
+		}
+
+		Convey("GetPings() should return the same groups from the daily rollups as from the pings", func() {
+			shouldMatchPings(24 * time.Hour)
+		})
+		Convey("GetPings() should return the same groups from the hourly rollups as from the pings", func() {
+			shouldMatchPings(3 * time.Hour)
+		})
+		Convey("GetPings() should answer from the rollups w/o reading the pings", func() {
+			err := dal.db.Update(func(tx *bolt.Tx) error {
+				if err := tx.DeleteBucket([]byte(dal.pingsBucket)); err != nil {
+					return err
+				}
+				_, err := tx.CreateBucket([]byte(dal.pingsBucket))
+				return err
+			})
+			So(err, ShouldBeNil)
+
+			groups, err := dal.GetPings(ip, start, start.Add(48*time.Hour), 24*time.Hour)
+			So(err, ShouldBeNil)
+			So(len(groups), ShouldEqual, 2)
+			So(groups[0].Received+groups[0].Timedout, ShouldEqual, 8640)
+			So(groups[1].Received+groups[1].Timedout, ShouldEqual, 8640)
+		})
+		Convey("RebuildRollups() should rebuild the rollups from the pings", func() {
+			before, err := dal.GetPings(ip, start, end, 24*time.Hour)
+			So(err, ShouldBeNil)
+
+			read, err := dal.RebuildRollups()
+			So(err, ShouldBeNil)
+			So(read, ShouldEqual, 49*60+30)
+			shouldMatchPings(24 * time.Hour)
+
+			after, err := dal.GetPings(ip, start, end, 24*time.Hour)
+			So(err, ShouldBeNil)
+			So(after[0].Received, ShouldEqual, before[0].Received)
+		})
+	})
+}
+
+func Benchmark_rollup_GetPingsYear(b *testing.B) {
+	dal := NewDAL()
+	if err := dal.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(dal.fileName)
+	defer dal.Close()
+	dal.CreateBuckets()
+
+	// a year of pings, 1 a minute so it doesn't take forever to seed
+	start := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	err := dal.db.Update(func(tx *bolt.Tx) error {
+		for t := start; t.Before(end); t = t.Add(time.Minute) {
+			if _, err := dal.savePingResWithTransaction("127.0.0.1", t, NewPingRes(t, 1.1), tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dal.GetPings("127.0.0.1", start, end, 24*time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package dal
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// Every series (ex: a host, tcp:example.com:443) is given a target ID the first
+// time a ping is saved to it. Ping keys start w/ the ID instead of the series so
+// they're fixed width, see GetPingKey. The targets bucket maps series to IDs &
+// the target_ids bucket maps them back.
+const TargetIDByteCount = 4
+
+// getTargetID returns the ID of series, ok is false when it doesn't have one yet
+func (dal *DAL) getTargetID(tx *bolt.Tx, series string) (id uint32, ok bool, err error) {
+	targets := tx.Bucket([]byte(dal.targetsBucket))
+	if targets == nil {
+		return 0, false, fmt.Errorf("%s %s", BucketNotFoundError, dal.targetsBucket)
+	}
+	v := targets.Get([]byte(series))
+	if v == nil {
+		return 0, false, nil
+	}
+	if len(v) != TargetIDByteCount {
+		return 0, false, fmt.Errorf("%s: target %s", InvalidByteLength, series)
+	}
+	return binary.BigEndian.Uint32(v), true, nil
+}
+
+// createTargetID returns the ID of series, giving it the next one when it
+// doesn't have one yet
+func (dal *DAL) createTargetID(tx *bolt.Tx, series string) (uint32, error) {
+	id, ok, err := dal.getTargetID(tx, series)
+	if err != nil || ok {
+		return id, err
+	}
+
+	targets := tx.Bucket([]byte(dal.targetsBucket))
+	ids := tx.Bucket([]byte(dal.targetIDsBucket))
+	if ids == nil {
+		return 0, fmt.Errorf("%s %s", BucketNotFoundError, dal.targetIDsBucket)
+	}
+	seq, err := targets.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if seq > 1<<32-1 {
+		return 0, fmt.Errorf("out of target IDs for %s", series)
+	}
+
+	id = uint32(seq)
+	b := make([]byte, TargetIDByteCount)
+	binary.BigEndian.PutUint32(b, id)
+	if err := targets.Put([]byte(series), b); err != nil {
+		return 0, err
+	}
+	if err := ids.Put(b, []byte(series)); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// getTargetSeries does the opposite of getTargetID, "" when id isn't used
+func (dal *DAL) getTargetSeries(tx *bolt.Tx, id uint32) (string, error) {
+	ids := tx.Bucket([]byte(dal.targetIDsBucket))
+	if ids == nil {
+		return "", fmt.Errorf("%s %s", BucketNotFoundError, dal.targetIDsBucket)
+	}
+	b := make([]byte, TargetIDByteCount)
+	binary.BigEndian.PutUint32(b, id)
+	return string(ids.Get(b)), nil
+}
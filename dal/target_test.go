@@ -0,0 +1,50 @@
+package dal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_target_integration(t *testing.T) {
+	Convey("targets", t, func() {
+		dal := NewDAL()
+		So(dal.Open(), ShouldBeNil)
+		So(dal.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			dal.Close()
+			os.Remove(dal.fileName)
+		})
+
+		Convey("createTargetID() should give every series its own ID, once", func() {
+			err := dal.db.Update(func(tx *bolt.Tx) error {
+				id1, err := dal.createTargetID(tx, "127.0.0.1")
+				So(err, ShouldBeNil)
+				id2, err := dal.createTargetID(tx, "127.0.0.10")
+				So(err, ShouldBeNil)
+				So(id2, ShouldNotEqual, id1)
+
+				id, err := dal.createTargetID(tx, "127.0.0.1")
+				So(err, ShouldBeNil)
+				So(id, ShouldEqual, id1)
+
+				series, err := dal.getTargetSeries(tx, id2)
+				So(err, ShouldBeNil)
+				So(series, ShouldEqual, "127.0.0.10")
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+		Convey("getTargetID() should return false for a series w/o an ID", func() {
+			err := dal.db.View(func(tx *bolt.Tx) error {
+				_, ok, err := dal.getTargetID(tx, "127.0.0.1")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,8 +9,12 @@ import (
 	"os"
 	"os/signal"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/nuttapp/pinghist/dal"
 	"github.com/nuttapp/pinghist/ping"
 	"github.com/olekukonko/tablewriter"
@@ -17,11 +22,27 @@ import (
 
 var (
 	d                *dal.DAL
-	host             string
+	dbPath           string
+	hosts            hostList
+	tcpAddrs         hostList
+	urls             urlList
+	dnsSpecs         hostList
+	traceHosts       hostList
+	native           bool
+	pinger           *ping.Pinger // sends the pings of every host, unless -native=false
+	compareTimes     bool
+	forceIPv4        bool
+	forceIPv6        bool
+	pingOpts         ping.Options
 	showExamples     bool
 	start            string
 	end              string
 	groupBy          string
+	breakdown        bool
+	split            bool
+	showTTL          bool
+	retention        = dal.DefaultRetention
+	autoPruning      bool // prune w/ retention while pinging, only when a -keep* flag is given
 	ip               string
 	inputTimeFormats = []string{
 		// full
@@ -56,28 +77,62 @@ var (
 const (
 	timeFormat      = "01/02/2006 03:04 pm"
 	tableTimeFmt    = "01/02 03:04 pm"
+	tableSecondsFmt = "01/02 03:04:05 pm"
+	tableMillisFmt  = "01/02 03:04:05.000 pm"
 	timeShortformat = "03:04 pm"
 )
 
 func init() {
-	d = dal.NewDAL()
-	d.CreateBuckets()
-
 	const (
-		hostUsage         = "The host IP or hostname to ping"
-		ipUsage           = "The ip to query"
+		dbUsage           = "The path of the database file, defaults to $" + dal.PathEnvVar + " or $XDG_DATA_HOME/pinghist/pinghist.db"
+		tcpUsage          = "The host:port(s) to ping by opening a TCP connection, for hosts that block ICMP, ex: -tcp google.com:443"
+		httpUsage         = "The url(s) to send a GET request to, repeat the flag for more than one, ex: -http https://google.com/"
+		dnsUsage          = "The name@resolver(s) to query, prefix the resolver with tcp:// to query over TCP, ex: -dns google.com@8.8.8.8,google.com@tcp://1.1.1.1"
+		traceUsage        = "The host(s) to traceroute continuously, recording the latency & loss of every hop, needs root, ex: -trace google.com"
+		intervalUsage     = "The time between pings, override it for one target with ?interval=, ex: -h google.com?interval=500ms"
+		timeoutUsage      = "How long to wait for a reply, defaults to 3s, ex: -timeout 1s"
+		countUsage        = "The # of pings to send to each target before exiting, 0 pings until interrupted"
+		sizeUsage         = "The # of payload bytes in ICMP pings, 0 uses 64 (or the default of the ping command when pinghist shells out to it)"
+		ttlUsage          = "The TTL of ICMP pings, or the max hops of -trace, 0 uses the default"
+		ipv4Usage         = "Only ping IPv4 addresses"
+		ipv6Usage         = "Only ping IPv6 addresses"
+		nativeUsage       = "Send pings from pinghist when the OS allows it, w/ unprivileged icmp sockets or as root, -native=false always uses the ping command"
+		compareUsage      = "Also save the RTT of native pings measured w/o kernel timestamps to <host>#user, to compare the two"
+		hostUsage         = "The host IP(s) or hostname(s) to ping, comma separated or repeated, ex: -h 10.0.0.1,google.com -h 10.0.0.2"
+		ipUsage           = "The ip to query, prefix TCP pings with tcp:, DNS queries with dns:, traced hosts with trace:, or the url of HTTP pings w/ an optional #dns, #connect, #tls or #ttfb phase, or a host w/ #user for -compare, ex: -ip tcp:google.com:443"
 		showExamplesUsage = "Show example usage"
 		startUsage        = "The time to start querying ping times"
 		endUsage          = "The time to end querying ping times (all time up to this point)"
 		groupUsage        = "The duration by which to group the results, supports (s)econds, (m)inutes, (h)ours"
+		breakdownUsage    = "Break lost pings down by reason (timeout, host unreachable, dns, ...), one column per reason"
+		splitUsage        = "Show the stats of a hostname per address it resolved to"
+		showTTLUsage      = "Add a column w/ the TTL of the replies in each group & list when it changed, a sign the route changed"
 	)
 
 	flag.BoolVar(&showExamples, "examples", false, showExamplesUsage)
 
+	flag.StringVar(&dbPath, "db", "", dbUsage)
+
 	flag.StringVar(&ip, "ip", "", ipUsage)
 
-	flag.StringVar(&host, "host", "", hostUsage)
-	flag.StringVar(&host, "h", "", "-host")
+	flag.Var(&hosts, "host", hostUsage)
+	flag.Var(&hosts, "h", "-host")
+
+	flag.Var(&tcpAddrs, "tcp", tcpUsage)
+	flag.Var(&urls, "http", httpUsage)
+	flag.Var(&dnsSpecs, "dns", dnsUsage)
+	flag.Var(&traceHosts, "trace", traceUsage)
+
+	flag.BoolVar(&native, "native", true, nativeUsage)
+	flag.BoolVar(&compareTimes, "compare", false, compareUsage)
+	flag.DurationVar(&pingOpts.Interval, "interval", ping.DefaultInterval, intervalUsage)
+	flag.DurationVar(&pingOpts.Timeout, "timeout", 0, timeoutUsage)
+	flag.IntVar(&pingOpts.Count, "count", 0, countUsage)
+	flag.IntVar(&pingOpts.Size, "size", 0, sizeUsage)
+	flag.IntVar(&pingOpts.TTL, "ttl", 0, ttlUsage)
+
+	flag.BoolVar(&forceIPv4, "4", false, ipv4Usage)
+	flag.BoolVar(&forceIPv6, "6", false, ipv6Usage)
 
 	flag.StringVar(&start, "start", "", startUsage)
 	flag.StringVar(&start, "s", "", "-start")
@@ -87,13 +142,55 @@ func init() {
 
 	flag.StringVar(&groupBy, "groupby", "1h", groupUsage)
 	flag.StringVar(&groupBy, "g", "", "-groupby")
+	flag.BoolVar(&breakdown, "breakdown", false, breakdownUsage)
+	flag.BoolVar(&split, "split", false, splitUsage)
+	flag.BoolVar(&showTTL, "showttl", false, showTTLUsage)
+	addRetentionFlags(flag.CommandLine, &retention)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
-
-	if host != "" {
-		PingHost(host)
+	autoPruning = retentionSet(flag.CommandLine)
+
+	d = openDB(dbPath)
+	defer d.Close()
+	warnIfOutdated(d)
+
+	if len(hosts) > 0 || len(tcpAddrs) > 0 || len(urls) > 0 || len(dnsSpecs) > 0 || len(traceHosts) > 0 {
+		switch {
+		case forceIPv4 && forceIPv6:
+			log.Fatal("Can't use -4 and -6 together")
+		case forceIPv4:
+			pingOpts.Family = ping.IPv4
+		case forceIPv6:
+			pingOpts.Family = ping.IPv6
+		}
+		if native && !ping.NativeSupported() {
+			fmt.Println("Not permitted to open an icmp socket, falling back to the ping command")
+			native = false
+		}
+		if native {
+			pinger = ping.NewPinger()
+			defer pinger.Close()
+		}
+		if err := pingOpts.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		targets, err := hostTargets(hosts, tcpAddrs, urls, dnsSpecs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		traces, err := traceTargets(traceHosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		PingTargets(append(targets, traces...))
 		return
 	}
 
@@ -116,7 +213,7 @@ func main() {
 		}
 
 		if end == "*" || end == "" {
-			et = time.Now().AddDate(5, 0, 0)
+			et = time.Now()
 		} else {
 			et, err = ParseTime(end)
 			if err != nil {
@@ -135,6 +232,13 @@ func main() {
 	if err != nil {
 		log.Fatal("Can't parse groupby: " + err.Error())
 	}
+	if dur <= 0 {
+		log.Fatalf("Can't group by %s, groupby must be > 0", groupBy)
+	}
+	if et.Sub(st)/dur > dal.MaxGroups {
+		log.Fatalf("Can't group by %s, it's more than %d groups from %s to %s, use a larger groupby or a shorter range",
+			groupBy, dal.MaxGroups, st.Format(tableTimeFmt), et.Format(tableTimeFmt))
+	}
 
 	toText := et.Format(tableTimeFmt)
 	if end == "*" || end == "" {
@@ -143,48 +247,382 @@ func main() {
 
 	fmt.Printf("\nResults for %s, from %s, to %s, grouped by %s\n\n", ip, st.Format(tableTimeFmt), toText, groupBy)
 
+	// trace:host shows every hop, trace:host#hop3 falls through to the table of that hop
+	if strings.HasPrefix(ip, ping.TraceSeriesPrefix) && !strings.Contains(ip, "#") {
+		WriteTrace(strings.TrimPrefix(ip, ping.TraceSeriesPrefix), st, et)
+		return
+	}
+
 	groups, err := d.GetPings(ip, st, et, dur)
 	if err != nil {
-		log.Fatal("Couldn't retreive pings: %s", err)
+		log.Fatalf("Couldn't retreive pings: %s", err)
+	}
+
+	if !hasPings(groups) {
+		fmt.Printf("No pings found for %s in %s\n", ip, d.Path())
+		return
+	}
+
+	WriteTable(groups, breakdown, showTTL)
+	if showTTL {
+		WriteTTLChanges(groups)
 	}
 
-	WriteTable(groups)
+	// the answers of a DNS query, or the addresses a host resolved to
+	history, err := d.GetHistory(ip, st, et)
+	if err != nil {
+		log.Fatalf("Couldn't retreive history: %s", err)
+	}
+	switch {
+	case strings.HasPrefix(ip, ping.DNSSeriesPrefix):
+		if len(history) > 0 {
+			fmt.Println()
+			WriteHistory("Answer", history)
+		}
+	case len(history) > 0:
+		if len(history) > 1 {
+			fmt.Println()
+			WriteMoves(ip, history)
+		}
+		if split {
+			fmt.Println()
+			WriteAddresses(ip, history, st, et)
+		}
+	case split:
+		fmt.Printf("\nNo addresses were recorded for %s\n", ip)
+	}
 }
 
-func PingHost(host string) {
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
-	tick := time.NewTicker(1 * time.Second)
+// hostList is a flag.Value that collects hosts given as a comma separated list,
+// by repeating the flag, or both. ex: -h 10.0.0.1,10.0.0.2 -h google.com
+type hostList []string
 
+func (hl *hostList) String() string {
+	return strings.Join(*hl, ",")
+}
+
+func (hl *hostList) Set(value string) error {
+	for _, h := range strings.Split(value, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		*hl = append(*hl, h)
+	}
+	return nil
+}
+
+// urlList is a flag.Value that collects urls by repeating the flag,
+// unlike hostList the values aren't split on commas b/c urls can contain them
+type urlList []string
+
+func (ul *urlList) String() string {
+	return strings.Join(*ul, " ")
+}
+
+func (ul *urlList) Set(value string) error {
+	*ul = append(*ul, value)
+	return nil
+}
+
+// formatPhases returns the phases of a ping for output, ex: " dns=1.204 connect=20.991"
+func formatPhases(phases []ping.Phase) string {
+	s := ""
+	for _, p := range phases {
+		s += fmt.Sprintf(" %s=%.3f", p.Name, p.Time)
+	}
+	return s
+}
+
+// formatMeta returns the metadata of a result for output, sorted by key, ex: " answers=10.0.0.1 rcode=NOERROR"
+func formatMeta(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%s", k, meta[k])
+	}
+	return s
+}
+
+// target is something PingTargets probes periodically
+type target struct {
+	prober ping.Prober
+	opts   ping.Options // the interval & count of the target are used by PingTargets
+}
+
+// hostProber returns the prober of an ICMP host, the native one unless -native=false
+// or the OS doesn't allow it
+func hostProber(host string, opts ping.Options) ping.Prober {
+	if native {
+		return &ping.NativeProber{Host: host, Opts: opts, Pinger: pinger, Compare: compareTimes}
+	}
+	return &ping.PingProber{Host: host, Opts: opts}
+}
+
+// hostTargets returns a target for every ICMP host, TCP address, HTTP url and DNS query.
+// Hosts, addresses & queries can override pingOpts, see ping.ParseTarget, urls can't
+// b/c they have their own query string.
+func hostTargets(hosts, tcpAddrs, urls, dnsSpecs []string) ([]target, error) {
+	targets := make([]target, 0, len(hosts)+len(tcpAddrs)+len(urls)+len(dnsSpecs))
+	for _, spec := range hosts {
+		host, opts, err := ping.ParseTarget(spec, pingOpts)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{prober: hostProber(host, opts), opts: opts})
+	}
+	for _, spec := range tcpAddrs {
+		addr, opts, err := ping.ParseTarget(spec, pingOpts)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{prober: &ping.TCPProber{Addr: addr, Opts: opts}, opts: opts})
+	}
+	for _, url := range urls {
+		targets = append(targets, target{prober: &ping.HTTPProber{URL: url, Opts: pingOpts}, opts: pingOpts})
+	}
+	for _, spec := range dnsSpecs {
+		query, opts, err := ping.ParseTarget(spec, pingOpts)
+		if err != nil {
+			return nil, err
+		}
+		q, err := ping.ParseDNSQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{prober: &ping.DNSProber{Query: q, Opts: opts}, opts: opts})
+	}
+	return targets, nil
+}
+
+// traceTargets returns a target for every host to trace, see hostTargets
+func traceTargets(hosts []string) ([]target, error) {
+	targets := make([]target, 0, len(hosts))
+	for _, spec := range hosts {
+		host, opts, err := ping.ParseTarget(spec, pingOpts)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{prober: &ping.TraceProber{Host: host, Opts: opts}, opts: opts})
+	}
+	return targets, nil
+}
+
+// PingTargets probes every target concurrently until the process is interrupted,
+// or every target has sent its count of probes.
+// Each target is probed from its own goroutine so a slow or timing out host
+// never delays the probes of the others, all of them save to the same dal.
+// On interrupt the probes in flight are cancelled and PingTargets returns once
+// every goroutine has stopped, so no write to the dal is cut short. A second
+// interrupt exits right away.
+func PingTargets(targets []target) {
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// pad the output prefix so the columns line up when pinging many hosts
+	prefixWidth := 0
+	for _, t := range targets {
+		if len(t.prober.Name()) > prefixWidth {
+			prefixWidth = len(t.prober.Name())
+		}
+	}
+
+	errs := newErrorLog()
+	w := newResultWriter(errs)
+	var mu sync.Mutex // serializes output so lines from different hosts don't interleave
+	pruned := make(chan struct{})
+	go func() {
+		defer close(pruned)
+		if autoPruning {
+			autoPrune(ctx, d, retention, &mu, errs)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		prefix := fmt.Sprintf("%-*s", prefixWidth, t.prober.Name())
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			PingTarget(ctx, t, prefix, &mu, w, errs)
+		}(t)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-signalChan:
+	case <-done:
+	}
+	cancel()
+	// a second interrupt exits w/o waiting on the probes or the prune in flight
+	for _, c := range []chan struct{}{done, pruned} {
+		select {
+		case <-signalChan:
+			os.Exit(1)
+		case <-c:
+		}
+	}
+
+	unsaved := w.Close()
+	if summary := errs.Summary(); summary != "" {
+		fmt.Printf("\nErrors while pinging:\n%s", summary)
+	}
+	if unsaved > 0 {
+		fmt.Printf("%d results couldn't be saved\n", unsaved)
+	}
+}
+
+// pruneEvery is how often a running pinghist prunes the db, see autoPrune
+const pruneEvery = 1 * time.Hour
+
+// autoPrune prunes db w/ r right away & then every pruneEvery until ctx is done,
+// what's pruned is printed & errors are counted in errs. A prune in flight stops
+// between batches once ctx is done.
+func autoPrune(ctx context.Context, db *dal.DAL, r dal.Retention, mu *sync.Mutex, errs *errorLog) {
 	for {
+		pruned, err := db.Prune(ctx, r, time.Now(), false)
+		mu.Lock()
+		for _, pr := range pruned {
+			fmt.Println(formatPruned(pr, false))
+		}
+		if err != nil && ctx.Err() == nil {
+			errs.Add("prune", err)
+			log.Printf("Couldn't prune the db: %s", err)
+		}
+		mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pruneEvery):
+		}
+	}
+}
+
+// PingTarget probes t every interval until it has sent count probes (forever w/o
+// a count) or ctx is done, and saves every result w/ w. Output lines are
+// prefixed with prefix and the probe's sequence #. Probes that fail w/ an error
+// are saved as lost & the error is counted in errs, only errors that would fail
+// every probe (see fatalError) stop pinghist.
+func PingTarget(ctx context.Context, t target, prefix string, mu *sync.Mutex, w *resultWriter, errs *errorLog) {
+	tick := time.NewTicker(t.opts.GetInterval())
+	defer tick.Stop()
+
+	for seq := 1; ; seq++ {
 		select {
+		case <-ctx.Done():
+			return
 		case <-tick.C:
-			startTime := time.Now()
-			pr, err := ping.Ping(host)
+		}
 
-			if err != nil {
-				if te, ok := err.(ping.TimeoutError); ok {
-					fmt.Println(err)
-					err = d.SavePing(te.IP(), startTime, -1)
-					if err != nil {
-						log.Fatal(err)
-					}
-				} else {
-					log.Fatal(err)
-				}
-			} else {
-				fmt.Printf("%.3f \n", pr.Time)
-				err = d.SavePing(pr.IP, startTime, float32(pr.Time))
-			}
-			if err != nil {
+		start := time.Now()
+		r, err := t.prober.Probe(ctx)
+		if ctx.Err() != nil {
+			return // cancelled mid probe, there's nothing to save
+		}
+		if err != nil {
+			if fatalError(err) {
 				log.Fatal(err)
 			}
-		case <-signalChan:
-			os.Exit(0)
+			errs.Add(t.prober.Name(), err)
+			r = ping.LostResult(t.prober.Name(), start, err)
+		}
+
+		mu.Lock()
+		if r.Status == ping.StatusOK {
+			fmt.Printf("%s seq=%d %.3f%s%s\n", prefix, seq, r.Time, formatPhases(r.Phases), formatMeta(r.Meta))
+		} else {
+			fmt.Printf("%s seq=%d %s: %s%s\n", prefix, seq, failReason(r), r.Err, formatMeta(r.Meta))
+		}
+		mu.Unlock()
+
+		if changed := w.Save(r); changed && seq > 1 {
+			mu.Lock()
+			fmt.Printf("%s seq=%d %s changed: %s\n", prefix, seq, r.HistoryName, r.History)
+			mu.Unlock()
+		}
+
+		if seq == t.opts.Count {
+			return
 		}
 	}
 }
 
+// outcomeReasons are the reasons lost pings are saved w/, by outcome
+var outcomeReasons = map[ping.Outcome]dal.FailReason{
+	ping.OutcomeTimeout:         dal.ReasonTimeout,
+	ping.OutcomeHostUnreachable: dal.ReasonHostUnreachable,
+	ping.OutcomeNetUnreachable:  dal.ReasonNetUnreachable,
+	ping.OutcomeTTLExceeded:     dal.ReasonTTLExceeded,
+	ping.OutcomeDNS:             dal.ReasonDNS,
+	ping.OutcomePermission:      dal.ReasonPermission,
+	ping.OutcomeLocal:           dal.ReasonLocal,
+	ping.OutcomeRejected:        dal.ReasonRejected,
+}
+
+// failReason returns why r was lost, results lost w/o an outcome (ex: the hops
+// of a trace that didn't answer) timed out
+func failReason(r *ping.Result) dal.FailReason {
+	if reason, ok := outcomeReasons[r.Outcome]; ok {
+		return reason
+	}
+	return dal.ReasonTimeout
+}
+
+// saveResult saves the time of r (-1 & the reason when lost), its phases & children
+// to their own series, and its history, all in one transaction so a result that
+// fails part way isn't saved twice when it's retried. changed is true if the
+// history changed
+func saveResult(r *ping.Result) (changed bool, err error) {
+	err = d.Update(func(tx *bolt.Tx) error {
+		changed, err = saveResultWithTransaction(r, tx)
+		return err
+	})
+	return changed, err
+}
+
+// saveResultWithTransaction is saveResult using the given bolt transaction
+func saveResultWithTransaction(r *ping.Result, tx *bolt.Tx) (changed bool, err error) {
+	var pr *dal.PingRes
+	if r.Status == ping.StatusLost {
+		pr = dal.NewLostPingRes(r.Start, failReason(r), uint8(r.ICMPCode))
+	} else {
+		pr = dal.NewPingRes(r.Start, float32(r.Time))
+		pr.TTL, pr.Size = uint8(r.TTL), uint16(r.Bytes)
+	}
+	if err := d.SavePingResWithTransaction(r.Series, r.Start, pr, tx); err != nil {
+		return false, err
+	}
+	for _, p := range r.Phases {
+		pr := dal.NewPingRes(r.Start, float32(p.Time))
+		if err := d.SavePingResWithTransaction(ping.PhaseSeries(r.Series, p.Name), r.Start, pr, tx); err != nil {
+			return false, err
+		}
+	}
+	for _, c := range r.Children {
+		if _, err := saveResultWithTransaction(c, tx); err != nil {
+			return false, err
+		}
+	}
+	if r.HistoryName == "" {
+		return false, nil
+	}
+	return d.SaveHistoryWithTransaction(r.Series, r.Start, r.History, tx)
+}
+
 func ParseTime(str string) (time.Time, error) {
 	now := time.Now()
 	t := time.Time{}
@@ -217,14 +655,29 @@ func GetLastPingedIP() string {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(allIPStats) == 0 {
+		fmt.Printf("No pings found in %s, ping a host with -h first\n", d.Path())
+		os.Exit(0)
+	}
 	sort.Stable(dal.ByLastPingTime(allIPStats))
 	ip = allIPStats[len(allIPStats)-1].IP
 	return ip
 }
 
-func WriteTable(groups []*dal.PingGroup) {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{
+// hasPings returns true if any group has at least one received or lost ping
+func hasPings(groups []*dal.PingGroup) bool {
+	for _, g := range groups {
+		if g.Received > 0 || g.Timedout > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTable prints a row per group, w/ breakdown the lost pings by reason too
+// & w/ showTTL the TTLs of the replies
+func WriteTable(groups []*dal.PingGroup, breakdown, showTTL bool) {
+	header := []string{
 		"Time",
 		"min",
 		"avg",
@@ -232,14 +685,32 @@ func WriteTable(groups []*dal.PingGroup) {
 		"std dev",
 		"Received",
 		"Lost",
-	})
+	}
+	var reasons []dal.FailReason
+	if breakdown {
+		reasons = lostReasons(groups)
+		for _, reason := range reasons {
+			header = append(header, reason.String())
+		}
+	}
+	if showTTL {
+		header = append(header, "TTL")
+	}
+
+	timeFmt := tableTimeFmt
+	if len(groups) > 0 {
+		timeFmt = groupTimeFormat(groups[0].End.Sub(groups[0].Start))
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
 
 	table.SetBorder(false) // Set Border to false
 	table.SetAlignment(tablewriter.ALIGN_RIGHT)
 
 	for _, g := range groups {
 		row := []string{
-			fmt.Sprintf("%s", g.Start.In(time.Local).Format(tableTimeFmt)),
+			fmt.Sprintf("%s", g.Start.In(time.Local).Format(timeFmt)),
 			fmt.Sprintf("%.0f ms", g.MinTime),
 			fmt.Sprintf("%.0f ms", g.AvgTime),
 			fmt.Sprintf("%.0f ms", g.MaxTime),
@@ -247,7 +718,259 @@ func WriteTable(groups []*dal.PingGroup) {
 			fmt.Sprintf("%d", g.Received),
 			fmt.Sprintf("%d", g.Timedout),
 		}
+		for _, reason := range reasons {
+			row = append(row, fmt.Sprintf("%d", g.LostBy[reason]))
+		}
+		if showTTL {
+			row = append(row, formatTTL(g))
+		}
 		table.Append(row)
 	}
 	table.Render()
 }
+
+// groupTimeFormat returns the format of the start of groups of the given
+// duration, w/ seconds when groups don't start on whole minutes & ms when they
+// don't start on whole seconds, ex: -g 500ms
+func groupTimeFormat(groupBy time.Duration) string {
+	switch {
+	case groupBy%time.Second != 0:
+		return tableMillisFmt
+	case groupBy%time.Minute != 0:
+		return tableSecondsFmt
+	}
+	return tableTimeFmt
+}
+
+// formatTTL returns the TTLs of the replies in g, ex: 57 or 56-58, "" if unknown
+func formatTTL(g *dal.PingGroup) string {
+	switch {
+	case g.MaxTTL == 0:
+		return ""
+	case g.MinTTL == g.MaxTTL:
+		return fmt.Sprintf("%d", g.MaxTTL)
+	}
+	return fmt.Sprintf("%d-%d", g.MinTTL, g.MaxTTL)
+}
+
+// ttlChanges returns a line per group whose TTLs differ from the last group w/
+// a TTL, ex: TTL changed from 57 to 58 at 01/02 02:03 pm
+func ttlChanges(groups []*dal.PingGroup) []string {
+	changes := []string{}
+	last := ""
+	for _, g := range groups {
+		ttl := formatTTL(g)
+		if ttl == "" {
+			continue
+		}
+		if last != "" && ttl != last {
+			changes = append(changes, fmt.Sprintf("TTL changed from %s to %s at %s", last, ttl, g.Start.In(time.Local).Format(tableTimeFmt)))
+		}
+		last = ttl
+	}
+	return changes
+}
+
+// WriteTTLChanges prints when the TTL of the replies changed between groups
+func WriteTTLChanges(groups []*dal.PingGroup) {
+	changes := ttlChanges(groups)
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Println()
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
+
+// lostReasons returns the reasons pings in groups were lost for, in the order of dal.FailReasons
+func lostReasons(groups []*dal.PingGroup) []dal.FailReason {
+	reasons := []dal.FailReason{}
+	for _, reason := range dal.FailReasons {
+		for _, g := range groups {
+			if g.LostBy[reason] > 0 {
+				reasons = append(reasons, reason)
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+// WriteHistory prints when the value of a series changed, ex: the answers of a DNS query
+func WriteHistory(header string, history []*dal.HistoryEntry) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Since", header})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	for _, h := range history {
+		table.Append([]string{h.Time.In(time.Local).Format(tableTimeFmt), h.Value})
+	}
+	table.Render()
+}
+
+// WriteMoves prints when host started resolving to another address, ex:
+// google.com moved from 10.0.0.5 to 10.0.0.9 at 01/02 02:03 pm
+func WriteMoves(host string, history []*dal.HistoryEntry) {
+	for i := 1; i < len(history); i++ {
+		fmt.Printf("%s moved from %s to %s at %s\n", host, history[i-1].Value, history[i].Value,
+			history[i].Time.In(time.Local).Format(tableTimeFmt))
+	}
+}
+
+// unknownAddr is the address of pings saved before the addresses of their host were recorded
+const unknownAddr = "?"
+
+// addressGroups returns a group per address series resolved to between start and
+// end, in the order they were first resolved to. history is the addresses of
+// series, see ping.HistoryAddress
+func addressGroups(series string, history []*dal.HistoryEntry, start, end time.Time) ([]string, map[string]*dal.PingGroup, error) {
+	type period struct {
+		addr     string
+		from, to time.Time
+	}
+	periods := []period{}
+	from, addr := start, unknownAddr
+	for _, h := range history {
+		if h.Time.After(from) {
+			periods = append(periods, period{addr, from, h.Time})
+			from = h.Time
+		}
+		addr = h.Value
+	}
+	periods = append(periods, period{addr, from, end})
+
+	addrs := []string{}
+	byAddr := map[string]*dal.PingGroup{}
+	for _, p := range periods {
+		if !p.to.After(p.from) {
+			continue
+		}
+		groups, err := d.GetPings(series, p.from, p.to, p.to.Sub(p.from))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !hasPings(groups) {
+			continue
+		}
+		for _, g := range groups {
+			if byAddr[p.addr] == nil {
+				addrs = append(addrs, p.addr)
+				byAddr[p.addr] = g
+				continue
+			}
+			byAddr[p.addr].Merge(g)
+		}
+	}
+	return addrs, byAddr, nil
+}
+
+// WriteAddresses prints a row per address host resolved to between start and end
+func WriteAddresses(host string, history []*dal.HistoryEntry, start, end time.Time) {
+	addrs, byAddr, err := addressGroups(host, history, start, end)
+	if err != nil {
+		log.Fatalf("Couldn't retreive pings: %s", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Address",
+		"min",
+		"avg",
+		"max",
+		"std dev",
+		"Received",
+		"Lost",
+	})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+
+	for _, addr := range addrs {
+		g := byAddr[addr]
+		table.Append([]string{
+			addr,
+			fmt.Sprintf("%.0f ms", g.MinTime),
+			fmt.Sprintf("%.0f ms", g.AvgTime),
+			fmt.Sprintf("%.0f ms", g.MaxTime),
+			fmt.Sprintf("%.0f ms", g.StdDev),
+			fmt.Sprintf("%d", g.Received),
+			fmt.Sprintf("%d", g.Timedout),
+		})
+	}
+	table.Render()
+}
+
+// longestPath returns the # of hops in the longest path in history
+func longestPath(history []*dal.HistoryEntry) int {
+	hops := 0
+	for _, h := range history {
+		if n := len(strings.Fields(h.Value)); n > hops {
+			hops = n
+		}
+	}
+	return hops
+}
+
+// WriteTrace prints the min/avg/max & loss of every hop to host between start
+// and end, followed by the paths taken when the path changed
+func WriteTrace(host string, start, end time.Time) {
+	if !end.After(start) {
+		log.Fatal("The end time must be after the start time")
+	}
+	history, err := d.GetHistory(ping.TraceSeries(host), start, end)
+	if err != nil {
+		log.Fatalf("Couldn't retreive paths: %s", err)
+	}
+	if len(history) == 0 {
+		fmt.Printf("No traces found for %s in %s\n", host, d.Path())
+		return
+	}
+	path := strings.Fields(history[len(history)-1].Value)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Hop",
+		"Address",
+		"min",
+		"avg",
+		"max",
+		"Received",
+		"Lost",
+		"Loss",
+	})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+
+	for hop := 1; hop <= longestPath(history); hop++ {
+		groups, err := d.GetPings(ping.HopSeries(host, hop), start, end, end.Sub(start))
+		if err != nil {
+			log.Fatalf("Couldn't retreive pings: %s", err)
+		}
+		g := groups[0]
+		addr := ""
+		if hop <= len(path) {
+			addr = path[hop-1]
+		}
+		loss := 0.0
+		if g.Received+g.Timedout > 0 {
+			loss = float64(g.Timedout) / float64(g.Received+g.Timedout) * 100
+		}
+		table.Append([]string{
+			fmt.Sprintf("%d", hop),
+			addr,
+			fmt.Sprintf("%.0f ms", g.MinTime),
+			fmt.Sprintf("%.0f ms", g.AvgTime),
+			fmt.Sprintf("%.0f ms", g.MaxTime),
+			fmt.Sprintf("%d", g.Received),
+			fmt.Sprintf("%d", g.Timedout),
+			fmt.Sprintf("%.1f%%", loss),
+		})
+	}
+	table.Render()
+
+	if len(history) > 1 {
+		fmt.Printf("\nThe path changed %d times\n\n", len(history)-1)
+		WriteHistory("Path", history)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -45,6 +48,135 @@ func Test_main_unit(t *testing.T) {
 			})
 		}
 	})
+
+	Convey("hostTargets()", t, func() {
+		Convey("should return a target per host, tcp address, url and dns query", func() {
+			targets, err := hostTargets([]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1:443"}, []string{"https://10.0.0.1/"}, []string{"google.com@8.8.8.8"})
+			So(err, ShouldBeNil)
+			So(len(targets), ShouldEqual, 5)
+			So(targets[0].prober.Name(), ShouldEqual, "10.0.0.1")
+			So(targets[1].prober.Name(), ShouldEqual, "10.0.0.2")
+			So(targets[2].prober.Name(), ShouldEqual, "tcp:10.0.0.1:443")
+			So(targets[3].prober.Name(), ShouldEqual, "https://10.0.0.1/")
+			So(targets[4].prober.Name(), ShouldEqual, "dns:google.com@8.8.8.8:53")
+		})
+		Convey("should strip & apply per target options", func() {
+			targets, err := hostTargets([]string{"10.0.0.1?interval=500ms&count=3"}, []string{"10.0.0.1:443?timeout=1s"}, nil, []string{"google.com@8.8.8.8?count=2"})
+			So(err, ShouldBeNil)
+			So(targets[0].prober.Name(), ShouldEqual, "10.0.0.1")
+			So(targets[0].opts.Interval, ShouldEqual, 500*time.Millisecond)
+			So(targets[0].opts.Count, ShouldEqual, 3)
+			So(targets[1].prober.Name(), ShouldEqual, "tcp:10.0.0.1:443")
+			So(targets[1].opts.Timeout, ShouldEqual, time.Second)
+			So(targets[2].prober.Name(), ShouldEqual, "dns:google.com@8.8.8.8:53")
+			So(targets[2].opts.Count, ShouldEqual, 2)
+		})
+		Convey("should ping hosts natively unless -native=false", func() {
+			defer func(n bool) { native = n }(native)
+			native = true
+			targets, err := hostTargets([]string{"10.0.0.1"}, nil, nil, nil)
+			So(err, ShouldBeNil)
+			So(targets[0].prober, ShouldHaveSameTypeAs, &ping.NativeProber{})
+
+			native = false
+			targets, err = hostTargets([]string{"10.0.0.1"}, nil, nil, nil)
+			So(err, ShouldBeNil)
+			So(targets[0].prober, ShouldHaveSameTypeAs, &ping.PingProber{})
+		})
+		Convey("should return error for invalid options", func() {
+			_, err := hostTargets([]string{"10.0.0.1?ttl=999"}, nil, nil, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("formatPhases()", t, func() {
+		Convey("should format phases in order", func() {
+			phases := []ping.Phase{{Name: "dns", Time: 1.2041}, {Name: "connect", Time: 20.9}}
+			So(formatPhases(phases), ShouldEqual, " dns=1.204 connect=20.900")
+			So(formatPhases(nil), ShouldEqual, "")
+		})
+	})
+
+	Convey("groupTimeFormat()", t, func() {
+		So(groupTimeFormat(1*time.Hour), ShouldEqual, tableTimeFmt)
+		So(groupTimeFormat(90*time.Second), ShouldEqual, tableSecondsFmt)
+		So(groupTimeFormat(500*time.Millisecond), ShouldEqual, tableMillisFmt)
+	})
+
+	Convey("traceTargets()", t, func() {
+		Convey("should return a trace target per host", func() {
+			targets, err := traceTargets([]string{"google.com?ttl=16"})
+			So(err, ShouldBeNil)
+			So(len(targets), ShouldEqual, 1)
+			So(targets[0].opts.TTL, ShouldEqual, 16)
+			So(targets[0].prober.Name(), ShouldEqual, "trace:google.com")
+			_, ok := targets[0].prober.(*ping.TraceProber)
+			So(ok, ShouldBeTrue)
+		})
+	})
+
+	Convey("longestPath()", t, func() {
+		Convey("should count the hops of the longest path", func() {
+			history := []*dal.HistoryEntry{{Value: "10.0.0.1 * 8.8.8.8"}, {Value: "10.0.0.1 8.8.8.8"}}
+			So(longestPath(history), ShouldEqual, 3)
+			So(longestPath(nil), ShouldEqual, 0)
+		})
+	})
+
+	Convey("formatMeta()", t, func() {
+		Convey("should format metadata sorted by key", func() {
+			So(formatMeta(map[string]string{"rcode": "NOERROR", "answers": "10.0.0.1"}), ShouldEqual, " answers=10.0.0.1 rcode=NOERROR")
+			So(formatMeta(nil), ShouldEqual, "")
+		})
+	})
+
+	Convey("urlList", t, func() {
+		Convey("should not split urls on commas", func() {
+			var ul urlList
+			ul.Set("https://example.com/?a=1,2")
+			ul.Set("http://10.0.0.1/")
+			So([]string(ul), ShouldResemble, []string{"https://example.com/?a=1,2", "http://10.0.0.1/"})
+		})
+	})
+
+	Convey("hostList", t, func() {
+		Convey("should split a comma separated list of hosts", func() {
+			var hl hostList
+			err := hl.Set("10.0.0.1, google.com,,10.0.0.2")
+			So(err, ShouldBeNil)
+			So([]string(hl), ShouldResemble, []string{"10.0.0.1", "google.com", "10.0.0.2"})
+		})
+		Convey("should append hosts when the flag is repeated", func() {
+			var hl hostList
+			hl.Set("10.0.0.1")
+			hl.Set("10.0.0.2,10.0.0.3")
+			So([]string(hl), ShouldResemble, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+			So(hl.String(), ShouldEqual, "10.0.0.1,10.0.0.2,10.0.0.3")
+		})
+	})
+}
+
+// fakeProber returns a 1ms result for series every time it's probed, blocking until ctx is done if block is set,
+// or err if it's set
+type fakeProber struct {
+	series string
+	block  bool
+	err    error
+	probes int
+}
+
+func (p *fakeProber) Name() string { return p.series }
+
+func (p *fakeProber) Probe(ctx context.Context) (*ping.Result, error) {
+	p.probes++
+	if p.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &ping.Result{Series: p.series, Start: time.Now(), Time: 1}, nil
 }
 
 func Test_main_integration(t *testing.T) {
@@ -60,6 +192,9 @@ func Test_main_integration(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		d := dal.NewDAL()
+		err = d.Open()
+		So(err, ShouldBeNil)
+		defer d.Close()
 		d.CreateBuckets()
 		err = d.SavePing(ip, startTime, float32(pr.Time))
 		So(err, ShouldBeNil)
@@ -76,4 +211,188 @@ func Test_main_integration(t *testing.T) {
 		So(groups[0].Timedout, ShouldEqual, 0)
 		So(groups[0].TotalTime, ShouldEqual, pr.Time)
 	})
+
+	Convey("saveResult()", t, func() {
+		d = dal.NewDAL()
+		So(d.Open(), ShouldBeNil)
+		So(d.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			d.Close()
+			os.Remove("pinghist.db")
+		})
+		start := time.Now()
+
+		Convey("should save the result, its phases, children & history", func() {
+			r := &ping.Result{
+				Series:      "trace:10.0.0.1",
+				Start:       start,
+				Time:        12,
+				Phases:      []ping.Phase{{Name: "ttfb", Time: 4}},
+				History:     "10.0.0.254 10.0.0.1",
+				HistoryName: "path",
+				Children: []*ping.Result{
+					{Series: "trace:10.0.0.1#hop1", Start: start, Time: 1},
+					{Series: "trace:10.0.0.1#hop2", Start: start, Time: -1, Status: ping.StatusLost},
+				},
+			}
+			changed, err := saveResult(r)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+
+			for series, want := range map[string]float64{"trace:10.0.0.1": 12, "trace:10.0.0.1#ttfb": 4, "trace:10.0.0.1#hop1": 1} {
+				groups, err := d.GetPings(series, start, start.Add(1*time.Minute), 1*time.Hour)
+				So(err, ShouldBeNil)
+				So(groups[0].Received, ShouldEqual, 1)
+				So(groups[0].MaxTime, ShouldEqual, want)
+			}
+			groups, err := d.GetPings("trace:10.0.0.1#hop2", start, start.Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Timedout, ShouldEqual, 1)
+
+			changed, err = saveResult(r)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeFalse)
+		})
+		Convey("should save nothing of a result that fails part way, so a retry saves it once", func() {
+			r := &ping.Result{
+				Series:   "10.0.0.1",
+				Start:    start,
+				Time:     12,
+				Phases:   []ping.Phase{{Name: "ttfb", Time: 4}},
+				Children: []*ping.Result{{Series: "", Start: start, Time: 1}}, // fails after the ping & phase are saved
+			}
+			_, err := saveResult(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, dal.IPRequiredError)
+			for _, series := range []string{"10.0.0.1", "10.0.0.1#ttfb"} {
+				stats, err := d.GetIPStats(series)
+				So(err, ShouldBeNil)
+				So(stats, ShouldBeNil)
+			}
+
+			r.Children[0].Series = "10.0.0.1#hop1"
+			_, err = saveResult(r)
+			So(err, ShouldBeNil)
+			stats, err := d.GetIPStats("10.0.0.1")
+			So(err, ShouldBeNil)
+			So(stats.Received, ShouldEqual, 1)
+			groups, err := d.GetPings("10.0.0.1", start, start.Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 1)
+		})
+		Convey("should save lost results as timeouts", func() {
+			r := &ping.Result{Series: "tcp:10.0.0.1:443", Start: start, Time: 3, Status: ping.StatusLost}
+			_, err := saveResult(r)
+			So(err, ShouldBeNil)
+			groups, err := d.GetPings(r.Series, start, start.Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 0)
+			So(groups[0].Timedout, ShouldEqual, 1)
+			So(groups[0].LostBy[dal.ReasonTimeout], ShouldEqual, 1)
+		})
+		Convey("should save why a result was lost", func() {
+			r := &ping.Result{Series: "10.0.0.1", Start: start, Time: -1, Status: ping.StatusLost, Outcome: ping.OutcomeHostUnreachable, ICMPCode: 1}
+			_, err := saveResult(r)
+			So(err, ShouldBeNil)
+			groups, err := d.GetPings(r.Series, start, start.Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].LostBy[dal.ReasonHostUnreachable], ShouldEqual, 1)
+			So(lostReasons(groups), ShouldResemble, []dal.FailReason{dal.ReasonHostUnreachable})
+		})
+		Convey("should save the TTL of replies & list when it changed", func() {
+			start := time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC)
+			for i, ttl := range []int{57, 57, 0, 58, 58} {
+				r := &ping.Result{Series: "10.0.0.1", Start: start.Add(time.Duration(i) * time.Minute), Time: 1, TTL: ttl, Bytes: 64}
+				_, err := saveResult(r)
+				So(err, ShouldBeNil)
+			}
+			groups, err := d.GetPings("10.0.0.1", start, start.Add(5*time.Minute), 1*time.Minute)
+			So(err, ShouldBeNil)
+			So(formatTTL(groups[0]), ShouldEqual, "57")
+			So(formatTTL(groups[2]), ShouldEqual, "")
+			So(ttlChanges(groups), ShouldResemble, []string{
+				"TTL changed from 57 to 58 at " + groups[3].Start.In(time.Local).Format(tableTimeFmt),
+			})
+
+			groups, err = d.GetPings("10.0.0.1", start, start.Add(5*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(formatTTL(groups[0]), ShouldEqual, "57-58")
+		})
+		Convey("should split the pings of a host by the address it resolved to", func() {
+			start := time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC)
+			So(d.SavePing("google.com", start.Add(-1*time.Minute), 9), ShouldBeNil) // before addresses were recorded
+			for i, addr := range []string{"10.0.0.5", "10.0.0.5", "10.0.0.9", "10.0.0.9", "10.0.0.5"} {
+				r := &ping.Result{Series: "google.com", Start: start.Add(time.Duration(i) * time.Minute), Time: float64(i + 1)}
+				if i == 3 {
+					r.Time, r.Status = -1, ping.StatusLost
+				}
+				r.History, r.HistoryName = addr, ping.HistoryAddress
+				_, err := saveResult(r)
+				So(err, ShouldBeNil)
+			}
+
+			end := start.Add(1 * time.Hour)
+			history, err := d.GetHistory("google.com", start.Add(-1*time.Hour), end)
+			So(err, ShouldBeNil)
+			So(len(history), ShouldEqual, 3)
+
+			addrs, byAddr, err := addressGroups("google.com", history, start.Add(-1*time.Hour), end)
+			So(err, ShouldBeNil)
+			So(addrs, ShouldResemble, []string{unknownAddr, "10.0.0.5", "10.0.0.9"})
+			So(byAddr[unknownAddr].Received, ShouldEqual, 1)
+			So(byAddr["10.0.0.5"].Received, ShouldEqual, 3)
+			So(byAddr["10.0.0.5"].MaxTime, ShouldEqual, 5)
+			So(byAddr["10.0.0.5"].AvgTime, ShouldAlmostEqual, 8.0/3, 1e-9)
+			So(byAddr["10.0.0.9"].Received, ShouldEqual, 1)
+			So(byAddr["10.0.0.9"].Timedout, ShouldEqual, 1)
+		})
+	})
+
+	Convey("PingTarget()", t, func() {
+		d = dal.NewDAL()
+		So(d.Open(), ShouldBeNil)
+		So(d.CreateBuckets(), ShouldBeNil)
+		Reset(func() {
+			d.Close()
+			os.Remove("pinghist.db")
+		})
+		var mu sync.Mutex
+		errs := newErrorLog()
+		w := newResultWriter(errs)
+		start := time.Now()
+
+		Convey("should stop after count probes", func() {
+			p := &fakeProber{series: "fake"}
+			PingTarget(context.Background(), target{prober: p, opts: ping.Options{Interval: time.Millisecond, Count: 3}}, "fake", &mu, w, errs)
+			So(p.probes, ShouldEqual, 3)
+
+			groups, err := d.GetPings("fake", start, time.Now().Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Received, ShouldEqual, 3)
+		})
+		Convey("should save probes that fail as lost w/ the reason", func() {
+			p := &fakeProber{series: "nosuchhost.invalid", err: fmt.Errorf("ping.Ping: %w", &net.DNSError{Err: "no such host", Name: "nosuchhost.invalid"})}
+			PingTarget(context.Background(), target{prober: p, opts: ping.Options{Interval: time.Millisecond, Count: 2}}, "fake", &mu, w, errs)
+			So(p.probes, ShouldEqual, 2)
+
+			groups, err := d.GetPings(p.series, start, time.Now().Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(groups[0].Timedout, ShouldEqual, 2)
+			So(groups[0].LostBy[dal.ReasonDNS], ShouldEqual, 2)
+			So(errs.Summary(), ShouldEqual, "     2x nosuchhost.invalid: ping.Ping: lookup nosuchhost.invalid: no such host\n")
+		})
+		Convey("should return promptly when a probe in flight is cancelled", func() {
+			p := &fakeProber{series: "fake", block: true}
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			PingTarget(ctx, target{prober: p, opts: ping.Options{Interval: time.Millisecond}}, "fake", &mu, w, errs)
+			So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			So(p.probes, ShouldEqual, 1)
+
+			groups, err := d.GetPings("fake", start, time.Now().Add(1*time.Minute), 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(hasPings(groups), ShouldBeFalse)
+		})
+	})
 }
@@ -0,0 +1,295 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSSeriesPrefix is prepended to DNS queries when they're saved, ex: dns:google.com@8.8.8.8:53
+const DNSSeriesPrefix = "dns:"
+
+// rcodeNames are the names dig & friends use for rcodes
+var rcodeNames = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+// DNSQuery is a query for a name sent to a specific resolver
+type DNSQuery struct {
+	Name   string // the name to resolve, ex: google.com
+	Server string // the resolver's host:port, ex: 8.8.8.8:53
+	TCP    bool   // query over TCP instead of UDP
+}
+
+// ParseDNSQuery parses name@server, the server can be prefixed with tcp:// or udp://
+// (the default) and the port defaults to 53. ex: google.com@8.8.8.8, google.com@tcp://[2001:4860:4860::8888]:53
+func ParseDNSQuery(spec string) (*DNSQuery, error) {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 || i == len(spec)-1 {
+		return nil, fmt.Errorf("ping.ParseDNSQuery: expected name@server, got %q", spec)
+	}
+
+	q := &DNSQuery{Name: spec[:i], Server: spec[i+1:]}
+	if strings.HasPrefix(q.Server, "tcp://") {
+		q.TCP = true
+		q.Server = strings.TrimPrefix(q.Server, "tcp://")
+	} else {
+		q.Server = strings.TrimPrefix(q.Server, "udp://")
+	}
+
+	if _, _, err := net.SplitHostPort(q.Server); err != nil {
+		q.Server = net.JoinHostPort(strings.Trim(q.Server, "[]"), "53")
+	}
+	return q, nil
+}
+
+// String returns the query in the format ParseDNSQuery takes, w/ the port
+func (q *DNSQuery) String() string {
+	if q.TCP {
+		return q.Name + "@tcp://" + q.Server
+	}
+	return q.Name + "@" + q.Server
+}
+
+// Series returns the series pings of the query are saved to, UDP & TCP are separate
+func (q *DNSQuery) Series() string {
+	return DNSSeriesPrefix + q.String()
+}
+
+// DNSError is returned when a resolver doesn't answer, or answers w/ an error
+// rcode (anything but NOERROR or NXDOMAIN)
+type DNSError struct {
+	series    string
+	msg       string
+	RCode     string // empty if there was no answer
+	IsTimeout bool
+	err       error // the error of the exchange, if any
+}
+
+// IP returns the series the failed ping should be saved to
+func (de DNSError) IP() string {
+	return de.series
+}
+
+func (de DNSError) Error() string {
+	return de.msg
+}
+
+func (de DNSError) Timeout() bool {
+	return de.IsTimeout
+}
+
+func (de DNSError) Unwrap() error {
+	return de.err
+}
+
+// PingDNS sends q to its resolver and measures the time it takes to get an answer.
+// A records are queried, AAAA when opts.Family is IPv6. The returned PingResponse
+// has the rcode & the sorted answer set (addresses and CNAMEs).
+func PingDNS(q *DNSQuery, opts Options) (*PingResponse, error) {
+	return PingDNSContext(context.Background(), q, opts)
+}
+
+// PingDNSContext is PingDNS that closes the connection to the resolver when ctx
+// is done, ctx's error is returned when it's done before the answer arrives
+func PingDNSContext(ctx context.Context, q *DNSQuery, opts Options) (*PingResponse, error) {
+	qtype := dnsmessage.TypeA
+	if opts.Family == IPv6 {
+		qtype = dnsmessage.TypeAAAA
+	}
+
+	fqdn := q.Name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("ping.PingDNS: %s", err)
+	}
+	id := uint16(rand.Intn(0xffff))
+	question := dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}).Pack()
+	if err != nil {
+		return nil, fmt.Errorf("ping.PingDNS: %s", err)
+	}
+
+	start := time.Now()
+	res, err := exchangeDNS(ctx, q, id, question, query, opts.timeout(TimeOut))
+	end := time.Now()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		de := &DNSError{series: q.Series(), msg: err.Error(), err: err}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			de.IsTimeout = true
+		}
+		return nil, de
+	}
+
+	rcode, ok := rcodeNames[res.RCode]
+	if !ok {
+		rcode = fmt.Sprintf("RCODE%d", res.RCode)
+	}
+	if res.RCode != dnsmessage.RCodeSuccess && res.RCode != dnsmessage.RCodeNameError {
+		return nil, &DNSError{series: q.Series(), msg: "resolver answered " + rcode, RCode: rcode}
+	}
+
+	pr := &PingResponse{
+		IP:      q.Series(),
+		Host:    q.Name,
+		Time:    float64(end.Sub(start)) / float64(time.Millisecond),
+		RCode:   rcode,
+		Answers: dnsAnswers(res),
+	}
+	return pr, nil
+}
+
+// exchangeDNS sends the packed query to the resolver and returns its reply, the
+// connection is closed when ctx is done. Over UDP anyone can send us a datagram,
+// so the ones that can't be unpacked or don't answer the query w/ id & question
+// are skipped until the timeout, like the resolver of the stdlib does.
+func exchangeDNS(ctx context.Context, q *DNSQuery, id uint16, question dnsmessage.Question, query []byte, timeout time.Duration) (*dnsmessage.Message, error) {
+	network := "udp"
+	if q.TCP {
+		network = "tcp"
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	c, err := dialer.DialContext(ctx, network, q.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	defer closeOnDone(ctx, c)()
+	c.SetDeadline(time.Now().Add(timeout))
+
+	if q.TCP {
+		// messages sent over TCP are prefixed w/ their length
+		query = append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)
+	}
+	if _, err := c.Write(query); err != nil {
+		return nil, err
+	}
+
+	for {
+		var b []byte
+		if q.TCP {
+			var l [2]byte
+			if _, err := io.ReadFull(c, l[:]); err != nil {
+				return nil, err
+			}
+			b = make([]byte, binary.BigEndian.Uint16(l[:]))
+			if _, err := io.ReadFull(c, b); err != nil {
+				return nil, err
+			}
+		} else {
+			b = make([]byte, 4096)
+			n, err := c.Read(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[:n]
+		}
+
+		var res dnsmessage.Message
+		if err := res.Unpack(b); err != nil {
+			if q.TCP {
+				return nil, err
+			}
+			continue
+		}
+		// a late answer to an earlier query (or a spoofed one), keep waiting
+		if res.ID != id || !res.Response || !answersQuestion(&res, question) {
+			if q.TCP {
+				return nil, errors.New("invalid reply identifier")
+			}
+			continue
+		}
+		return &res, nil
+	}
+}
+
+// answersQuestion returns whether res is an answer to question, names are
+// compared case-insensitively
+func answersQuestion(res *dnsmessage.Message, question dnsmessage.Question) bool {
+	if len(res.Questions) != 1 {
+		return false
+	}
+	rq := res.Questions[0]
+	return rq.Type == question.Type && rq.Class == question.Class &&
+		strings.EqualFold(rq.Name.String(), question.Name.String())
+}
+
+// dnsAnswers returns the addresses & CNAMEs in the answer section, sorted
+func dnsAnswers(res *dnsmessage.Message) []string {
+	answers := []string{}
+	for _, a := range res.Answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, body.CNAME.String())
+		}
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// Answer returns the rcode & answers of a DNS ping as one string, ex: NOERROR 10.0.0.1,10.0.0.2
+func (pr *PingResponse) Answer() string {
+	return strings.TrimSpace(pr.RCode + " " + strings.Join(pr.Answers, ","))
+}
+
+// DNSProber is a Prober that sends Query to its resolver, see PingDNS. The
+// answers are saved to the history of the query.
+type DNSProber struct {
+	Query *DNSQuery
+	Opts  Options
+}
+
+func (p *DNSProber) Name() string {
+	return p.Query.Series()
+}
+
+func (p *DNSProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	pr, err := PingDNSContext(ctx, p.Query, opts)
+	r, err := newResult(start, pr, err)
+	if err != nil {
+		return nil, err
+	}
+	if de, ok := r.Err.(*DNSError); ok && de.RCode != "" {
+		r.Meta = map[string]string{"rcode": de.RCode}
+	}
+	if pr != nil {
+		r.Meta = map[string]string{"rcode": pr.RCode}
+		if len(pr.Answers) > 0 {
+			r.Meta["answers"] = strings.Join(pr.Answers, ",")
+		}
+		r.History, r.HistoryName = pr.Answer(), "answer"
+	}
+	return r, nil
+}
@@ -0,0 +1,218 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// dnsReply answers a packed query, names starting w/ "fail" get SERVFAIL,
+// "missing" gets NXDOMAIN and everything else 2 A records
+func dnsReply(b []byte) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		return nil
+	}
+	m.Response = true
+	q := m.Questions[0]
+	switch q.Name.String()[:4] {
+	case "fail":
+		m.RCode = dnsmessage.RCodeServerFailure
+	case "miss":
+		m.RCode = dnsmessage.RCodeNameError
+	default:
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+		m.Answers = []dnsmessage.Resource{
+			{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}},
+			{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}},
+		}
+	}
+	res, _ := m.Pack()
+	return res
+}
+
+// strayReplies returns what's sent before the reply to a query of a name starting
+// w/ "stray": garbage & the reply to another question
+func strayReplies(b []byte) [][]byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil || !strings.HasPrefix(m.Questions[0].Name.String(), "stray") {
+		return nil
+	}
+	m.Questions[0].Name = dnsmessage.MustNewName("other.example.com.")
+	other, _ := m.Pack()
+	return [][]byte{{0xde, 0xad}, dnsReply(other)}
+}
+
+// serveDNSUDP answers queries on a local UDP port until the returned conn is closed
+func serveDNSUDP() net.PacketConn {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			for _, stray := range strayReplies(b[:n]) {
+				c.WriteTo(stray, addr)
+			}
+			c.WriteTo(dnsReply(b[:n]), addr)
+		}
+	}()
+	return c
+}
+
+// serveDNSTCP answers one query per connection on a local TCP port until the returned listener is closed
+func serveDNSTCP() net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var n [2]byte
+			io.ReadFull(c, n[:])
+			b := make([]byte, binary.BigEndian.Uint16(n[:]))
+			io.ReadFull(c, b)
+			for _, res := range append(strayReplies(b), dnsReply(b)) {
+				c.Write(append([]byte{byte(len(res) >> 8), byte(len(res))}, res...))
+			}
+			c.Close()
+		}
+	}()
+	return l
+}
+
+func Test_dns_unit(t *testing.T) {
+	Convey("dns", t, func() {
+		Convey("ParseDNSQuery()", func() {
+			Convey("should default to UDP & port 53", func() {
+				q, err := ParseDNSQuery("google.com@8.8.8.8")
+				So(err, ShouldBeNil)
+				So(*q, ShouldResemble, DNSQuery{Name: "google.com", Server: "8.8.8.8:53"})
+				So(q.Series(), ShouldEqual, "dns:google.com@8.8.8.8:53")
+			})
+			Convey("should parse tcp:// and ports", func() {
+				q, err := ParseDNSQuery("google.com@tcp://1.1.1.1:5353")
+				So(err, ShouldBeNil)
+				So(*q, ShouldResemble, DNSQuery{Name: "google.com", Server: "1.1.1.1:5353", TCP: true})
+				So(q.String(), ShouldEqual, "google.com@tcp://1.1.1.1:5353")
+			})
+			Convey("should parse IPv6 servers", func() {
+				q, err := ParseDNSQuery("google.com@2001:4860:4860::8888")
+				So(err, ShouldBeNil)
+				So(q.Server, ShouldEqual, "[2001:4860:4860::8888]:53")
+				q, err = ParseDNSQuery("google.com@[::1]:5353")
+				So(err, ShouldBeNil)
+				So(q.Server, ShouldEqual, "[::1]:5353")
+			})
+			Convey("should require a name and a server", func() {
+				for _, spec := range []string{"google.com", "@8.8.8.8", "google.com@"} {
+					_, err := ParseDNSQuery(spec)
+					So(err, ShouldNotBeNil)
+				}
+			})
+		})
+		Convey("Answer()", func() {
+			Convey("should join the rcode & answers", func() {
+				pr := &PingResponse{RCode: "NOERROR", Answers: []string{"10.0.0.1", "10.0.0.2"}}
+				So(pr.Answer(), ShouldEqual, "NOERROR 10.0.0.1,10.0.0.2")
+				pr = &PingResponse{RCode: "NXDOMAIN"}
+				So(pr.Answer(), ShouldEqual, "NXDOMAIN")
+			})
+		})
+	})
+}
+
+func Test_dns_integration(t *testing.T) {
+	Convey("dns", t, func() {
+		Convey("PingDNS()", func() {
+			udp := serveDNSUDP()
+			Reset(func() { udp.Close() })
+			tcp := serveDNSTCP()
+			Reset(func() { tcp.Close() })
+
+			Convey("should return the sorted answers over UDP", func() {
+				q := &DNSQuery{Name: "example.com", Server: udp.LocalAddr().String()}
+				pr, err := PingDNS(q, Options{})
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, q.Series())
+				So(pr.Time, ShouldBeGreaterThan, 0)
+				So(pr.RCode, ShouldEqual, "NOERROR")
+				So(pr.Answers, ShouldResemble, []string{"10.0.0.1", "10.0.0.2"})
+			})
+			Convey("should return the sorted answers over TCP", func() {
+				q := &DNSQuery{Name: "example.com", Server: tcp.Addr().String(), TCP: true}
+				pr, err := PingDNS(q, Options{})
+				So(err, ShouldBeNil)
+				So(pr.Answers, ShouldResemble, []string{"10.0.0.1", "10.0.0.2"})
+			})
+			Convey("should skip stray datagrams over UDP until the answer", func() {
+				q := &DNSQuery{Name: "stray.example.com", Server: udp.LocalAddr().String()}
+				pr, err := PingDNS(q, Options{})
+				So(err, ShouldBeNil)
+				So(pr.Answers, ShouldResemble, []string{"10.0.0.1", "10.0.0.2"})
+			})
+			Convey("should return error for a reply it can't unpack over TCP", func() {
+				q := &DNSQuery{Name: "stray.example.com", Server: tcp.Addr().String(), TCP: true}
+				_, err := PingDNS(q, Options{})
+				So(err, ShouldNotBeNil)
+			})
+			Convey("should treat NXDOMAIN as an answer", func() {
+				q := &DNSQuery{Name: "missing.example.com", Server: udp.LocalAddr().String()}
+				pr, err := PingDNS(q, Options{})
+				So(err, ShouldBeNil)
+				So(pr.RCode, ShouldEqual, "NXDOMAIN")
+				So(pr.Answers, ShouldBeEmpty)
+			})
+			Convey("should return a DNSError for SERVFAIL", func() {
+				q := &DNSQuery{Name: "fail.example.com", Server: udp.LocalAddr().String()}
+				pr, err := PingDNS(q, Options{})
+				So(pr, ShouldBeNil)
+				de, ok := err.(*DNSError)
+				So(ok, ShouldBeTrue)
+				So(de.RCode, ShouldEqual, "SERVFAIL")
+				So(de.IP(), ShouldEqual, q.Series())
+			})
+			Convey("should return a timeout DNSError when the resolver doesn't answer", func() {
+				c, err := net.ListenPacket("udp", "127.0.0.1:0")
+				So(err, ShouldBeNil)
+				defer c.Close()
+
+				defer func(d time.Duration) { TimeOut = d }(TimeOut)
+				TimeOut = 50 * time.Millisecond
+				_, err = PingDNS(&DNSQuery{Name: "example.com", Server: c.LocalAddr().String()}, Options{})
+				de, ok := err.(*DNSError)
+				So(ok, ShouldBeTrue)
+				So(de.Timeout(), ShouldBeTrue)
+			})
+		})
+
+		Convey("DNSProber", func() {
+			Convey("Probe() should stop waiting for an answer when ctx is cancelled", func() {
+				c, err := net.ListenPacket("udp", "127.0.0.1:0")
+				So(err, ShouldBeNil)
+				defer c.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				start := time.Now()
+				q := &DNSQuery{Name: "example.com", Server: c.LocalAddr().String()}
+				_, err = (&DNSProber{Query: q, Opts: Options{Timeout: 10 * time.Second}}).Probe(ctx)
+				So(err, ShouldEqual, context.Canceled)
+				So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			})
+		})
+	})
+}
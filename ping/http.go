@@ -0,0 +1,205 @@
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// The phases of an HTTP ping, in the order they happen. A phase is left out
+// when it doesn't happen, ex: there's no DNS lookup for an IP & no TLS for http://
+const (
+	PhaseDNS     = "dns"
+	PhaseConnect = "connect"
+	PhaseTLS     = "tls"
+	PhaseTTFB    = "ttfb" // time to first byte, from the start of the request
+)
+
+// httpTLSConfig is used by the transport of HTTP pings, nil uses the defaults
+var httpTLSConfig *tls.Config
+
+// HTTPError is returned when an HTTP request fails or the response isn't a 2xx
+type HTTPError struct {
+	url        string
+	msg        string
+	StatusCode int // 0 if there was no response
+	IsTimeout  bool
+	err        error // the error of the request, if any
+}
+
+// IP returns the series the failed ping should be saved to
+func (he HTTPError) IP() string {
+	return he.url
+}
+
+func (he HTTPError) Error() string {
+	return he.msg
+}
+
+func (he HTTPError) Timeout() bool {
+	return he.IsTimeout
+}
+
+func (he HTTPError) Unwrap() error {
+	return he.err
+}
+
+// httpTimings collects the phase timings of a request, the trace hooks
+// can be called from more than one goroutine (ex: dialing IPv4 & IPv6 at once)
+type httpTimings struct {
+	mu                            sync.Mutex
+	start, dnsStart, connectStart time.Time
+	tlsStart                      time.Time
+	phases                        map[string]float64
+}
+
+func (ht *httpTimings) begin(t *time.Time) {
+	ht.mu.Lock()
+	*t = time.Now()
+	ht.mu.Unlock()
+}
+
+func (ht *httpTimings) end(phase string, start *time.Time) {
+	ht.mu.Lock()
+	ht.phases[phase] = float64(time.Since(*start)) / float64(time.Millisecond)
+	ht.mu.Unlock()
+}
+
+func (ht *httpTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { ht.begin(&ht.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { ht.end(PhaseDNS, &ht.dnsStart) },
+		ConnectStart: func(network, addr string) {
+			ht.begin(&ht.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				ht.end(PhaseConnect, &ht.connectStart)
+			}
+		},
+		TLSHandshakeStart:    func() { ht.begin(&ht.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { ht.end(PhaseTLS, &ht.tlsStart) },
+		GotFirstResponseByte: func() { ht.end(PhaseTTFB, &ht.start) },
+	}
+}
+
+// Phases returns the timings in the order they happened
+func (ht *httpTimings) Phases() []Phase {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	phases := []Phase{}
+	for _, name := range []string{PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB} {
+		if t, ok := ht.phases[name]; ok {
+			phases = append(phases, Phase{Name: name, Time: t})
+		}
+	}
+	return phases
+}
+
+// PingHTTP sends a GET request to url and measures how long it takes to read the
+// whole response. The DNS, connect, TLS handshake and time to first byte phases
+// are returned in PingResponse.Phases. Every request uses a new connection and
+// redirects aren't followed, anything but a 2xx response is returned as an HTTPError.
+func PingHTTP(url string, opts Options) (*PingResponse, error) {
+	return PingHTTPContext(context.Background(), url, opts)
+}
+
+// PingHTTPContext is PingHTTP that aborts the request when ctx is done, ctx's
+// error is returned when it's done before the whole response is read
+func PingHTTPContext(ctx context.Context, url string, opts Options) (*PingResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ping.PingHTTP: %s", err)
+	}
+
+	ht := &httpTimings{phases: map[string]float64{}}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), ht.trace()))
+
+	dialer := &net.Dialer{Timeout: opts.timeout(TimeOut)}
+	client := &http.Client{
+		Timeout: opts.timeout(TimeOut),
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   httpTLSConfig,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				switch opts.Family {
+				case IPv4:
+					network = "tcp4"
+				case IPv6:
+					network = "tcp6"
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ht.start = time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, fmt.Errorf("ping.PingHTTP: %w", err)
+		}
+		he := &HTTPError{url: url, msg: err.Error(), err: err}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			he.IsTimeout = true
+		}
+		return nil, he
+	}
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	end := time.Now()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &HTTPError{url: url, msg: err.Error(), StatusCode: res.StatusCode, err: err}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &HTTPError{url: url, msg: res.Status, StatusCode: res.StatusCode}
+	}
+
+	pr := &PingResponse{
+		IP:     url,
+		Host:   req.URL.Host,
+		Time:   float64(end.Sub(ht.start)) / float64(time.Millisecond),
+		Phases: ht.Phases(),
+	}
+	return pr, nil
+}
+
+// HTTPProber is a Prober that sends a GET request to URL, see PingHTTP
+type HTTPProber struct {
+	URL  string
+	Opts Options
+}
+
+func (p *HTTPProber) Name() string {
+	return p.URL
+}
+
+func (p *HTTPProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	pr, err := PingHTTPContext(ctx, p.URL, opts)
+	return newResult(start, pr, err)
+}
@@ -0,0 +1,114 @@
+package ping
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// phaseNames returns the names of the phases in order
+func phaseNames(phases []Phase) []string {
+	names := []string{}
+	for _, p := range phases {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func Test_http_integration(t *testing.T) {
+	Convey("http", t, func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/error":
+				w.WriteHeader(http.StatusInternalServerError)
+			case "/redirect":
+				http.Redirect(w, r, "/", http.StatusFound)
+			case "/hang":
+				<-r.Context().Done()
+			default:
+				w.Write([]byte("ok"))
+			}
+		})
+
+		Convey("PingHTTP()", func() {
+			srv := httptest.NewServer(handler)
+			Reset(srv.Close)
+
+			Convey("should return the total time, connect & ttfb phases", func() {
+				pr, err := PingHTTP(srv.URL+"/", Options{})
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, srv.URL+"/")
+				So(pr.Time, ShouldBeGreaterThan, 0)
+				So(phaseNames(pr.Phases), ShouldResemble, []string{PhaseConnect, PhaseTTFB})
+				for _, p := range pr.Phases {
+					So(p.Time, ShouldBeLessThanOrEqualTo, pr.Time)
+				}
+			})
+			Convey("should return a dns phase for hostnames", func() {
+				url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+				pr, err := PingHTTP(url, Options{Family: IPv4})
+				So(err, ShouldBeNil)
+				So(phaseNames(pr.Phases), ShouldResemble, []string{PhaseDNS, PhaseConnect, PhaseTTFB})
+			})
+			Convey("should return HTTPError for non 2xx responses", func() {
+				pr, err := PingHTTP(srv.URL+"/error", Options{})
+				So(pr, ShouldBeNil)
+				he, ok := err.(*HTTPError)
+				So(ok, ShouldBeTrue)
+				So(he.StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(he.IP(), ShouldEqual, srv.URL+"/error")
+			})
+			Convey("should not follow redirects", func() {
+				_, err := PingHTTP(srv.URL+"/redirect", Options{})
+				he, ok := err.(*HTTPError)
+				So(ok, ShouldBeTrue)
+				So(he.StatusCode, ShouldEqual, http.StatusFound)
+			})
+			Convey("should return HTTPError when the server is down", func() {
+				url := srv.URL
+				srv.Close()
+				_, err := PingHTTP(url, Options{})
+				_, ok := err.(*HTTPError)
+				So(ok, ShouldBeTrue)
+			})
+			Convey("should return error with an invalid url", func() {
+				_, err := PingHTTP("://nope", Options{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("HTTPProber", func() {
+			srv := httptest.NewServer(handler)
+			Reset(srv.Close)
+
+			Convey("Probe() should abort the request when ctx is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				start := time.Now()
+				_, err := (&HTTPProber{URL: srv.URL + "/hang", Opts: Options{Timeout: 10 * time.Second}}).Probe(ctx)
+				So(err, ShouldEqual, context.Canceled)
+				So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			})
+		})
+
+		Convey("PingHTTP() w/ TLS", func() {
+			srv := httptest.NewTLSServer(handler)
+			httpTLSConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+			Reset(func() {
+				srv.Close()
+				httpTLSConfig = nil
+			})
+
+			Convey("should return a tls phase", func() {
+				pr, err := PingHTTP(srv.URL, Options{})
+				So(err, ShouldBeNil)
+				So(phaseNames(pr.Phases), ShouldResemble, []string{PhaseConnect, PhaseTLS, PhaseTTFB})
+			})
+		})
+	})
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package ping
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// dialICMPDgram opens an unprivileged ICMP "ping" socket connected to ipAddr.
+// The kernel only allows it when the user's group is in net.ipv4.ping_group_range,
+// it fills in the echo identifier & checksum and reads don't include the IP header.
+func dialICMPDgram(ipAddr *net.IPAddr) (net.Conn, error) {
+	var fd int
+	var sa syscall.Sockaddr
+	var err error
+	if ip4 := ipAddr.IP.To4(); ip4 != nil {
+		fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMPV6)
+		sa6 := &syscall.SockaddrInet6{ZoneId: zoneIndex(ipAddr.Zone)}
+		copy(sa6.Addr[:], ipAddr.IP.To16())
+		sa = sa6
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := syscall.Connect(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+
+	// FileConn dups the fd, so the file can be closed right away
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// listenICMPDgram opens an unconnected unprivileged ICMP socket, like dialICMPDgram
+// but it can send to any address. Addresses are *net.UDPAddr, the port is ignored.
+func listenICMPDgram(v6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// zoneIndex returns the interface index of an IPv6 zone, ex: eth0 or 2
+func zoneIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	n, _ := strconv.Atoi(zone)
+	return uint32(n)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package ping
+
+import (
+	"errors"
+	"net"
+)
+
+// dialICMPDgram is only supported on linux, everything else falls back to raw sockets
+func dialICMPDgram(ipAddr *net.IPAddr) (net.Conn, error) {
+	return nil, errors.New("ping: icmp datagram sockets are not supported on this platform")
+}
+
+// listenICMPDgram is only supported on linux, everything else falls back to raw sockets
+func listenICMPDgram(v6 bool) (net.PacketConn, error) {
+	return nil, errors.New("ping: icmp datagram sockets are not supported on this platform")
+}
@@ -0,0 +1,152 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Family is the address family used to ping a host
+type Family int
+
+const (
+	FamilyAny Family = iota // IPv4 if the host has an IPv4 address, otherwise IPv6
+	IPv4
+	IPv6
+)
+
+// network returns the net package network name for the family, ex: ip4
+func (f Family) network() string {
+	switch f {
+	case IPv4:
+		return "ip4"
+	case IPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// DefaultInterval is the time between pings when Options.Interval isn't set
+const DefaultInterval = 1 * time.Second
+
+// MinInterval is the shortest time between pings Validate allows, the floor
+// ping(8) enforces for non-root users, so one target can't flood a host or the db
+const MinInterval = 200 * time.Millisecond
+
+// Options configure how a host is pinged, the zero value pings with the defaults
+type Options struct {
+	Family   Family
+	Interval time.Duration // time between pings, defaults to DefaultInterval
+	Timeout  time.Duration // how long to wait for a reply, defaults to TimeOut (the ping command's default for Ping)
+	Count    int           // # of pings to send before stopping, 0 never stops
+	Size     int           // # of payload bytes in ICMP pings, 0 uses the default of the pinger
+	TTL      int           // TTL of ICMP pings (max hops of traces), 0 uses the OS default
+}
+
+// GetInterval returns the time between pings, DefaultInterval if it isn't set
+func (o Options) GetInterval() time.Duration {
+	if o.Interval <= 0 {
+		return DefaultInterval
+	}
+	return o.Interval
+}
+
+// timeout returns how long to wait for a reply, def if it isn't set
+func (o Options) timeout(def time.Duration) time.Duration {
+	if o.Timeout <= 0 {
+		return def
+	}
+	return o.Timeout
+}
+
+// Validate returns an error if any of the options are out of range
+func (o Options) Validate() error {
+	switch {
+	case o.Interval < 0:
+		return errors.New("ping.Options: interval can't be negative")
+	case o.Interval > 0 && o.Interval < MinInterval:
+		return fmt.Errorf("ping.Options: interval must be at least %s", MinInterval)
+	case o.Timeout < 0:
+		return errors.New("ping.Options: timeout can't be negative")
+	case o.Count < 0:
+		return errors.New("ping.Options: count can't be negative")
+	case o.Size < 0 || o.Size > 65500:
+		return errors.New("ping.Options: size must be between 0 and 65500")
+	case o.TTL < 0 || o.TTL > 255:
+		return errors.New("ping.Options: ttl must be between 0 and 255")
+	}
+	return nil
+}
+
+// ParseTarget splits a target from the options that override defaults for it,
+// the options are given like a url query, ex: google.com?interval=500ms&ttl=32
+// Supported options are interval, timeout, count, size & ttl.
+func ParseTarget(spec string, defaults Options) (string, Options, error) {
+	opts := defaults
+	i := strings.Index(spec, "?")
+	if i < 0 {
+		return spec, opts, nil
+	}
+	target := spec[:i]
+
+	values, err := url.ParseQuery(spec[i+1:])
+	if err != nil {
+		return "", opts, fmt.Errorf("ping.ParseTarget: %s", err)
+	}
+	for key := range values {
+		value := values.Get(key)
+		switch key {
+		case "interval":
+			opts.Interval, err = time.ParseDuration(value)
+		case "timeout":
+			opts.Timeout, err = time.ParseDuration(value)
+		case "count":
+			opts.Count, err = strconv.Atoi(value)
+		case "size":
+			opts.Size, err = strconv.Atoi(value)
+		case "ttl":
+			opts.TTL, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return "", opts, fmt.Errorf("ping.ParseTarget: %s: %s", target, err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return "", opts, fmt.Errorf("ping.ParseTarget: %s: %s", target, err)
+	}
+	return target, opts, nil
+}
+
+// ResolveIP returns the address to ping for hostOrIP. IP literals are returned as
+// is, including IPv6 zones (ex: fe80::1%eth0), hostnames are looked up (A or AAAA
+// records depending on family).
+func ResolveIP(hostOrIP string, family Family) (*net.IPAddr, error) {
+	ipAddr, err := net.ResolveIPAddr(family.network(), hostOrIP)
+	if err != nil {
+		return nil, fmt.Errorf("ping.ResolveIP: %w", err)
+	}
+	return ipAddr, nil
+}
+
+// isIPv6 returns true if ipAddr is an IPv6 address (and not an IPv4 mapped one)
+func isIPv6(ipAddr *net.IPAddr) bool {
+	return ipAddr.IP.To4() == nil
+}
+
+// parseIP returns s if it's an IPv4 or IPv6 address, IPv6 zones are allowed
+func parseIP(s string) (string, bool) {
+	addr := s
+	if i := strings.LastIndex(addr, "%"); i > 0 {
+		addr = addr[:i]
+	}
+	if net.ParseIP(addr) == nil {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,133 @@
+package ping
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_options_unit(t *testing.T) {
+	Convey("options", t, func() {
+		Convey("ResolveIP()", func() {
+			Convey("should return IP literals as is", func() {
+				tests := map[string]string{
+					"127.0.0.1":                "127.0.0.1",
+					"::1":                      "::1",
+					"2607:f8b0:4006:81c::200e": "2607:f8b0:4006:81c::200e",
+					"fe80::1%lo":               "fe80::1%lo",
+				}
+				for ip, want := range tests {
+					ipAddr, err := ResolveIP(ip, FamilyAny)
+					So(err, ShouldBeNil)
+					So(ipAddr.String(), ShouldEqual, want)
+				}
+			})
+			Convey("should return error when the IP doesn't match the family", func() {
+				_, err := ResolveIP("::1", IPv4)
+				So(err, ShouldNotBeNil)
+				_, err = ResolveIP("127.0.0.1", IPv6)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("parseIP()", func() {
+			Convey("should accept IPv4 and IPv6 w/ or w/o zone", func() {
+				for _, ip := range []string{"127.0.0.1", "::1", "fe80::1%lo0", "2001:db8::"} {
+					parsed, ok := parseIP(ip)
+					So(ok, ShouldBeTrue)
+					So(parsed, ShouldEqual, ip)
+				}
+			})
+			Convey("should reject everything else", func() {
+				for _, s := range []string{"64", "bytes", "1.76", "google.com", "%lo0", ""} {
+					_, ok := parseIP(s)
+					So(ok, ShouldBeFalse)
+				}
+			})
+		})
+
+		Convey("pingCommand()", func() {
+			Convey("should ping IPv4 with ping", func() {
+				ipAddr, _ := ResolveIP("127.0.0.1", FamilyAny)
+				name, args := pingCommand(ipAddr, Options{})
+				So(name, ShouldEqual, "ping")
+				So(args, ShouldResemble, []string{"-c", "1", "127.0.0.1"})
+			})
+			Convey("should ping IPv6 with ping6 or ping -6", func() {
+				ipAddr, _ := ResolveIP("::1", FamilyAny)
+				name, args := pingCommand(ipAddr, Options{})
+				if name == "ping6" {
+					So(args, ShouldResemble, []string{"-c", "1", "::1"})
+				} else {
+					So(args, ShouldResemble, []string{"-6", "-c", "1", "::1"})
+				}
+			})
+		})
+
+		Convey("pingArgs()", func() {
+			v4, _ := ResolveIP("10.0.0.1", FamilyAny)
+			v6, _ := ResolveIP("::1", FamilyAny)
+			opts := Options{Timeout: 1500 * time.Millisecond, Size: 100, TTL: 32}
+
+			Convey("should only add flags for the options that are set", func() {
+				for _, goos := range []string{"linux", "darwin"} {
+					So(pingArgs(goos, v4, Options{}, false), ShouldResemble, []string{"-c", "1", "10.0.0.1"})
+				}
+				So(pingArgs("windows", v4, Options{}, false), ShouldResemble, []string{"-n", "1", "10.0.0.1"})
+			})
+			Convey("should round the timeout up to seconds on linux", func() {
+				So(pingArgs("linux", v4, opts, false), ShouldResemble,
+					[]string{"-c", "1", "-W", "2", "-s", "100", "-t", "32", "10.0.0.1"})
+				So(pingArgs("linux", v6, opts, false), ShouldResemble,
+					[]string{"-6", "-c", "1", "-W", "2", "-s", "100", "-t", "32", "::1"})
+			})
+			Convey("should use the macOS & BSD flags", func() {
+				So(pingArgs("darwin", v4, opts, false), ShouldResemble,
+					[]string{"-c", "1", "-W", "1500", "-s", "100", "-m", "32", "10.0.0.1"})
+				So(pingArgs("darwin", v6, opts, true), ShouldResemble,
+					[]string{"-c", "1", "-s", "100", "-h", "32", "::1"})
+			})
+			Convey("should use the windows flags", func() {
+				So(pingArgs("windows", v4, opts, false), ShouldResemble,
+					[]string{"-n", "1", "-w", "1500", "-l", "100", "-i", "32", "10.0.0.1"})
+			})
+		})
+
+		Convey("ParseTarget()", func() {
+			defaults := Options{Family: IPv4, Interval: time.Second, Size: 64}
+
+			Convey("should return the defaults when there are no options", func() {
+				target, opts, err := ParseTarget("google.com", defaults)
+				So(err, ShouldBeNil)
+				So(target, ShouldEqual, "google.com")
+				So(opts, ShouldResemble, defaults)
+			})
+			Convey("should override the defaults", func() {
+				target, opts, err := ParseTarget("google.com?interval=500ms&timeout=2s&count=10&ttl=32", defaults)
+				So(err, ShouldBeNil)
+				So(target, ShouldEqual, "google.com")
+				So(opts, ShouldResemble, Options{Family: IPv4, Interval: 500 * time.Millisecond,
+					Timeout: 2 * time.Second, Count: 10, Size: 64, TTL: 32})
+			})
+			Convey("should return error for unknown or invalid options", func() {
+				for _, spec := range []string{"google.com?foo=1", "google.com?interval=fast", "google.com?ttl=256", "google.com?count=-1", "google.com?interval=1ns", "google.com?interval=199ms"} {
+					_, _, err := ParseTarget(spec, defaults)
+					So(err, ShouldNotBeNil)
+				}
+			})
+		})
+
+		Convey("Validate() should only allow intervals of at least MinInterval", func() {
+			So(Options{}.Validate(), ShouldBeNil)
+			So(Options{Interval: MinInterval}.Validate(), ShouldBeNil)
+			err := Options{Interval: time.Nanosecond}.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "200ms")
+		})
+		Convey("GetInterval()", func() {
+			So(Options{}.GetInterval(), ShouldEqual, DefaultInterval)
+			So(Options{Interval: 200 * time.Millisecond}.GetInterval(), ShouldEqual, 200*time.Millisecond)
+		})
+	})
+}
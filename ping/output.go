@@ -0,0 +1,241 @@
+package ping
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// DestinationUnreachableError is the error of ping output w/o a reply, see NoReplyError
+const DestinationUnreachableError = "Destination unreachable"
+
+// TTLExceededError is the error of ping output where a router reported the TTL ran out
+const TTLExceededError = "Time to live exceeded"
+
+// Outcome is what happened to a ping, or what a line of ping command output reports
+type Outcome int
+
+const (
+	OutcomeNone            Outcome = iota // not a reply or an error, ex: the header or statistics
+	OutcomeReply                          // an echo reply
+	OutcomeTimeout                        // no reply in time, ex: Request timeout for icmp_seq 0
+	OutcomeHostUnreachable                // ex: Destination Host Unreachable
+	OutcomeTTLExceeded                    // ex: Time to live exceeded
+	OutcomeNetUnreachable                 // ex: Destination Net Unreachable, Network is unreachable
+	OutcomeDNS                            // the host didn't resolve
+	OutcomePermission                     // not permitted to send the ping
+	OutcomeLocal                          // any other error on this side, ex: out of sockets
+	OutcomeRejected                       // the target answered w/ an error, ex: connection refused, HTTP 500, SERVFAIL
+)
+
+var outcomeNames = map[Outcome]string{
+	OutcomeNone:            "none",
+	OutcomeReply:           "reply",
+	OutcomeTimeout:         "timeout",
+	OutcomeHostUnreachable: "host_unreachable",
+	OutcomeTTLExceeded:     "ttl_exceeded",
+	OutcomeNetUnreachable:  "net_unreachable",
+	OutcomeDNS:             "dns",
+	OutcomePermission:      "permission",
+	OutcomeLocal:           "local",
+	OutcomeRejected:        "rejected",
+}
+
+func (o Outcome) String() string {
+	if name, ok := outcomeNames[o]; ok {
+		return name
+	}
+	return "Outcome(" + strconv.Itoa(int(o)) + ")"
+}
+
+// outcomePhrases give away lines w/o a reply, in lower case. They're checked
+// before replies b/c BSD & macOS start errors w/ "92 bytes from"
+var outcomePhrases = []struct {
+	phrase  string
+	outcome Outcome
+}{
+	{"net unreachable", OutcomeNetUnreachable},
+	{"network unreachable", OutcomeNetUnreachable},
+	{"network is unreachable", OutcomeNetUnreachable},
+	{"unreachable", OutcomeHostUnreachable},
+	{"no route to host", OutcomeHostUnreachable},
+	{"host is down", OutcomeHostUnreachable},
+	{"time to live exceeded", OutcomeTTLExceeded},
+	{"ttl expired", OutcomeTTLExceeded},   // windows
+	{"request timeout", OutcomeTimeout},   // bsd & macOS
+	{"request timed out", OutcomeTimeout}, // windows
+	{"no answer yet", OutcomeTimeout},     // iputils -O
+	{"unknown host", OutcomeDNS},
+	{"cannot resolve", OutcomeDNS},
+	{"name or service not known", OutcomeDNS},
+	{"failure in name resolution", OutcomeDNS},
+	{"could not find host", OutcomeDNS}, // windows
+	{"operation not permitted", OutcomePermission},
+	{"permission denied", OutcomePermission},
+}
+
+// PingLine is one parsed line of ping command output
+type PingLine struct {
+	Outcome Outcome
+	From    string        // the address that replied or reported the error, "" if it's not on the line
+	Reply   *PingResponse // only for OutcomeReply
+}
+
+// NoReplyError is returned by ParsePingOutput when the output has no reply
+type NoReplyError struct {
+	Outcome Outcome // OutcomeTimeout when ping didn't say why
+	From    string  // the address that reported the error, if any
+	Line    string  // the line the outcome was parsed from, if any
+}
+
+// Error is DestinationUnreachableError for anything but an exceeded TTL, it's
+// what ParsePingOutput has always returned w/o a reply
+func (e *NoReplyError) Error() string {
+	if e.Outcome == OutcomeTTLExceeded {
+		return TTLExceededError
+	}
+	return DestinationUnreachableError
+}
+
+// ParsePingOutput will parse the entire output of a ping command.
+// If there is more than one reply only the first one is parsed.
+// If there is no reply it returns a *NoReplyError w/ the first error ping printed
+func ParsePingOutput(res []byte) (*PingResponse, error) {
+	var noReply *NoReplyError
+	for _, line := range strings.Split(string(res), "\n") {
+		pl, err := ParsePingLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if pl.Outcome == OutcomeReply {
+			return pl.Reply, nil
+		}
+		if pl.Outcome != OutcomeNone && noReply == nil {
+			noReply = &NoReplyError{Outcome: pl.Outcome, From: pl.From, Line: strings.TrimSpace(line)}
+		}
+	}
+	if noReply == nil {
+		noReply = &NoReplyError{Outcome: OutcomeTimeout}
+	}
+	return nil, noReply
+}
+
+// ParsePingLine parses one line of the output of iputils, BusyBox, BSD, macOS or
+// Windows ping. Lines that aren't a reply or an error are OutcomeNone, an error
+// is only returned for a reply that can't be parsed
+func ParsePingLine(s string) (*PingLine, error) {
+	s = stripTimestamp(strings.TrimSpace(s))
+	lower := strings.ToLower(s)
+	// the header & statistics, which can hold any hostname
+	if strings.HasPrefix(lower, "ping ") || strings.HasPrefix(lower, "pinging ") || strings.HasPrefix(lower, "---") {
+		return &PingLine{}, nil
+	}
+
+	for _, p := range outcomePhrases {
+		if strings.Contains(lower, p.phrase) {
+			return &PingLine{Outcome: p.outcome, From: parseFrom(strings.Fields(s))}, nil
+		}
+	}
+	if !strings.Contains(lower, "bytes from") && !strings.HasPrefix(lower, "reply from") {
+		return &PingLine{}, nil
+	}
+
+	pr, err := ParsePingResponseLine(s)
+	if err != nil {
+		return nil, err
+	}
+	return &PingLine{Outcome: OutcomeReply, From: pr.IP, Reply: pr}, nil
+}
+
+// ParsePingResponseLine parses a successful ping reply and returns a corresponding PingResponse struct
+// Replies from IPv6 pings (ping6 or ping -6) are supported, hlim is parsed as the TTL.
+// Windows' time<1ms is parsed as 1 ms
+func ParsePingResponseLine(s string) (*PingResponse, error) {
+	fields := strings.Fields(stripTimestamp(strings.TrimSpace(s)))
+
+	pr := &PingResponse{IP: parseFrom(fields)}
+	hasTime := false
+	for i, field := range fields {
+		if field == "bytes" && i > 0 { // 64 bytes from
+			if n, err := strconv.Atoi(fields[i-1]); err == nil {
+				pr.Bytes = n
+			}
+			continue
+		}
+
+		key, value, ok := cutField(field)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "icmp_seq", "seq": // busybox uses seq
+			icmpSeq, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("Failed parsing icmp_seq:" + err.Error())
+			}
+			pr.ICMPSeq = icmpSeq
+		case "ttl", "hlim":
+			ttl, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("Failed parsing ttl:" + err.Error())
+			}
+			pr.TTL = ttl
+		case "time":
+			time, err := strconv.ParseFloat(strings.TrimSuffix(value, "ms"), 64)
+			if err != nil {
+				return nil, errors.New("Failed parsing time:" + err.Error())
+			}
+			pr.Time = time
+			hasTime = true
+		case "bytes": // windows
+			bytes, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("Failed parsing bytes:" + err.Error())
+			}
+			pr.Bytes = bytes
+		}
+	}
+	if !hasTime {
+		return nil, errors.New("No time found in ping reply: " + s)
+	}
+	return pr, nil
+}
+
+// stripTimestamp removes the [1697040000.123456] iputils -D puts before every line
+func stripTimestamp(s string) string {
+	if !strings.HasPrefix(s, "[") {
+		return s
+	}
+	if i := strings.Index(s, "]"); i > 0 {
+		return strings.TrimSpace(s[i+1:])
+	}
+	return s
+}
+
+// parseFrom returns the address after "from", ex: from host (10.0.0.1): or From 10.0.0.1
+func parseFrom(fields []string) string {
+	for i, field := range fields {
+		if !strings.EqualFold(field, "from") {
+			continue
+		}
+		ip := ""
+		for j := i + 1; j < len(fields) && j <= i+2; j++ {
+			// IPv6 addresses can start & end with colons (::1), so only trim the one after the address
+			part := strings.Trim(strings.TrimSuffix(fields[j], ":"), "(),")
+			if addr, ok := parseIP(part); ok {
+				ip = addr
+			}
+		}
+		return ip
+	}
+	return ""
+}
+
+// cutField splits key=value, or Windows' time<1ms, the key is returned in lower case
+func cutField(field string) (key, value string, ok bool) {
+	i := strings.IndexAny(field, "=<")
+	if i <= 0 || i == len(field)-1 {
+		return "", "", false
+	}
+	return strings.ToLower(field[:i]), strings.TrimRight(field[i+1:], ",."), true
+}
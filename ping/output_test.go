@@ -0,0 +1,112 @@
+package ping
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// outputFixture is what ParsePingOutput should find in a file of testdata/output
+type outputFixture struct {
+	outcome Outcome
+	ip      string // the address that replied or reported the error
+	seq     int
+	ttl     int
+	time    float64
+	bytes   int
+}
+
+// outputFixtures has an entry for every file of testdata/output, captured from
+// the ping command of the OS the file is named after
+var outputFixtures = map[string]outputFixture{
+	"busybox_reply.txt":           {outcome: OutcomeReply, ip: "10.0.0.1", seq: 0, ttl: 64, time: 0.086, bytes: 64},
+	"busybox_timeout.txt":         {outcome: OutcomeTimeout},
+	"darwin_ping6.txt":            {outcome: OutcomeReply, ip: "::1", seq: 0, ttl: 64, time: 0.070, bytes: 16},
+	"darwin_reply.txt":            {outcome: OutcomeReply, ip: "10.0.0.1", seq: 0, ttl: 64, time: 3.416, bytes: 64},
+	"darwin_timeout.txt":          {outcome: OutcomeTimeout},
+	"darwin_ttl_exceeded.txt":     {outcome: OutcomeTTLExceeded, ip: "10.0.0.1"},
+	"darwin_unknown_host.txt":     {outcome: OutcomeDNS},
+	"darwin_unreachable.txt":      {outcome: OutcomeHostUnreachable, ip: "10.0.0.5"},
+	"freebsd_sendto.txt":          {outcome: OutcomeHostUnreachable},
+	"iputils_dup.txt":             {outcome: OutcomeReply, ip: "10.0.0.7", seq: 1, ttl: 64, time: 0.418, bytes: 64},
+	"iputils_hostname.txt":        {outcome: OutcomeReply, ip: "142.250.80.46", seq: 1, ttl: 117, time: 4.21, bytes: 64},
+	"iputils_ipv6.txt":            {outcome: OutcomeReply, ip: "::1", seq: 1, ttl: 64, time: 0.033, bytes: 64},
+	"iputils_net_unreachable.txt": {outcome: OutcomeNetUnreachable, ip: "10.0.0.1"},
+	"iputils_no_route.txt":        {outcome: OutcomeNetUnreachable},
+	"iputils_permission.txt":      {outcome: OutcomePermission},
+	"iputils_reply.txt":           {outcome: OutcomeReply, ip: "10.0.0.1", seq: 1, ttl: 64, time: 0.045, bytes: 64},
+	"iputils_size1000.txt":        {outcome: OutcomeReply, ip: "10.0.0.1", seq: 1, ttl: 64, time: 0.112, bytes: 1008},
+	"iputils_timeout.txt":         {outcome: OutcomeTimeout},
+	"iputils_timestamp.txt":       {outcome: OutcomeReply, ip: "10.0.0.1", seq: 1, ttl: 64, time: 0.061, bytes: 64},
+	"iputils_ttl_exceeded.txt":    {outcome: OutcomeTTLExceeded, ip: "10.0.0.1"},
+	"iputils_unknown_host.txt":    {outcome: OutcomeDNS},
+	"iputils_unreachable.txt":     {outcome: OutcomeHostUnreachable, ip: "10.0.0.5"},
+	"windows_lt1ms.txt":           {outcome: OutcomeReply, ip: "127.0.0.1", ttl: 128, time: 1, bytes: 32},
+	"windows_reply.txt":           {outcome: OutcomeReply, ip: "10.0.0.1", ttl: 64, time: 3, bytes: 32},
+	"windows_timeout.txt":         {outcome: OutcomeTimeout},
+	"windows_ttl_expired.txt":     {outcome: OutcomeTTLExceeded, ip: "10.0.0.1"},
+	"windows_unreachable.txt":     {outcome: OutcomeHostUnreachable, ip: "10.0.0.5"},
+}
+
+func Test_output_unit(t *testing.T) {
+	Convey("output", t, func() {
+		Convey("ParsePingOutput()", func() {
+			files, err := filepath.Glob("testdata/output/*.txt")
+			So(err, ShouldBeNil)
+			So(len(files), ShouldEqual, len(outputFixtures))
+
+			for _, file := range files {
+				want, ok := outputFixtures[filepath.Base(file)]
+				So(ok, ShouldBeTrue)
+				b, err := ioutil.ReadFile(file)
+				So(err, ShouldBeNil)
+
+				pr, err := ParsePingOutput(b)
+				if want.outcome != OutcomeReply {
+					So(pr, ShouldBeNil)
+					nr, ok := err.(*NoReplyError)
+					So(ok, ShouldBeTrue)
+					So(nr.Outcome, ShouldEqual, want.outcome)
+					So(nr.From, ShouldEqual, want.ip)
+					continue
+				}
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, want.ip)
+				So(pr.ICMPSeq, ShouldEqual, want.seq)
+				So(pr.TTL, ShouldEqual, want.ttl)
+				So(pr.Time, ShouldEqual, want.time)
+				So(pr.Bytes, ShouldEqual, want.bytes)
+			}
+		})
+
+		Convey("ParsePingLine()", func() {
+			Convey("should ignore the header & statistics", func() {
+				for _, line := range []string{
+					"PING unreachable.example.com (10.0.0.1) 56(84) bytes of data.",
+					"--- unreachable.example.com ping statistics ---",
+					"1 packets transmitted, 0 received, 100% packet loss, time 0ms",
+					"Vr HL TOS  Len   ID Flg  off TTL Pro  cks      Src      Dst",
+					"",
+				} {
+					pl, err := ParsePingLine(line)
+					So(err, ShouldBeNil)
+					So(pl.Outcome, ShouldEqual, OutcomeNone)
+				}
+			})
+			Convey("should return error given a reply w/o a time", func() {
+				_, err := ParsePingLine("64 bytes from 10.0.0.1: icmp_seq=1 ttl=64")
+				So(err, ShouldNotBeNil)
+				_, err = ParsePingLine("64 bytes from 10.0.0.1: icmp_seq=x ttl=64 time=1 ms")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("NoReplyError", func() {
+			So((&NoReplyError{Outcome: OutcomeTimeout}).Error(), ShouldEqual, DestinationUnreachableError)
+			So((&NoReplyError{Outcome: OutcomeTTLExceeded}).Error(), ShouldEqual, TTLExceededError)
+			So(OutcomeTTLExceeded.String(), ShouldEqual, "ttl_exceeded")
+		})
+	})
+}
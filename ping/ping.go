@@ -1,97 +1,44 @@
 package ping
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os/exec"
 	"regexp"
+	"runtime"
 	"strconv"
-	"strings"
+	"time"
 )
 
-const DestinationUnreachableError = "Destination unreachable"
-
 var (
-	ipRegex   = regexp.MustCompile(`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$`)
 	hostRegex = regexp.MustCompile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])$`)
 )
 
 type PingResponse struct {
 	ID      string
 	Host    string
-	IP      string
+	IP      string // the series the ping is saved to, the host the user asked for
+	Addr    string // the address Host resolved to & was pinged at, "" for pings w/o one, ex: HTTP
 	TTL     int
 	Time    float64
 	ICMPSeq int
+	Bytes   int      // the size of the reply the ping command reported, 0 if it didn't
+	Phases  []Phase  // extra timings, each saved as its own series (see PhaseSeries)
+	RCode   string   // DNS pings only, ex: NOERROR
+	Answers []string // DNS pings only, the sorted answer set
 }
 
-// ParsePingResponseLine parses a successful ping reply and returns a corresponding PingResponse struct
-func ParsePingResponseLine(s string) (*PingResponse, error) {
-	parts := strings.Split(s, " ")
-
-	pr := &PingResponse{}
-	for _, part := range parts {
-		part = strings.Trim(part, ":()")
-
-		if part == "64" || part == "from" || part == "bytes" || part == "ms" {
-			continue
-		}
-
-		if strings.HasPrefix(part, "icmp_seq") {
-			icmpParts := strings.Split(part, "=")
-			if len(icmpParts) != 2 {
-				return nil, errors.New("Unexpected # of parts found while parsing icmp_seq")
-			}
-
-			icmpSeq, err := strconv.Atoi(icmpParts[1])
-			if err != nil {
-				return nil, errors.New("Failed parsing icmp_seq:" + err.Error())
-			}
-			pr.ICMPSeq = icmpSeq
-		} else if strings.HasPrefix(part, "ttl") {
-			ttlParts := strings.Split(part, "=")
-			if len(ttlParts) != 2 {
-				return nil, errors.New("Unexpected # of parts found while parsing ttl")
-			}
-
-			ttl, err := strconv.Atoi(ttlParts[1])
-			if err != nil {
-				return nil, errors.New("Failed parsing ttl:" + err.Error())
-			}
-			pr.TTL = ttl
-		} else if strings.HasPrefix(part, "time") {
-			timeParts := strings.Split(part, "=")
-			if len(timeParts) != 2 {
-				return nil, errors.New("Unexpected # of parts found while parsing time")
-			}
-
-			time, err := strconv.ParseFloat(timeParts[1], 64)
-			if err != nil {
-				return nil, errors.New("Failed parsing time:" + err.Error())
-			}
-			pr.Time = time
-		} else if ipRegex.MatchString(part) {
-			pr.IP = part
-		}
-	}
-	return pr, nil
+// Phase is the time in ms spent in one part of a ping, ex: the DNS lookup of an HTTP ping
+type Phase struct {
+	Name string
+	Time float64
 }
 
-// ParsePingOutput will parse the entire output of a ping command.
-// If there is more than one reply only the first one is parsed.
-// If there is no reply it returns a DestinationUnreachableError
-func ParsePingOutput(res []byte) (*PingResponse, error) {
-	lines := strings.Split(string(res), "\n")
-	if len(lines[1]) == 0 {
-		return nil, errors.New(DestinationUnreachableError)
-	}
-
-	pr, err := ParsePingResponseLine(lines[1])
-	if err != nil {
-		return nil, err
-	}
-	return pr, nil
+// PhaseSeries returns the series a phase of a ping saved to series is stored in
+func PhaseSeries(series, phase string) string {
+	return series + "#" + phase
 }
 
 type TimeoutError interface {
@@ -99,17 +46,29 @@ type TimeoutError interface {
 	IP() string
 }
 
+// addrError is an error of a ping that got as far as resolving its host, see LostResult
+type addrError interface {
+	Addr() string
+}
+
 type PingError struct {
 	ip        string
+	addr      string // the address ip resolved to, if it did
 	msg       string
 	Output    string
 	IsTimeout bool
+	Outcome   Outcome // why there was no reply when the ping command said, see ParsePingLine
 }
 
 func (pe PingError) IP() string {
 	return pe.ip
 }
 
+// Addr returns the address the host resolved to & was pinged at, "" if it didn't resolve
+func (pe PingError) Addr() string {
+	return pe.addr
+}
+
 func (pe PingError) Error() string {
 	return pe.msg
 }
@@ -119,30 +78,125 @@ func (pe PingError) Timeout() bool {
 }
 
 // Ping will run the ping command and send 1 ping packet to the given hostOrIP
-// TODO: Add ipv6 regex
 func Ping(hostOrIP string) (*PingResponse, error) {
-	var ip string
-	if hostOrIP == "localhost" || hostOrIP == "::1" || hostOrIP == "fe80::1%lo0" {
-		ip = "127.0.0.1"
-	} else if ipRegex.MatchString(hostOrIP) {
-		ip = hostOrIP
-	} else {
-		addrs, err := net.LookupHost(hostOrIP)
-		if err != nil {
-			return nil, fmt.Errorf("ping.Ping: %s", err)
+	return PingWithOptions(hostOrIP, Options{})
+}
+
+// pingCommand returns the command & args that send 1 ping packet to ipAddr.
+// IPv6 uses ping6 where it exists (BSD, macOS, older linux), otherwise ping -6
+func pingCommand(ipAddr *net.IPAddr, opts Options) (string, []string) {
+	if isIPv6(ipAddr) {
+		if _, err := exec.LookPath("ping6"); err == nil {
+			return "ping6", pingArgs(runtime.GOOS, ipAddr, opts, true)
+		}
+	}
+	return "ping", pingArgs(runtime.GOOS, ipAddr, opts, false)
+}
+
+// pingArgs returns the args of the ping command on goos, every OS has its own
+// flags for the timeout, payload size & ttl. ping6 is true for the ping6 command
+func pingArgs(goos string, ipAddr *net.IPAddr, opts Options, ping6 bool) []string {
+	ms := strconv.FormatInt(int64(opts.Timeout/time.Millisecond), 10)
+	var args []string
+	switch goos {
+	case "windows":
+		args = []string{"-n", "1"}
+		if opts.Timeout > 0 {
+			args = append(args, "-w", ms)
+		}
+		if opts.Size > 0 {
+			args = append(args, "-l", strconv.Itoa(opts.Size))
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-i", strconv.Itoa(opts.TTL))
+		}
+	case "darwin", "freebsd", "dragonfly":
+		args = []string{"-c", "1"}
+		if opts.Timeout > 0 && !ping6 { // ping6 has no timeout
+			args = append(args, "-W", ms)
 		}
-		ip = addrs[0]
+		if opts.Size > 0 {
+			args = append(args, "-s", strconv.Itoa(opts.Size))
+		}
+		if opts.TTL > 0 && ping6 {
+			args = append(args, "-h", strconv.Itoa(opts.TTL))
+		} else if opts.TTL > 0 {
+			args = append(args, "-m", strconv.Itoa(opts.TTL))
+		}
+	default: // linux, iputils & busybox
+		args = []string{"-c", "1"}
+		if opts.Timeout > 0 { // whole seconds, rounded up
+			secs := (opts.Timeout + time.Second - 1) / time.Second
+			args = append(args, "-W", strconv.FormatInt(int64(secs), 10))
+		}
+		if opts.Size > 0 {
+			args = append(args, "-s", strconv.Itoa(opts.Size))
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-t", strconv.Itoa(opts.TTL))
+		}
+	}
+	if isIPv6(ipAddr) && !ping6 {
+		args = append([]string{"-6"}, args...)
+	}
+	return append(args, ipAddr.String())
+}
+
+// PingWithOptions will run the ping command and send 1 ping packet to the given hostOrIP
+func PingWithOptions(hostOrIP string, opts Options) (*PingResponse, error) {
+	return PingContext(context.Background(), hostOrIP, opts)
+}
+
+// pingCommandTimeOut is how long the ping command waits for a reply when it isn't
+// given a timeout (iputils' linger time)
+const pingCommandTimeOut = 10 * time.Second
+
+// execCommand starts the ping command, tests replace it w/ a fake ping
+var execCommand = exec.CommandContext
+
+// PingContext is PingWithOptions that kills the ping command when ctx is done,
+// ctx's error is returned when it's done before the command exits. A ping command
+// that hangs for a second longer than its timeout is killed & returns a timeout.
+func PingContext(ctx context.Context, hostOrIP string, opts Options) (*PingResponse, error) {
+	ipAddr, err := ResolveIP(hostOrIP, opts.Family)
+	if err != nil {
+		return nil, fmt.Errorf("ping.Ping: %w", err)
 	}
+	ip := ipAddr.String()
 
-	output, err := exec.Command("ping", "-c", "1", ip).CombinedOutput()
+	cmdCtx, cancel := context.WithTimeout(ctx, opts.timeout(pingCommandTimeOut)+time.Second)
+	defer cancel()
+
+	name, args := pingCommand(ipAddr, opts)
+	cmd := execCommand(cmdCtx, name, args...)
+	cmd.WaitDelay = time.Second // don't wait on the output of children that outlive a killed ping
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) { // the ping command couldn't be started
+		return nil, fmt.Errorf("ping.Ping: %w", err)
+	}
 	if err != nil {
-		err = &PingError{
-			ip:        ip,
+		pe := &PingError{
+			ip:        hostOrIP,
+			addr:      ip,
 			IsTimeout: true,
 			Output:    string(output),
 			msg:       err.Error(),
+			Outcome:   OutcomeTimeout,
 		}
-		return nil, err
+		// ping exits w/ an error when there's no reply, the output says why
+		if _, perr := ParsePingOutput(output); perr != nil {
+			if nr, ok := perr.(*NoReplyError); ok {
+				pe.Outcome = nr.Outcome
+				if nr.Line != "" {
+					pe.msg = nr.Line
+				}
+			}
+		}
+		return nil, pe
 	}
 
 	pr, err := ParsePingOutput(output)
@@ -152,21 +206,114 @@ func Ping(hostOrIP string) (*PingResponse, error) {
 
 	pr.IP = hostOrIP
 	pr.Host = hostOrIP
+	pr.Addr = ip
 	return pr, nil
 }
 
-// Ping will run the ping command and send 1 ping packet to the given hostOrIP
+// PingNative will send 1 ping packet to the given hostOrIP without shelling out
+// to the ping command, see Ping2. Timeouts are returned as a PingError like Ping.
 func PingNative(hostOrIP string) (*PingResponse, error) {
-	_, ms, err := Ping2(hostOrIP)
+	return PingNativeWithOptions(hostOrIP, Options{})
+}
+
+// PingNativeWithOptions is PingNative with options, see Ping2WithOptions
+func PingNativeWithOptions(hostOrIP string, opts Options) (*PingResponse, error) {
+	return PingNativeContext(context.Background(), hostOrIP, opts)
+}
+
+// PingNativeContext is PingNativeWithOptions that gives up when ctx is done, see Ping2Context
+func PingNativeContext(ctx context.Context, hostOrIP string, opts Options) (*PingResponse, error) {
+	ipAddr, err := ResolveIP(hostOrIP, opts.Family)
+	if err != nil {
+		return nil, err
+	}
+	ip := ipAddr.String()
+
+	_, ms, err := Ping2Context(ctx, ip, opts)
 	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = &PingError{
+				ip:        hostOrIP,
+				addr:      ip,
+				IsTimeout: true,
+				msg:       err.Error(),
+			}
+		}
 		return nil, err
 	}
 
 	pr := &PingResponse{
 		IP:   hostOrIP,
 		Host: hostOrIP,
+		Addr: ip,
 		Time: ms,
 	}
 
 	return pr, nil
 }
+
+// PingProber is a Prober that pings Host w/ the ping command, see Ping
+type PingProber struct {
+	Host string
+	Opts Options
+}
+
+func (p *PingProber) Name() string {
+	return p.Host
+}
+
+func (p *PingProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	pr, err := PingContext(ctx, p.Host, opts)
+	return newResult(start, pr, err)
+}
+
+// NativeProber is a Prober that pings Host w/o the ping command, over the socket
+// of Pinger when it's set, otherwise over a new socket every time, see PingNative.
+// With Compare (and Pinger) the userland RTT is returned as the PhaseUser phase
+// when the kernel timed the reply.
+type NativeProber struct {
+	Host    string
+	Opts    Options
+	Pinger  *Pinger
+	Compare bool
+}
+
+func (p *NativeProber) Name() string {
+	return p.Host
+}
+
+func (p *NativeProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if p.Pinger == nil {
+		pr, err := PingNativeContext(ctx, p.Host, opts)
+		return newResult(start, pr, err)
+	}
+
+	er, err := p.Pinger.Ping(ctx, p.Host, opts)
+	if err != nil {
+		return newResult(start, nil, err)
+	}
+	r, err := newResult(start, &PingResponse{IP: p.Host, Host: p.Host, Addr: er.IP, Time: er.Time, ICMPSeq: er.Seq, TTL: er.TTL, Bytes: er.Bytes}, nil)
+	if p.Compare && er.Kernel {
+		r.Phases = []Phase{{Name: PhaseUser, Time: er.UserTime}}
+	}
+	if er.OutOfOrder || er.Duplicates > 0 {
+		r.Meta = map[string]string{}
+		if er.OutOfOrder {
+			r.Meta["out_of_order"] = "true"
+		}
+		if er.Duplicates > 0 {
+			r.Meta["dup"] = strconv.Itoa(er.Duplicates)
+		}
+	}
+	return r, err
+}
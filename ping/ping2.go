@@ -1,5 +1,5 @@
 /*
-This is not being used b/c the Golang implementation of ping
+This used to be opt-in (-native) b/c the Golang implementation of ping
 varies a great deal more than the measurements parsed from
 shelling out to ping. I suspect it's b/c of GC pausing but
 I'll have to test some more to be sure.
@@ -21,6 +21,12 @@ Note how the std dev and max pings vary so much more with Golang ping.
   02/14 02:40pm | 1 ms |  4 ms |   6 ms |    1 ms |      300 |
   02/14 02:45pm | 1 ms |  4 ms |  87 ms |    5 ms |      300 |
   02/14 02:50pm | 1 ms |  4 ms |  24 ms |    2 ms |      300 |
+
+Pinger (pinger.go) now measures the RTT w/ the kernel's receive timestamp
+(SO_TIMESTAMPNS, linux only) & the send time carried in the echo payload,
+so time spent waiting on the runtime before the reply is read isn't counted.
+It's the default when the OS allows it, -native=false shells out to ping.
+Run w/ -compare to save the old userland RTT next to it as <host>#user.
 */
 
 // Based on pingg code
@@ -32,19 +38,80 @@ Note how the std dev and max pings vary so much more with Golang ping.
 package ping
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"log"
 	"net"
 	"os"
+	"syscall"
 	"time"
 )
 
-// Timeout sets the ping timeout in milliseconds
+// Timeout sets the ping timeout in milliseconds, Options.Timeout overrides it
 var TimeOut = 3000 * time.Millisecond
 
+// DefaultSize is the # of payload bytes Ping2 sends when Options.Size isn't set
+const DefaultSize = 64
+
+// PermissionError is returned when the process isn't allowed to open an ICMP socket.
+// Unprivileged datagram sockets need the user's group in net.ipv4.ping_group_range,
+// otherwise raw sockets need root (or CAP_NET_RAW)
+type PermissionError struct {
+	Err error
+}
+
+func (pe PermissionError) Error() string {
+	return "ping: not permitted to open an icmp socket, run as root or add your group to net.ipv4.ping_group_range: " + pe.Err.Error()
+}
+
+// dialICMP opens an ICMP (or ICMPv6) socket connected to ipAddr. It prefers an
+// unprivileged datagram socket and falls back to a raw socket. raw is true when
+// the socket is a raw socket, reads from a raw IPv4 socket include the IP header.
+func dialICMP(ipAddr *net.IPAddr) (c net.Conn, raw bool, err error) {
+	c, dgramErr := dialICMPDgram(ipAddr)
+	if dgramErr == nil {
+		return c, false, nil
+	}
+
+	network := "ip4:icmp"
+	if isIPv6(ipAddr) {
+		network = "ip6:ipv6-icmp"
+	}
+	c, err = net.Dial(network, ipAddr.String())
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, false, PermissionError{Err: err}
+		}
+		return nil, false, err
+	}
+	return c, true, nil
+}
+
+// NativeSupported returns true if this process can open an ICMP socket,
+// either an unprivileged datagram socket or a raw socket, ie Ping2 will work
+func NativeSupported() bool {
+	c, _, err := dialICMP(&net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
 // Ping sends a ping command to a given host, returns whether is host answers or not
 func Ping2(host string) (up bool, ms float64, err error) {
+	return Ping2WithOptions(host, Options{})
+}
+
+// Ping2WithOptions is Ping2 with options, IPv6 hosts are pinged with ICMPv6.
+// Replies that don't arrive within opts.Timeout are a timeout, including the
+// ones a router drops b/c opts.TTL was exceeded.
+func Ping2WithOptions(host string, opts Options) (up bool, ms float64, err error) {
+	return Ping2Context(context.Background(), host, opts)
+}
+
+// Ping2Context is Ping2WithOptions that closes the socket when ctx is done,
+// ctx's error is returned when it's done before the reply arrives
+func Ping2Context(ctx context.Context, host string, opts Options) (up bool, ms float64, err error) {
 
 	// Don't panic, just return nil
 	defer func() {
@@ -55,17 +122,32 @@ func Ping2(host string) (up bool, ms float64, err error) {
 		}
 	}()
 
-	if os.Geteuid() != 0 {
-		log.Fatal("skipping ping, root perimisisons missing")
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+	ipAddr, err := ResolveIP(host, opts.Family)
+	if err != nil {
+		return false, 0, err
 	}
+	v6 := isIPv6(ipAddr)
 
-	c, err := net.Dial("ip:icmp", host)
+	c, raw, err := dialICMP(ipAddr)
 	if err != nil {
 		return false, 0, err
 	}
 
-	c.SetDeadline(time.Now().Add(TimeOut))
 	defer c.Close()
+	defer closeOnDone(ctx, c)()
+	if opts.TTL > 0 {
+		sc, ok := c.(syscall.Conn)
+		if !ok {
+			return false, 0, errors.New("ping: can't set the ttl of the icmp socket")
+		}
+		if err := setTTL(sc, opts.TTL, v6); err != nil {
+			return false, 0, err
+		}
+	}
+	c.SetDeadline(time.Now().Add(opts.timeout(TimeOut)))
 
 	xid, xseq := os.Getpid()&0xffff, 1
 	// b, err := (&icmpMessage{
@@ -76,64 +158,121 @@ func Ping2(host string) (up bool, ms float64, err error) {
 	// 		Data: []byte("ping.gg.ping.gg.ping.gg"),
 	// 	},
 	// }).Marshal()
-	dataBytes := make([]byte, 64)
+	size := opts.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+	dataBytes := make([]byte, size)
 	// dataBytes := []byte("ping.gg.ping.gg.ping.gg")
-	b, err := ICMPMsg(icmpv4EchoRequest, 0, xid, xseq, dataBytes)
+	echoRequest := icmpv4EchoRequest
+	if v6 {
+		echoRequest = icmpv6EchoRequest
+	}
+	b, err := ICMPMsg(echoRequest, 0, xid, xseq, dataBytes)
 	if err != nil {
 		return false, 0, err
 	}
 
 	start := time.Now()
 	if _, err := c.Write(b); err != nil {
+		if ctx.Err() != nil {
+			return false, 0, ctx.Err()
+		}
 		return false, 0, err
 	}
 
-	if _, err := c.Read(b); err != nil {
-		return false, 0, err
+	return readEchoReply(ctx, c, raw, v6, xid, xseq, len(b), start)
+}
+
+// readEchoReply reads from c until the reply to the echo request w/ the given id
+// & seq, of size bytes, sent at start. A raw socket sees every ICMP message the
+// host receives, so the ones that aren't the reply are skipped, even when they
+// can't be parsed, a datagram socket only gets our replies.
+func readEchoReply(ctx context.Context, c net.Conn, raw, v6 bool, xid, xseq, size int, start time.Time) (up bool, ms float64, err error) {
+	echoReply := icmpv4EchoReply
+	if v6 {
+		echoReply = icmpv6EchoReply
 	}
-	end := time.Now()
-	ms = float64(end.Sub(start)) / float64(time.Millisecond)
-	// fmt.Printf("%.3f ms\n", ms)
 
-	b = ipv4Payload(b)
+	// leave room for the IPv4 header that raw sockets hand back
+	rb := make([]byte, size+60)
+	for {
+		n, err := c.Read(rb)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, 0, ctx.Err()
+			}
+			return false, 0, err
+		}
+		end := time.Now()
+		ms = float64(end.Sub(start)) / float64(time.Millisecond)
+		// fmt.Printf("%.3f ms\n", ms)
+
+		b := rb[:n]
+		if raw && !v6 { // the kernel never hands us the IPv6 header
+			if b, err = ipv4Payload(b); err != nil {
+				continue // not our reply, a raw socket sees all ICMP traffic
+			}
+		}
 
-	var m *icmpMessage
-	if m, err = parseICMPMessage(b); err != nil {
-		return false, 0, err
-	}
+		var m *icmpMessage
+		if m, err = parseICMPMessage(b); err != nil {
+			if raw {
+				continue // not our reply either
+			}
+			return false, 0, err
+		}
 
-	if m.Type != icmpv4EchoReply && m.Type != icmpv6EchoReply {
-		return false, 0, errors.New("invalid reply type")
-	}
+		// raw sockets get a copy of every ICMP message the host receives, including
+		// our own request when pinging ourselves, ICMPv6 neighbor discovery & time
+		// exceeded messages, skip them. Datagram sockets only get echo replies
+		if raw && m.Type != echoReply {
+			continue
+		}
 
-	switch p := m.Body.(type) {
-	case *icmpEcho:
-		if p.ID != xid {
-			fmt.Printf("err: id: %d, xid: %d\n", p.ID, xid)
-			return false, 0, errors.New("invalid reply indentifier")
+		if m.Type != echoReply {
+			return false, 0, errors.New("invalid reply type")
 		}
-		if p.Seq != xseq {
-			return false, 0, errors.New("invalid reply sequence")
+
+		switch p := m.Body.(type) {
+		case *icmpEcho:
+			// the kernel picks the identifier of datagram sockets and only hands
+			// us replies that match it, a raw socket sees replies to other processes
+			if raw && p.ID != xid {
+				continue
+			}
+			if p.Seq != xseq {
+				return false, 0, errors.New("invalid reply sequence")
+			}
+			return true, ms, nil // UP!
+		default:
+			return false, 0, errors.New("invalid reply payload")
 		}
-		return true, ms, nil // UP!
-	default:
-		return false, 0, errors.New("invalid reply payload")
 	}
 }
 
-func ipv4Payload(b []byte) []byte {
-	if len(b) < 20 {
-		return b
+// ipv4Payload strips the IPv4 header from b, b is returned as is when it doesn't
+// start w/ one
+func ipv4Payload(b []byte) ([]byte, error) {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return b, nil
 	}
 	hdrlen := int(b[0]&0x0f) << 2
-	return b[hdrlen:]
+	if hdrlen < 20 || hdrlen > len(b) {
+		return nil, errors.New("invalid IPv4 header length")
+	}
+	return b[hdrlen:], nil
 }
 
 const (
-	icmpv4EchoRequest = 8
-	icmpv4EchoReply   = 0
-	icmpv6EchoRequest = 128
-	icmpv6EchoReply   = 129
+	icmpv4EchoRequest     = 8
+	icmpv4EchoReply       = 0
+	icmpv4DestUnreachable = 3
+	icmpv4TimeExceeded    = 11
+	icmpv6EchoRequest     = 128
+	icmpv6EchoReply       = 129
+	icmpv6DestUnreachable = 1
+	icmpv6TimeExceeded    = 3
 )
 
 // icmpMessage represents an ICMP message.
@@ -219,14 +358,15 @@ func parseICMPMessage(b []byte) (*icmpMessage, error) {
 		return nil, errors.New("message too short")
 	}
 	m := &icmpMessage{Type: int(b[0]), Code: int(b[1]), Checksum: int(b[2])<<8 | int(b[3])}
-	if msglen > 4 {
+	switch m.Type {
+	case icmpv4EchoRequest, icmpv4EchoReply, icmpv6EchoRequest, icmpv6EchoReply:
 		var err error
-		switch m.Type {
-		case icmpv4EchoRequest, icmpv4EchoReply, icmpv6EchoRequest, icmpv6EchoReply:
-			m.Body, err = parseICMPEcho(b[4:])
-			if err != nil {
-				return nil, err
-			}
+		if m.Body, err = parseICMPEcho(b[4:]); err != nil {
+			return nil, err
+		}
+	case icmpv4DestUnreachable, icmpv4TimeExceeded, icmpv6DestUnreachable: // icmpv6TimeExceeded == icmpv4DestUnreachable
+		if msglen > 4 {
+			m.Body = parseICMPError(b[4:])
 		}
 	}
 	return m, nil
@@ -260,6 +400,9 @@ func (p *icmpEcho) Marshal() ([]byte, error) {
 // body.
 func parseICMPEcho(b []byte) (*icmpEcho, error) {
 	bodylen := len(b)
+	if bodylen < 4 {
+		return nil, errors.New("echo message too short")
+	}
 	p := &icmpEcho{ID: int(b[0])<<8 | int(b[1]), Seq: int(b[2])<<8 | int(b[3])}
 	if bodylen > 4 {
 		p.Data = make([]byte, bodylen-4)
@@ -267,3 +410,65 @@ func parseICMPEcho(b []byte) (*icmpEcho, error) {
 	}
 	return p, nil
 }
+
+// icmpError represents the body of an ICMP time exceeded or destination
+// unreachable message, it quotes the start of the datagram that caused it
+type icmpError struct {
+	Data []byte // the quoted datagram, starting w/ its IP header
+}
+
+func (p *icmpError) Len() int {
+	if p == nil {
+		return 0
+	}
+	return 4 + len(p.Data)
+}
+
+// Marshal returns the binary encoding of the ICMP error message body p.
+func (p *icmpError) Marshal() ([]byte, error) {
+	b := make([]byte, 4+len(p.Data))
+	copy(b[4:], p.Data)
+	return b, nil
+}
+
+// Echo returns the echo request quoted by the error, nil if it quotes something
+// else. An error is returned when the quoted IPv4 header is cut short.
+func (p *icmpError) Echo() (*icmpEcho, error) {
+	b, echoRequest := p.Data, icmpv4EchoRequest
+	if len(b) == 0 {
+		return nil, nil
+	}
+	// the type #s of ICMP & ICMPv6 overlap, so go by the version of the quoted header
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 || b[9] != 1 { // protocol must be ICMP
+			return nil, nil
+		}
+		hdrlen := int(b[0]&0x0f) << 2
+		if hdrlen < 20 || hdrlen+8 > len(b) {
+			return nil, errors.New("invalid quoted IPv4 header length")
+		}
+		b = b[hdrlen:]
+	case 6:
+		if len(b) < 40 || b[6] != 58 { // next header must be ICMPv6
+			return nil, nil
+		}
+		b, echoRequest = b[40:], icmpv6EchoRequest
+	default:
+		return nil, nil
+	}
+	if len(b) < 8 || b[0] != byte(echoRequest) {
+		return nil, nil
+	}
+	return parseICMPEcho(b[4:8])
+}
+
+// parseICMPError parses b as an ICMP time exceeded or destination unreachable message body
+func parseICMPError(b []byte) *icmpError {
+	p := &icmpError{}
+	if len(b) > 4 { // skip the unused (or MTU) field
+		p.Data = make([]byte, len(b)-4)
+		copy(p.Data, b[4:])
+	}
+	return p
+}
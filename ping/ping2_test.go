@@ -1,39 +1,146 @@
 package ping
 
-//
-// import (
-// 	"testing"
-// 	"time"
-// )
-//
-// var pingtests = []struct {
-// 	host string
-// 	ping bool
-// 	err  string
-// }{
-// 	{"127.0.0.1", true, ""},
-// 	// {"8.8.8.8", true, ""},
-// 	// {"google.com", true, ""},
-// 	// {"128.0.0.1", false, "read ip 128.0.0.1: i/o timeout"},
-// 	// {"fail.ping.gg", false, "dial ip:icmp: lookup fail.ping.gg: no such host"},
-// }
-//
-// func TestPing(t *testing.T) {
-//
-// 	TimeOut = time.Second / 2
-//
-// 	for _, tt := range pingtests {
-// 		ping, err := Ping2(tt.host)
-// 		if ping {
-// 			if !tt.ping || err != nil {
-// 				t.Errorf("Incorrect ping for host: %s resulted: %t with error: %s", tt.host, ping, err.Error())
-// 			}
-// 		}
-//
-// 		if !ping {
-// 			if tt.ping || err.Error() != tt.err {
-// 				t.Errorf("Incorrect ping for host: %s resulted: %t with error: %s", tt.host, ping, err.Error())
-// 			}
-// 		}
-// 	}
-// }
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ping2_unit(t *testing.T) {
+	Convey("ping2", t, func() {
+		Convey("ICMPMsg()", func() {
+			Convey("should build an echo request parseICMPMessage can read", func() {
+				b, err := ICMPMsg(icmpv4EchoRequest, 0, 0x1234, 7, make([]byte, 8))
+				So(err, ShouldBeNil)
+
+				m, err := parseICMPMessage(b)
+				So(err, ShouldBeNil)
+				So(m.Type, ShouldEqual, icmpv4EchoRequest)
+				echo, ok := m.Body.(*icmpEcho)
+				So(ok, ShouldBeTrue)
+				So(echo.ID, ShouldEqual, 0x1234)
+				So(echo.Seq, ShouldEqual, 7)
+				So(len(echo.Data), ShouldEqual, 8)
+			})
+		})
+
+		Convey("readEchoReply()", func() {
+			reply, err := ICMPMsg(icmpv4EchoReply, 0, 0x1234, 1, make([]byte, 8))
+			So(err, ShouldBeNil)
+			garbage := []byte{icmpv4EchoReply, 0, 0}
+
+			Convey("should skip the messages a raw socket can't parse", func() {
+				c := &packetConn{packets: [][]byte{garbage, reply}}
+				up, _, err := readEchoReply(context.Background(), c, true, false, 0x1234, 1, len(reply), time.Now())
+				So(err, ShouldBeNil)
+				So(up, ShouldBeTrue)
+			})
+			Convey("should return error when a datagram socket's reply can't be parsed", func() {
+				c := &packetConn{packets: [][]byte{garbage, reply}}
+				up, _, err := readEchoReply(context.Background(), c, false, false, 0x1234, 1, len(reply), time.Now())
+				So(err, ShouldNotBeNil)
+				So(up, ShouldBeFalse)
+			})
+		})
+
+		Convey("PermissionError", func() {
+			Convey("should wrap the socket error", func() {
+				err := PermissionError{Err: os.NewSyscallError("socket", syscall.EPERM)}
+				So(err.Error(), ShouldContainSubstring, "ping_group_range")
+				So(err.Error(), ShouldContainSubstring, "operation not permitted")
+				So(errors.Is(err.Err, os.ErrPermission), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func Test_ping2_integration(t *testing.T) {
+	Convey("ping2", t, func() {
+		Convey("Ping2()", func() {
+			if !NativeSupported() {
+				Convey("should return PermissionError without icmp socket permissions", func() {
+					_, _, err := Ping2("127.0.0.1")
+					_, ok := err.(PermissionError)
+					So(ok, ShouldBeTrue)
+				})
+				return
+			}
+			Convey("should ping 127.0.0.1", func() {
+				up, ms, err := Ping2("127.0.0.1")
+				So(err, ShouldBeNil)
+				So(up, ShouldBeTrue)
+				So(ms, ShouldBeGreaterThan, 0)
+			})
+			Convey("should ping 127.0.0.1 w/ a ttl, size & timeout", func() {
+				up, _, err := Ping2WithOptions("127.0.0.1", Options{TTL: 1, Size: 1000, Timeout: time.Second})
+				So(err, ShouldBeNil)
+				So(up, ShouldBeTrue)
+			})
+			Convey("should ping ::1 w/ ICMPv6", func() {
+				up, ms, err := Ping2WithOptions("::1", Options{Family: IPv6})
+				So(err, ShouldBeNil)
+				So(up, ShouldBeTrue)
+				So(ms, ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("Ping2Context()", func() {
+			Convey("should return right away when ctx is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, _, err := Ping2Context(ctx, "127.0.0.1", Options{})
+				if _, ok := err.(PermissionError); ok {
+					return
+				}
+				So(err, ShouldEqual, context.Canceled)
+			})
+			Convey("should stop waiting for a reply when ctx is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+
+				// TEST-NET-2 never answers, w/o a route to it the write fails right away
+				start := time.Now()
+				_, _, err := Ping2Context(ctx, "198.51.100.1", Options{Timeout: 10 * time.Second})
+				So(err, ShouldNotBeNil)
+				So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			})
+		})
+
+		Convey("dialICMP()", func() {
+			Convey("should open a datagram socket when the OS allows it", func() {
+				c, err := dialICMPDgram(&net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					return // not permitted here, dialICMP falls back to a raw socket
+				}
+				defer c.Close()
+
+				dc, raw, err := dialICMP(&net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				So(err, ShouldBeNil)
+				So(raw, ShouldBeFalse)
+				dc.Close()
+			})
+		})
+	})
+}
+
+// packetConn is a net.Conn that reads packets, one per Read, & then io.EOF
+type packetConn struct {
+	net.Conn
+	packets [][]byte
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	if len(c.packets) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.packets[0])
+	c.packets = c.packets[1:]
+	return n, nil
+}
@@ -1,7 +1,10 @@
 package ping
 
 import (
+	"context"
+	"os/exec"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -15,12 +18,18 @@ func Test_ping_unit(t *testing.T) {
 				"64 bytes from 127.0.0.1: icmp_seq=0 ttl=64 time=0.052 ms",                                             // bsd
 				"64 bytes from iad23s06-in-f0.1e100.net (74.125.228.32): icmp_seq=1 ttl=46 time=1.76 ms",               // gnu
 				"64 bytes from ip-23-229-234-162.ip.secureserver.net (23.229.234.162): icmp_seq=2 ttl=45 time=47.8 ms", // gnu
+				"64 bytes from ::1: icmp_seq=3 ttl=64 time=0.045 ms",                                                   // gnu ping -6
+				"64 bytes from lga25s71-in-x0e.1e100.net (2607:f8b0:4006:81c::200e): icmp_seq=4 ttl=117 time=1.23 ms",  // gnu ping -6
+				"16 bytes from fe80::1%lo0, icmp_seq=5 hlim=64 time=0.070 ms",                                          // bsd ping6
 			}
 
 			results := []PingResponse{
 				PingResponse{Host: "", IP: "127.0.0.1", ICMPSeq: 0, TTL: 64, Time: 0.052},
 				PingResponse{Host: "", IP: "74.125.228.32", ICMPSeq: 1, TTL: 46, Time: 1.76},
 				PingResponse{Host: "", IP: "23.229.234.162", ICMPSeq: 2, TTL: 45, Time: 47.8},
+				PingResponse{Host: "", IP: "::1", ICMPSeq: 3, TTL: 64, Time: 0.045},
+				PingResponse{Host: "", IP: "2607:f8b0:4006:81c::200e", ICMPSeq: 4, TTL: 117, Time: 1.23},
+				PingResponse{Host: "", IP: "fe80::1%lo0", ICMPSeq: 5, TTL: 64, Time: 0.070},
 			}
 
 			for i, test := range tests {
@@ -31,10 +40,65 @@ func Test_ping_unit(t *testing.T) {
 				So(pr.Host, ShouldEqual, results[i].Host)
 				So(pr.IP, ShouldEqual, results[i].IP)
 				So(pr.ICMPSeq, ShouldEqual, results[i].ICMPSeq)
+				So(pr.TTL, ShouldEqual, results[i].TTL)
 				So(pr.Time, ShouldEqual, results[i].Time)
 			}
 		})
 
+		Convey("PingContext()", func() {
+			Convey("should parse the output of the ping command", func() {
+				defer fakePing("cat", "testdata/output/iputils_reply.txt")()
+				pr, err := PingContext(context.Background(), "10.0.0.1", Options{})
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, "10.0.0.1")
+				So(pr.Time, ShouldEqual, 0.045)
+			})
+			Convey("should return why there was no reply when the ping command fails", func() {
+				defer fakePing("sh", "-c", "cat testdata/output/iputils_unreachable.txt; exit 1")()
+				_, err := PingContext(context.Background(), "10.0.0.99", Options{})
+				pe, ok := err.(*PingError)
+				So(ok, ShouldBeTrue)
+				So(pe.Timeout(), ShouldBeTrue)
+				So(pe.Outcome, ShouldEqual, OutcomeHostUnreachable)
+				So(pe.Error(), ShouldEqual, "From 10.0.0.5 icmp_seq=1 Destination Host Unreachable")
+			})
+			Convey("should keep the host as the IP of replies & errors, w/ the address it resolved to", func() {
+				defer fakePing("cat", "testdata/output/iputils_reply.txt")()
+				pr, err := PingContext(context.Background(), "localhost", Options{Family: IPv4})
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, "localhost")
+				So(pr.Addr, ShouldEqual, "127.0.0.1")
+
+				defer fakePing("sh", "-c", "cat testdata/output/iputils_timeout.txt; exit 1")()
+				_, err = PingContext(context.Background(), "localhost", Options{Family: IPv4})
+				pe, ok := err.(*PingError)
+				So(ok, ShouldBeTrue)
+				So(pe.IP(), ShouldEqual, "localhost")
+				So(pe.Addr(), ShouldEqual, "127.0.0.1")
+			})
+			Convey("should kill the ping command when ctx is cancelled", func() {
+				defer fakePing("sleep", "10")()
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+
+				start := time.Now()
+				pr, err := PingContext(ctx, "10.0.0.1", Options{})
+				So(pr, ShouldBeNil)
+				So(err, ShouldEqual, context.Canceled)
+				So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			})
+			Convey("should kill a hung ping command a second after its timeout", func() {
+				defer fakePing("sleep", "10")()
+
+				start := time.Now()
+				_, err := PingContext(context.Background(), "10.0.0.1", Options{Timeout: 100 * time.Millisecond})
+				pe, ok := err.(*PingError)
+				So(ok, ShouldBeTrue)
+				So(pe.Timeout(), ShouldBeTrue)
+				So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			})
+		})
+
 		Convey("ParsePingOutput()", func() {
 
 			Convey("Should return PingResponse given a valid reply", func() {
@@ -95,6 +159,14 @@ rtt min/avg/max/mdev = 1.674/1.674/1.674/0.000 ms`),
 	})
 }
 
+// fakePing replaces the ping command w/ name & args until the returned func is called
+func fakePing(name string, args ...string) (restore func()) {
+	execCommand = func(ctx context.Context, _ string, _ ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	return func() { execCommand = exec.CommandContext }
+}
+
 func Test_ping_integration(t *testing.T) {
 	Convey("ping", t, func() {
 		Convey("Ping()", func() {
@@ -0,0 +1,502 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultTTL is the TTL linux, macOS & the BSDs send with, a Pinger resets its
+// socket to it after sending a ping w/ a TTL
+const defaultTTL = 64
+
+// doneSeqTTL is how long a Pinger remembers the echoes it got a reply to, replies
+// to them within this time are duplicates, later ones are ignored
+const doneSeqTTL = 1 * time.Minute
+
+// PingerClosedError is returned by the pings of a closed Pinger
+const PingerClosedError = "ping: pinger is closed"
+
+// PhaseUser is the phase NativeProber records the userland RTT as when it
+// compares it to the RTT measured w/ kernel timestamps
+const PhaseUser = "user"
+
+// sentStampSize is the # of bytes at the start of an echo payload that carry
+// the time it was sent, smaller payloads don't carry it
+const sentStampSize = 8
+
+// icmpEchoHeaderLen is the # of bytes of an echo message before its payload
+const icmpEchoHeaderLen = 8
+
+// ICMPError is returned when a router or the host answers an echo request w/ a
+// destination unreachable or time exceeded message. Only raw sockets get them
+type ICMPError struct {
+	ip      string
+	addr    string  // the address the ping was sent to
+	From    string  // the address that sent the error
+	Outcome Outcome // OutcomeHostUnreachable, OutcomeNetUnreachable or OutcomeTTLExceeded
+	Code    int     // the ICMP code
+}
+
+// IP returns the series the failed ping should be saved to
+func (ie ICMPError) IP() string {
+	return ie.ip
+}
+
+// Addr returns the address the failed ping was sent to
+func (ie ICMPError) Addr() string {
+	return ie.addr
+}
+
+func (ie ICMPError) Error() string {
+	msg := "destination host unreachable"
+	switch ie.Outcome {
+	case OutcomeNetUnreachable:
+		msg = "destination net unreachable"
+	case OutcomeTTLExceeded:
+		msg = "time to live exceeded"
+	}
+	return fmt.Sprintf("ping: %s from %s, code %d", msg, ie.From, ie.Code)
+}
+
+func (ie ICMPError) Timeout() bool {
+	return false
+}
+
+// icmpOutcome returns the outcome of an ICMP error message
+func icmpOutcome(v6 bool, msgType, code int) Outcome {
+	switch {
+	case !v6 && msgType == icmpv4TimeExceeded, v6 && msgType == icmpv6TimeExceeded:
+		return OutcomeTTLExceeded
+	case v6 && code == 0: // no route to destination
+		return OutcomeNetUnreachable
+	case !v6 && (code == 0 || code == 6 || code == 9 || code == 11): // net unreachable, unknown, prohibited & unreachable for TOS
+		return OutcomeNetUnreachable
+	}
+	return OutcomeHostUnreachable
+}
+
+// EchoReply is the reply to one echo request sent by a Pinger
+type EchoReply struct {
+	IP         string  // the address that replied
+	Seq        int     // the sequence # of the echo request
+	Time       float64 // ms, from kernel timestamps if Kernel is set
+	UserTime   float64 // ms, from the time pinghist read the reply
+	Kernel     bool    // Time was measured w/ the kernel's receive timestamp
+	TTL        int     // of the reply, 0 if the socket doesn't say
+	Bytes      int     // the size of the reply's ICMP message
+	OutOfOrder bool    // a reply to a later echo request to the same address arrived first
+	Duplicates int     // # of duplicate replies from the address since its previous reply
+}
+
+// Pinger sends echo requests to any # of hosts over one long-lived socket per
+// address family, so pinging many hosts, or pinging at sub-second intervals,
+// doesn't open a socket per ping. Every echo request gets the next sequence #
+// and several can be in flight at once, replies are matched to them by
+// identifier & sequence #. A Pinger is safe for concurrent use.
+//
+// Where the OS supports it (linux) the RTT is measured from the kernel's
+// receive timestamp of the reply & the send time carried in the echo
+// payload, so it isn't inflated by GC & scheduler pauses before the reply is
+// read.
+type Pinger struct {
+	mu       sync.Mutex
+	conns    map[bool]*pingerConn // by v6
+	id       int                  // identifier of echoes sent over raw sockets
+	seq      int                  // sequence # of the last echo request
+	inflight map[int]*echoWait    // by sequence #
+	done     map[int]*echoDone    // echoes that got a reply, by sequence #
+	hosts    map[string]*hostSeq  // by address
+	closed   bool
+}
+
+// pingerConn is the socket of one address family
+type pingerConn struct {
+	c   net.PacketConn
+	raw bool
+	v6  bool
+	wmu sync.Mutex // serializes setting the ttl & writing
+	ttl int        // the ttl the socket sends with, 0 for the OS default
+
+	timestamps bool // the kernel timestamps the replies we read
+	ttls       bool // the kernel passes the ttl of the replies we read
+}
+
+// echoWait is an echo request waiting for its reply
+type echoWait struct {
+	ip     string
+	sent   time.Time
+	reply  chan *EchoReply
+	failed chan *ICMPError
+}
+
+// echoDone is an echo request that got a reply
+type echoDone struct {
+	ip string
+	at time.Time
+}
+
+// hostSeq is what a Pinger knows about the replies from an address
+type hostSeq struct {
+	replied    bool
+	lastSeq    int // sequence # of the last reply
+	duplicates int // since the last reply
+}
+
+// NewPinger returns a Pinger, sockets are opened when the first host of their
+// address family is pinged
+func NewPinger() *Pinger {
+	return &Pinger{
+		conns:    map[bool]*pingerConn{},
+		id:       os.Getpid() & 0xffff,
+		inflight: map[int]*echoWait{},
+		done:     map[int]*echoDone{},
+		hosts:    map[string]*hostSeq{},
+	}
+}
+
+// Close closes the sockets of the Pinger, pings in flight return an error
+func (p *Pinger) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, pc := range p.conns {
+		pc.c.Close()
+	}
+	for seq, w := range p.inflight {
+		close(w.reply)
+		delete(p.inflight, seq)
+	}
+	return nil
+}
+
+// conn returns the socket of the address family, opening it if needed. It
+// prefers an unprivileged datagram socket and falls back to a raw socket
+func (p *Pinger) conn(v6 bool) (*pingerConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New(PingerClosedError)
+	}
+	if pc, ok := p.conns[v6]; ok {
+		return pc, nil
+	}
+
+	pc := &pingerConn{v6: v6}
+	c, err := listenICMPDgram(v6)
+	if err != nil {
+		network, laddr := "ip4:icmp", "0.0.0.0"
+		if v6 {
+			network, laddr = "ip6:ipv6-icmp", "::"
+		}
+		if c, err = net.ListenPacket(network, laddr); err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				return nil, PermissionError{Err: err}
+			}
+			return nil, err
+		}
+		pc.raw = true
+	}
+	pc.c = c
+	if sc, ok := c.(syscall.Conn); ok {
+		pc.timestamps = enableRxTimestamps(sc) == nil
+		pc.ttls = enableRxTTL(sc, v6) == nil
+	}
+	p.conns[v6] = pc
+	go p.read(pc)
+	return pc, nil
+}
+
+// Ping sends an echo request to host and waits for the reply. Replies that
+// don't arrive within opts.Timeout are returned as a PingError timeout, ctx's
+// error is returned if it's done first.
+func (p *Pinger) Ping(ctx context.Context, host string, opts Options) (*EchoReply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ipAddr, err := ResolveIP(host, opts.Family)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := p.conn(isIPv6(ipAddr))
+	if err != nil {
+		return nil, err
+	}
+
+	size := opts.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+	w := &echoWait{ip: ipAddr.IP.String(), reply: make(chan *EchoReply, 1), failed: make(chan *ICMPError, 1)}
+
+	p.mu.Lock()
+	p.seq = (p.seq + 1) & 0xffff
+	seq := p.seq
+	delete(p.done, seq) // the sequence # wrapped, it's not a duplicate anymore
+	p.inflight[seq] = w
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if p.inflight[seq] == w {
+			delete(p.inflight, seq)
+		}
+		p.mu.Unlock()
+	}()
+
+	echoRequest := icmpv4EchoRequest
+	if pc.v6 {
+		echoRequest = icmpv6EchoRequest
+	}
+	data := make([]byte, size)
+	pc.wmu.Lock()
+	err = pc.setTTL(opts.TTL)
+	if err == nil {
+		var b []byte
+		p.mu.Lock()
+		w.sent = time.Now()
+		p.mu.Unlock()
+		if size >= sentStampSize {
+			binary.BigEndian.PutUint64(data, uint64(w.sent.UnixNano()))
+		}
+		if b, err = ICMPMsg(echoRequest, 0, p.id, seq, data); err == nil {
+			_, err = pc.c.WriteTo(b, pc.addr(ipAddr))
+		}
+	}
+	pc.wmu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(opts.timeout(TimeOut))
+	defer timer.Stop()
+	select {
+	case r, ok := <-w.reply:
+		if !ok {
+			return nil, errors.New(PingerClosedError)
+		}
+		return r, nil
+	case ie := <-w.failed:
+		ie.ip, ie.addr = host, w.ip
+		return nil, ie
+	case <-timer.C:
+		return nil, &PingError{ip: host, addr: w.ip, IsTimeout: true, msg: "ping: timed out waiting for echo reply"}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// setTTL sets the ttl the socket sends with (0 for the default) if it isn't
+// already, pc.wmu must be held
+func (pc *pingerConn) setTTL(ttl int) error {
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if ttl == pc.ttl || (ttl == defaultTTL && pc.ttl == 0) {
+		return nil
+	}
+	sc, ok := pc.c.(syscall.Conn)
+	if !ok {
+		return errors.New("ping: can't set the ttl of the icmp socket")
+	}
+	if err := setTTL(sc, ttl, pc.v6); err != nil {
+		return err
+	}
+	pc.ttl = ttl
+	return nil
+}
+
+// addr returns the address to send to ipAddr w/, datagram sockets take a *net.UDPAddr
+func (pc *pingerConn) addr(ipAddr *net.IPAddr) net.Addr {
+	if pc.raw {
+		return ipAddr
+	}
+	return &net.UDPAddr{IP: ipAddr.IP, Zone: ipAddr.Zone}
+}
+
+// read reads replies from pc until it's closed, and hands them to the echo
+// requests waiting for them
+func (p *Pinger) read(pc *pingerConn) {
+	rb := make([]byte, 65536)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, peer, err := pc.readMsg(rb, oob)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return // closed
+		}
+		received := time.Now()
+		var kernel time.Time
+		if pc.timestamps {
+			kernel, _ = parseRxTimestamp(oob[:oobn])
+		}
+		ttl := 0
+		if pc.ttls {
+			ttl, _ = parseRxTTL(oob[:oobn])
+		}
+
+		p.handle(pc, rb[:n], addrIP(peer), ttl, received, kernel)
+	}
+}
+
+// handle hands the ICMP message b from peer to the echo request it answers,
+// ttl is the TTL of the packet when the socket passed it, 0 otherwise
+func (p *Pinger) handle(pc *pingerConn, b []byte, peer string, ttl int, received, kernel time.Time) {
+	echoReply, destUnreachable, timeExceeded := icmpv4EchoReply, icmpv4DestUnreachable, icmpv4TimeExceeded
+	if pc.v6 {
+		echoReply, destUnreachable, timeExceeded = icmpv6EchoReply, icmpv6DestUnreachable, icmpv6TimeExceeded
+	}
+
+	// raw IPv4 sockets read the IP header too
+	if ttl == 0 && !pc.v6 && len(b) >= 20 && b[0]>>4 == 4 {
+		ttl = int(b[8])
+	}
+
+	// raw sockets see all ICMP traffic, including our own requests
+	b, err := ipv4Payload(b)
+	if err != nil {
+		return
+	}
+	m, err := parseICMPMessage(b)
+	if err != nil {
+		return
+	}
+	switch m.Type {
+	case echoReply:
+		echo, ok := m.Body.(*icmpEcho)
+		// the kernel picks the identifier of datagram sockets and only hands us
+		// replies that match it, a raw socket sees replies to other processes
+		if !ok || (pc.raw && echo.ID != p.id) {
+			return
+		}
+		p.reply(peer, echo.Seq, ttl, received, kernel, echo.Data)
+	case destUnreachable, timeExceeded:
+		ie, ok := m.Body.(*icmpError)
+		if !ok {
+			return
+		}
+		echo, err := ie.Echo()
+		if err != nil || echo == nil || echo.ID != p.id {
+			return
+		}
+		p.fail(echo.Seq, &ICMPError{From: peer, Outcome: icmpOutcome(pc.v6, m.Type, m.Code), Code: m.Code})
+	}
+}
+
+// fail hands an ICMP error to echo request seq, if it's still waiting
+func (p *Pinger) fail(seq int, ie *ICMPError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, ok := p.inflight[seq]
+	if !ok {
+		return
+	}
+	delete(p.inflight, seq)
+	w.failed <- ie
+}
+
+// readMsg reads a packet & its control messages from pc
+func (pc *pingerConn) readMsg(b, oob []byte) (n, oobn int, peer net.Addr, err error) {
+	switch c := pc.c.(type) {
+	case *net.UDPConn:
+		var addr *net.UDPAddr
+		n, oobn, _, addr, err = c.ReadMsgUDP(b, oob)
+		return n, oobn, addr, err
+	case *net.IPConn:
+		var addr *net.IPAddr
+		n, oobn, _, addr, err = c.ReadMsgIP(b, oob)
+		return n, oobn, addr, err
+	}
+	n, peer, err = pc.c.ReadFrom(b)
+	return n, 0, peer, err
+}
+
+// reply hands the reply from ip to echo request seq to the request waiting for
+// it, or counts it as a duplicate if the request already got a reply. kernel is
+// when the kernel received the reply & ttl its TTL, zero if unknown, and data
+// its payload.
+func (p *Pinger) reply(ip string, seq, ttl int, received, kernel time.Time, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w, ok := p.inflight[seq]
+	if !ok || w.ip != ip {
+		if d, ok := p.done[seq]; ok && d.ip == ip && received.Sub(d.at) < doneSeqTTL {
+			p.host(ip).duplicates++
+		}
+		return
+	}
+	delete(p.inflight, seq)
+
+	hs := p.host(ip)
+	r := &EchoReply{
+		IP:         ip,
+		Seq:        seq,
+		TTL:        ttl,
+		Bytes:      icmpEchoHeaderLen + len(data),
+		UserTime:   float64(received.Sub(w.sent)) / float64(time.Millisecond),
+		OutOfOrder: hs.replied && int16(seq-hs.lastSeq) < 0,
+		Duplicates: hs.duplicates,
+	}
+	r.Time = r.UserTime
+	if rtt, ok := kernelRTT(kernel, data); ok {
+		r.Time, r.Kernel = rtt, true
+	}
+	if !r.OutOfOrder {
+		hs.replied, hs.lastSeq = true, seq
+	}
+	hs.duplicates = 0
+	w.reply <- r
+
+	p.done[seq] = &echoDone{ip: ip, at: received}
+	if len(p.done) > 1024 {
+		for s, d := range p.done {
+			if received.Sub(d.at) >= doneSeqTTL {
+				delete(p.done, s)
+			}
+		}
+	}
+}
+
+// kernelRTT returns the ms between the send time carried in the payload of a
+// reply & the time the kernel received it
+func kernelRTT(kernel time.Time, data []byte) (float64, bool) {
+	if kernel.IsZero() || len(data) < sentStampSize {
+		return 0, false
+	}
+	sent := int64(binary.BigEndian.Uint64(data))
+	rtt := kernel.UnixNano() - sent
+	// a payload that isn't ours, or the wall clock stepped
+	if rtt < 0 || rtt > int64(doneSeqTTL) {
+		return 0, false
+	}
+	return float64(rtt) / float64(time.Millisecond), true
+}
+
+// host returns what's known about the replies from ip, p.mu must be held
+func (p *Pinger) host(ip string) *hostSeq {
+	hs, ok := p.hosts[ip]
+	if !ok {
+		hs = &hostSeq{}
+		p.hosts[ip] = hs
+	}
+	return hs
+}
+
+// addrIP returns the IP of a *net.IPAddr or *net.UDPAddr w/o the zone
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return ""
+}
@@ -0,0 +1,228 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitFor registers an echo request to ip w/ seq as in flight, like Pinger.Ping does
+func waitFor(p *Pinger, ip string, seq int) *echoWait {
+	w := &echoWait{ip: ip, sent: time.Now(), reply: make(chan *EchoReply, 1), failed: make(chan *ICMPError, 1)}
+	p.inflight[seq] = w
+	return w
+}
+
+func Test_pinger_unit(t *testing.T) {
+	Convey("pinger", t, func() {
+		p := NewPinger()
+
+		Convey("reply()", func() {
+			Convey("should hand the reply to the echo request waiting for it", func() {
+				w1, w2 := waitFor(p, "10.0.0.1", 1), waitFor(p, "10.0.0.2", 2)
+				p.reply("10.0.0.2", 2, 0, time.Now(), time.Time{}, nil)
+				p.reply("10.0.0.1", 1, 0, time.Now(), time.Time{}, nil)
+
+				r := <-w1.reply
+				So(r.IP, ShouldEqual, "10.0.0.1")
+				So(r.Seq, ShouldEqual, 1)
+				So(r.Time, ShouldBeGreaterThanOrEqualTo, 0)
+				So((<-w2.reply).Seq, ShouldEqual, 2)
+				So(p.inflight, ShouldBeEmpty)
+			})
+			Convey("should ignore replies from another address", func() {
+				w := waitFor(p, "10.0.0.1", 1)
+				p.reply("10.0.0.9", 1, 0, time.Now(), time.Time{}, nil)
+				So(len(w.reply), ShouldEqual, 0)
+				So(p.inflight, ShouldContainKey, 1)
+			})
+			Convey("should count duplicates & report them w/ the next reply", func() {
+				waitFor(p, "10.0.0.1", 1)
+				p.reply("10.0.0.1", 1, 0, time.Now(), time.Time{}, nil)
+				p.reply("10.0.0.1", 1, 0, time.Now(), time.Time{}, nil)
+				p.reply("10.0.0.1", 1, 0, time.Now(), time.Time{}, nil)
+
+				w := waitFor(p, "10.0.0.1", 2)
+				p.reply("10.0.0.1", 2, 0, time.Now(), time.Time{}, nil)
+				So((<-w.reply).Duplicates, ShouldEqual, 2)
+
+				w = waitFor(p, "10.0.0.1", 3)
+				p.reply("10.0.0.1", 3, 0, time.Now(), time.Time{}, nil)
+				So((<-w.reply).Duplicates, ShouldEqual, 0)
+			})
+			Convey("should flag replies that arrive after the reply to a later request", func() {
+				w5, w6 := waitFor(p, "10.0.0.1", 5), waitFor(p, "10.0.0.1", 6)
+				p.reply("10.0.0.1", 6, 0, time.Now(), time.Time{}, nil)
+				p.reply("10.0.0.1", 5, 0, time.Now(), time.Time{}, nil)
+				So((<-w6.reply).OutOfOrder, ShouldBeFalse)
+				So((<-w5.reply).OutOfOrder, ShouldBeTrue)
+			})
+			Convey("should time the reply w/ the kernel timestamp & the send time in the payload", func() {
+				w := waitFor(p, "10.0.0.1", 1)
+				data := make([]byte, DefaultSize)
+				sent := time.Now()
+				binary.BigEndian.PutUint64(data, uint64(sent.UnixNano()))
+				p.reply("10.0.0.1", 1, 0, sent.Add(time.Second), sent.Add(5*time.Millisecond), data)
+
+				r := <-w.reply
+				So(r.Kernel, ShouldBeTrue)
+				So(r.Time, ShouldAlmostEqual, 5, 0.001)
+				So(r.UserTime, ShouldBeGreaterThan, 900)
+			})
+			Convey("should fall back to the userland time w/o a kernel timestamp or send time", func() {
+				w1, w2 := waitFor(p, "10.0.0.1", 1), waitFor(p, "10.0.0.1", 2)
+				p.reply("10.0.0.1", 1, 0, time.Now(), time.Time{}, make([]byte, DefaultSize))
+				p.reply("10.0.0.1", 2, 0, time.Now(), time.Now(), make([]byte, 4))
+
+				for _, w := range []*echoWait{w1, w2} {
+					r := <-w.reply
+					So(r.Kernel, ShouldBeFalse)
+					So(r.Time, ShouldEqual, r.UserTime)
+				}
+			})
+			Convey("should read the TTL of a reply from the IPv4 header of raw sockets", func() {
+				w := waitFor(p, "10.0.0.1", 3)
+				hdr := make([]byte, 20)
+				hdr[0], hdr[8], hdr[9] = 0x45, 57, 1 // IPv4, ttl 57, ICMP
+				echo, err := ICMPMsg(icmpv4EchoReply, 0, p.id, 3, make([]byte, 56))
+				So(err, ShouldBeNil)
+
+				p.handle(&pingerConn{raw: true}, append(hdr, echo...), "10.0.0.1", 0, time.Now(), time.Time{})
+				r := <-w.reply
+				So(r.TTL, ShouldEqual, 57)
+				So(r.Bytes, ShouldEqual, 64)
+			})
+			Convey("should fail the echo request an ICMP error quotes", func() {
+				w := waitFor(p, "10.0.0.9", 7)
+				quoted := make([]byte, 28)
+				quoted[0], quoted[9] = 0x45, 1 // IPv4, ICMP
+				copy(quoted[20:], []byte{icmpv4EchoRequest, 0, 0, 0, byte(p.id >> 8), byte(p.id), 0, 7})
+				msg := append([]byte{icmpv4DestUnreachable, 1, 0, 0, 0, 0, 0, 0}, quoted...)
+
+				p.handle(&pingerConn{raw: true}, msg, "10.0.0.1", 0, time.Now(), time.Time{})
+				ie := <-w.failed
+				So(ie.From, ShouldEqual, "10.0.0.1")
+				So(ie.Outcome, ShouldEqual, OutcomeHostUnreachable)
+				So(ie.Code, ShouldEqual, 1)
+				So(p.inflight, ShouldBeEmpty)
+			})
+			Convey("should drop echo replies too short to parse", func() {
+				waitFor(p, "10.0.0.1", 1)
+				for n := 0; n < 8; n++ {
+					p.handle(&pingerConn{}, []byte{icmpv4EchoReply, 0, 0, 0, 0, 1, 0, 1}[:n], "10.0.0.1", 0, time.Now(), time.Time{})
+				}
+				So(p.inflight, ShouldContainKey, 1)
+			})
+			Convey("should drop ICMP errors w/ a bogus IPv4 header length", func() {
+				waitFor(p, "10.0.0.9", 7)
+				quoted := make([]byte, 28)
+				quoted[0], quoted[9] = 0x4f, 1 // IPv4 w/ a 60 byte header, ICMP
+				copy(quoted[20:], []byte{icmpv4EchoRequest, 0, 0, 0, byte(p.id >> 8), byte(p.id), 0, 7})
+				msg := append([]byte{icmpv4DestUnreachable, 1, 0, 0, 0, 0, 0, 0}, quoted...)
+				p.handle(&pingerConn{raw: true}, msg, "10.0.0.1", 0, time.Now(), time.Time{})
+
+				hdr := make([]byte, 20)
+				hdr[0] = 0x4f // the packet is shorter than its header
+				p.handle(&pingerConn{raw: true}, append(hdr, msg...)[:40], "10.0.0.1", 0, time.Now(), time.Time{})
+				So(p.inflight, ShouldContainKey, 7)
+			})
+			Convey("should handle the sequence # wrapping", func() {
+				w1, w2 := waitFor(p, "10.0.0.1", 0xffff), waitFor(p, "10.0.0.1", 0)
+				p.reply("10.0.0.1", 0xffff, 0, time.Now(), time.Time{}, nil)
+				p.reply("10.0.0.1", 0, 0, time.Now(), time.Time{}, nil)
+				So((<-w1.reply).OutOfOrder, ShouldBeFalse)
+				So((<-w2.reply).OutOfOrder, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func Test_pinger_integration(t *testing.T) {
+	Convey("pinger", t, func() {
+		p := NewPinger()
+		Reset(func() { p.Close() })
+
+		if !NativeSupported() {
+			Convey("should return PermissionError without icmp socket permissions", func() {
+				_, err := p.Ping(context.Background(), "127.0.0.1", Options{})
+				_, ok := err.(PermissionError)
+				So(ok, ShouldBeTrue)
+			})
+			return
+		}
+
+		Convey("Ping()", func() {
+			Convey("should ping 127.0.0.1 w/ increasing sequence #s", func() {
+				r1, err := p.Ping(context.Background(), "127.0.0.1", Options{})
+				So(err, ShouldBeNil)
+				r2, err := p.Ping(context.Background(), "127.0.0.1", Options{TTL: 2, Size: 1000})
+				So(err, ShouldBeNil)
+				So(r1.IP, ShouldEqual, "127.0.0.1")
+				So(r2.Seq, ShouldEqual, r1.Seq+1)
+				So(r2.Time, ShouldBeGreaterThan, 0)
+			})
+			if runtime.GOOS == "linux" {
+				Convey("should time replies w/ kernel timestamps", func() {
+					r, err := p.Ping(context.Background(), "127.0.0.1", Options{})
+					So(err, ShouldBeNil)
+					So(r.Kernel, ShouldBeTrue)
+					So(r.Time, ShouldBeGreaterThan, 0)
+					So(r.Time, ShouldBeLessThanOrEqualTo, r.UserTime)
+				})
+				Convey("should return the ttl & size of replies", func() {
+					r, err := p.Ping(context.Background(), "127.0.0.1", Options{Size: 1000})
+					So(err, ShouldBeNil)
+					So(r.TTL, ShouldEqual, defaultTTL)
+					So(r.Bytes, ShouldEqual, 1008)
+				})
+			}
+			Convey("should ping ::1 w/ ICMPv6", func() {
+				r, err := p.Ping(context.Background(), "::1", Options{Family: IPv6})
+				So(err, ShouldBeNil)
+				So(r.IP, ShouldEqual, "::1")
+			})
+			Convey("should keep many echoes in flight over one socket", func() {
+				var wg sync.WaitGroup
+				replies := make(chan *EchoReply, 50)
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						r, err := p.Ping(context.Background(), "127.0.0.1", Options{})
+						if err == nil {
+							replies <- r
+						}
+					}()
+				}
+				wg.Wait()
+				close(replies)
+
+				seqs := map[int]bool{}
+				for r := range replies {
+					seqs[r.Seq] = true
+				}
+				So(len(seqs), ShouldEqual, 50)
+				So(len(p.conns), ShouldEqual, 1)
+			})
+			Convey("should return promptly when ctx is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+
+				start := time.Now()
+				_, err := p.Ping(ctx, "198.51.100.1", Options{Timeout: 10 * time.Second})
+				So(err, ShouldNotBeNil)
+				So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+			})
+			Convey("should return error once closed", func() {
+				p.Close()
+				_, err := p.Ping(context.Background(), "127.0.0.1", Options{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
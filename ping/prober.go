@@ -0,0 +1,166 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Status is the outcome of a probe
+type Status int
+
+const (
+	StatusOK   Status = iota // a reply arrived in time
+	StatusLost               // no reply, or a reply that counts as lost, ex: connection refused
+)
+
+func (s Status) String() string {
+	if s == StatusOK {
+		return "ok"
+	}
+	return "lost"
+}
+
+// Result is the outcome of probing a target once
+type Result struct {
+	Series      string            // the series the result is saved to, ex: tcp:google.com:443
+	Addr        string            // the address the target resolved to, see PingResponse.Addr
+	Start       time.Time         // when the probe started
+	Time        float64           // ms, -1 when lost
+	TTL         int               // of the reply, 0 when unknown
+	Bytes       int               // the size of the reply, 0 when unknown
+	Status      Status            // StatusOK or StatusLost
+	Err         error             // why the probe was lost
+	Outcome     Outcome           // why the probe was lost, see ErrorOutcome
+	ICMPCode    int               // the code of the ICMP error the probe was lost to, if any
+	Phases      []Phase           // timings saved to their own series, see PhaseSeries
+	Meta        map[string]string // shown after the time, ex: the rcode of a DNS query
+	History     string            // saved to the history of Series when it changes, ex: the path of a trace
+	HistoryName string            // what History is, ex: path
+	Children    []*Result         // results saved to their own series, ex: the hops of a trace
+}
+
+// HistoryAddress is the HistoryName of results w/ an Addr, the history of their
+// series is the addresses the target resolved to over time
+const HistoryAddress = "address"
+
+// Prober probes a target, ex: sends an ICMP echo request or opens a TCP connection
+type Prober interface {
+	// Name is shown at the start of every line of output, usually the series
+	Name() string
+	// Probe probes the target once. Lost probes (timeouts, refused connections,
+	// ...) are a Result w/ StatusLost, an error means the probe failed before
+	// there was a series to save it to, ex: the host didn't resolve. The caller
+	// records those as lost too, see LostResult
+	Probe(ctx context.Context) (*Result, error)
+}
+
+// LostResult returns the result of a probe of series started at start that
+// failed w/ err
+func LostResult(series string, start time.Time, err error) *Result {
+	r := &Result{Series: series, Start: start, Time: -1, Status: StatusLost, Err: err, Outcome: ErrorOutcome(err)}
+	var ie *ICMPError
+	if errors.As(err, &ie) {
+		r.ICMPCode = ie.Code
+	}
+	var ae addrError
+	if errors.As(err, &ae) {
+		r.setAddr(ae.Addr())
+	}
+	return r
+}
+
+// setAddr records that the target of r resolved to addr, in its history too
+func (r *Result) setAddr(addr string) {
+	if addr == "" {
+		return
+	}
+	r.Addr = addr
+	r.History, r.HistoryName = addr, HistoryAddress
+}
+
+// newResult returns the result of a probe that returned pr & err, TimeoutErrors
+// are lost probes, any other error is returned as is
+func newResult(start time.Time, pr *PingResponse, err error) (*Result, error) {
+	if err != nil {
+		if te, ok := err.(TimeoutError); ok {
+			return LostResult(te.IP(), start, err), nil
+		}
+		return nil, err
+	}
+	r := &Result{Series: pr.IP, Start: start, Time: pr.Time, TTL: pr.TTL, Bytes: pr.Bytes, Phases: pr.Phases, Outcome: OutcomeReply}
+	r.setAddr(pr.Addr)
+	return r, nil
+}
+
+// ErrorOutcome returns why a probe that failed w/ err was lost
+func ErrorOutcome(err error) Outcome {
+	var (
+		pe      *PingError
+		ie      *ICMPError
+		te      *TCPError
+		he      *HTTPError
+		de      *DNSError
+		dnsErr  *net.DNSError
+		permErr PermissionError
+	)
+	switch {
+	case errors.As(err, &pe) && pe.Outcome > OutcomeReply:
+		return pe.Outcome // the ping command said why
+	case errors.As(err, &ie):
+		return ie.Outcome
+	case errors.As(err, &te) && te.Refused,
+		errors.As(err, &he) && he.StatusCode != 0 && he.err == nil,
+		errors.As(err, &de) && de.RCode != "",
+		errors.Is(err, syscall.ECONNREFUSED):
+		return OutcomeRejected
+	case errors.As(err, &dnsErr):
+		return OutcomeDNS
+	case errors.As(err, &permErr), errors.Is(err, os.ErrPermission):
+		return OutcomePermission
+	case errors.Is(err, syscall.ENETUNREACH):
+		return OutcomeNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return OutcomeHostUnreachable
+	}
+	if te, ok := err.(TimeoutError); ok && te.Timeout() {
+		return OutcomeTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return OutcomeTimeout
+	}
+	return OutcomeLocal
+}
+
+// contextOptions returns opts w/ a timeout that ends before ctx does, or ctx's
+// error if it's already done
+func contextOptions(ctx context.Context, opts Options) (Options, error) {
+	if err := ctx.Err(); err != nil {
+		return opts, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); opts.Timeout == 0 || left < opts.Timeout {
+			opts.Timeout = left
+		}
+	}
+	return opts, nil
+}
+
+// closeOnDone closes c when ctx is done, unblocking reads & writes. Call the
+// returned func once c isn't used anymore to stop waiting on ctx
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,178 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	_ Prober = &PingProber{}
+	_ Prober = &NativeProber{}
+	_ Prober = &TCPProber{}
+	_ Prober = &HTTPProber{}
+	_ Prober = &DNSProber{}
+	_ Prober = &TraceProber{}
+)
+
+func Test_prober_unit(t *testing.T) {
+	Convey("prober", t, func() {
+		start := time.Now()
+
+		Convey("newResult()", func() {
+			Convey("should return the time of a response", func() {
+				r, err := newResult(start, &PingResponse{IP: "10.0.0.1", Time: 1.5, TTL: 57, Bytes: 64}, nil)
+				So(err, ShouldBeNil)
+				So(r.Series, ShouldEqual, "10.0.0.1")
+				So(r.Status, ShouldEqual, StatusOK)
+				So(r.Time, ShouldEqual, 1.5)
+				So(r.TTL, ShouldEqual, 57)
+				So(r.Bytes, ShouldEqual, 64)
+			})
+			Convey("should return TimeoutErrors as lost", func() {
+				te := &TCPError{addr: "10.0.0.1:443", msg: "connection refused", Refused: true}
+				r, err := newResult(start, nil, te)
+				So(err, ShouldBeNil)
+				So(r.Series, ShouldEqual, "tcp:10.0.0.1:443")
+				So(r.Status, ShouldEqual, StatusLost)
+				So(r.Time, ShouldEqual, -1)
+				So(r.Err, ShouldEqual, te)
+				So(r.Outcome, ShouldEqual, OutcomeRejected)
+			})
+			Convey("should return any other error", func() {
+				r, err := newResult(start, nil, errors.New("no such host"))
+				So(r, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("ErrorOutcome()", func() {
+			tests := map[error]Outcome{
+				&PingError{IsTimeout: true}:                                                       OutcomeTimeout,
+				&PingError{IsTimeout: true, Outcome: OutcomeNetUnreachable}:                       OutcomeNetUnreachable,
+				&ICMPError{Outcome: OutcomeTTLExceeded, Code: 0}:                                  OutcomeTTLExceeded,
+				&TCPError{IsTimeout: true}:                                                        OutcomeTimeout,
+				&HTTPError{StatusCode: 500}:                                                       OutcomeRejected,
+				&DNSError{RCode: "SERVFAIL"}:                                                      OutcomeRejected,
+				fmt.Errorf("ping.Ping: %w", &net.DNSError{Err: "no such host"}):                   OutcomeDNS,
+				PermissionError{Err: syscall.EPERM}:                                               OutcomePermission,
+				&net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ENETUNREACH)}: OutcomeNetUnreachable,
+				&TCPError{err: syscall.EHOSTUNREACH}:                                              OutcomeHostUnreachable,
+				errors.New("too many open files"):                                                 OutcomeLocal,
+			}
+			for err, want := range tests {
+				So(ErrorOutcome(err), ShouldEqual, want)
+			}
+		})
+
+		Convey("LostResult()", func() {
+			r := LostResult("10.0.0.1", start, &ICMPError{ip: "10.0.0.1", Outcome: OutcomeHostUnreachable, Code: 3})
+			So(r.Status, ShouldEqual, StatusLost)
+			So(r.Outcome, ShouldEqual, OutcomeHostUnreachable)
+			So(r.ICMPCode, ShouldEqual, 3)
+		})
+		Convey("should record the address the target resolved to", func() {
+			r, err := newResult(start, &PingResponse{IP: "google.com", Addr: "10.0.0.5", Time: 1.5}, nil)
+			So(err, ShouldBeNil)
+			So(r.Series, ShouldEqual, "google.com")
+			So(r.Addr, ShouldEqual, "10.0.0.5")
+			So(r.History, ShouldEqual, "10.0.0.5")
+			So(r.HistoryName, ShouldEqual, HistoryAddress)
+
+			r = LostResult("google.com", start, &PingError{ip: "google.com", addr: "10.0.0.9", IsTimeout: true})
+			So(r.Addr, ShouldEqual, "10.0.0.9")
+			So(r.HistoryName, ShouldEqual, HistoryAddress)
+
+			r = LostResult("google.com", start, errors.New("no such host"))
+			So(r.Addr, ShouldEqual, "")
+			So(r.HistoryName, ShouldEqual, "")
+		})
+
+		Convey("contextOptions()", func() {
+			Convey("should return the error of a done context", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := contextOptions(ctx, Options{})
+				So(err, ShouldEqual, context.Canceled)
+			})
+			Convey("should end the timeout before the context", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+				defer cancel()
+				opts, err := contextOptions(ctx, Options{Timeout: 2 * time.Second})
+				So(err, ShouldBeNil)
+				So(opts.Timeout, ShouldBeLessThanOrEqualTo, 500*time.Millisecond)
+
+				opts, err = contextOptions(ctx, Options{Timeout: 100 * time.Millisecond})
+				So(err, ShouldBeNil)
+				So(opts.Timeout, ShouldEqual, 100*time.Millisecond)
+			})
+		})
+	})
+}
+
+func Test_prober_integration(t *testing.T) {
+	Convey("prober", t, func() {
+		Convey("TCPProber", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			addr := l.Addr().String()
+
+			p := &TCPProber{Addr: addr}
+			So(p.Name(), ShouldEqual, "tcp:"+addr)
+			r, err := p.Probe(context.Background())
+			So(err, ShouldBeNil)
+			So(r.Status, ShouldEqual, StatusOK)
+			So(r.Series, ShouldEqual, "tcp:"+addr)
+
+			l.Close()
+			r, err = p.Probe(context.Background())
+			So(err, ShouldBeNil)
+			So(r.Status, ShouldEqual, StatusLost)
+		})
+
+		Convey("NativeProber", func() {
+			if !NativeSupported() {
+				return
+			}
+			pinger := NewPinger()
+			defer pinger.Close()
+
+			p := &NativeProber{Host: "127.0.0.1", Pinger: pinger}
+			r, err := p.Probe(context.Background())
+			So(err, ShouldBeNil)
+			So(r.Status, ShouldEqual, StatusOK)
+			So(r.Series, ShouldEqual, "127.0.0.1")
+			So(r.Meta, ShouldBeNil)
+		})
+
+		Convey("DNSProber", func() {
+			udp := serveDNSUDP()
+			Reset(func() { udp.Close() })
+
+			Convey("should return the answers as metadata & history", func() {
+				p := &DNSProber{Query: &DNSQuery{Name: "example.com", Server: udp.LocalAddr().String()}}
+				r, err := p.Probe(context.Background())
+				So(err, ShouldBeNil)
+				So(r.Status, ShouldEqual, StatusOK)
+				So(r.Meta, ShouldResemble, map[string]string{"rcode": "NOERROR", "answers": "10.0.0.1,10.0.0.2"})
+				So(r.History, ShouldEqual, "NOERROR 10.0.0.1,10.0.0.2")
+				So(r.HistoryName, ShouldEqual, "answer")
+			})
+			Convey("should return SERVFAIL as lost", func() {
+				p := &DNSProber{Query: &DNSQuery{Name: "fail.example.com", Server: udp.LocalAddr().String()}}
+				r, err := p.Probe(context.Background())
+				So(err, ShouldBeNil)
+				So(r.Status, ShouldEqual, StatusLost)
+				So(r.Meta["rcode"], ShouldEqual, "SERVFAIL")
+				So(r.HistoryName, ShouldEqual, "")
+			})
+		})
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setTTL isn't supported on this platform, so neither is Trace
+func setTTL(c syscall.Conn, ttl int, v6 bool) error {
+	return errors.New("ping: setting the ttl is not supported on this platform")
+}
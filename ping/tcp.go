@@ -0,0 +1,117 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPSeriesPrefix is prepended to the address of TCP pings when they're saved,
+// so TCP & ICMP pings to the same host are stored separately
+const TCPSeriesPrefix = "tcp:"
+
+// TCPError is returned when a TCP connection can't be established
+type TCPError struct {
+	addr      string
+	msg       string
+	Refused   bool // the host answered, but nothing is listening on the port
+	IsTimeout bool
+	err       error // the dial error
+}
+
+// IP returns the series the failed ping should be saved to
+func (te TCPError) IP() string {
+	return TCPSeriesPrefix + te.addr
+}
+
+func (te TCPError) Error() string {
+	return te.msg
+}
+
+func (te TCPError) Timeout() bool {
+	return te.IsTimeout
+}
+
+func (te TCPError) Unwrap() error {
+	return te.err
+}
+
+// newTCPError classifies a dial error as refused, timed out, or neither (ex: host unreachable)
+func newTCPError(addr string, err error) *TCPError {
+	te := &TCPError{addr: addr, msg: err.Error(), err: err}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		te.Refused = true
+		te.msg = "connection refused"
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		te.IsTimeout = true
+		te.msg = "connection timed out"
+	}
+	return te
+}
+
+// PingTCP measures how long it takes to open a TCP connection to addr (host:port),
+// the connection is closed right away. DNS lookups aren't included in the time.
+// This is useful for hosts that block ICMP. The returned PingResponse's IP is
+// prefixed with TCPSeriesPrefix, ex: tcp:google.com:443
+func PingTCP(addr string, opts Options) (*PingResponse, error) {
+	return PingTCPContext(context.Background(), addr, opts)
+}
+
+// PingTCPContext is PingTCP that stops dialing when ctx is done, ctx's error is
+// returned when it's done before the connection is open
+func PingTCPContext(ctx context.Context, addr string, opts Options) (*PingResponse, error) {
+	network := "tcp"
+	switch opts.Family {
+	case IPv4:
+		network = "tcp4"
+	case IPv6:
+		network = "tcp6"
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("ping.PingTCP: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.timeout(TimeOut)}
+	start := time.Now()
+	c, err := dialer.DialContext(ctx, network, tcpAddr.String())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newTCPError(addr, err)
+	}
+	end := time.Now()
+	c.Close()
+
+	pr := &PingResponse{
+		IP:   TCPSeriesPrefix + addr,
+		Host: addr,
+		Time: float64(end.Sub(start)) / float64(time.Millisecond),
+	}
+	return pr, nil
+}
+
+// TCPProber is a Prober that opens a TCP connection to Addr, see PingTCP
+type TCPProber struct {
+	Addr string
+	Opts Options
+}
+
+func (p *TCPProber) Name() string {
+	return TCPSeriesPrefix + p.Addr
+}
+
+func (p *TCPProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	pr, err := PingTCPContext(ctx, p.Addr, opts)
+	return newResult(start, pr, err)
+}
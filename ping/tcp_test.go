@@ -0,0 +1,80 @@
+package ping
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func Test_tcp_unit(t *testing.T) {
+	Convey("tcp", t, func() {
+		Convey("newTCPError()", func() {
+			Convey("should classify connection refused", func() {
+				err := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+				te := newTCPError("10.0.0.1:443", err)
+				So(te.Refused, ShouldBeTrue)
+				So(te.Timeout(), ShouldBeFalse)
+				So(te.IP(), ShouldEqual, "tcp:10.0.0.1:443")
+			})
+			Convey("should classify timeouts", func() {
+				te := newTCPError("10.0.0.1:443", timeoutErr{})
+				So(te.Refused, ShouldBeFalse)
+				So(te.Timeout(), ShouldBeTrue)
+			})
+			Convey("should keep the message of everything else", func() {
+				te := newTCPError("10.0.0.1:443", errors.New("no route to host"))
+				So(te.Refused, ShouldBeFalse)
+				So(te.Timeout(), ShouldBeFalse)
+				So(te.Error(), ShouldEqual, "no route to host")
+			})
+			Convey("should implement TimeoutError", func() {
+				var err error = newTCPError("10.0.0.1:443", timeoutErr{})
+				_, ok := err.(TimeoutError)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func Test_tcp_integration(t *testing.T) {
+	Convey("tcp", t, func() {
+		Convey("PingTCP()", func() {
+			Convey("should measure the time to connect to a listening port", func() {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				So(err, ShouldBeNil)
+				defer l.Close()
+
+				pr, err := PingTCP(l.Addr().String(), Options{})
+				So(err, ShouldBeNil)
+				So(pr.IP, ShouldEqual, TCPSeriesPrefix+l.Addr().String())
+				So(pr.Time, ShouldBeGreaterThan, 0)
+			})
+			Convey("should return a refused TCPError when nothing is listening", func() {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				So(err, ShouldBeNil)
+				addr := l.Addr().String()
+				l.Close()
+
+				pr, err := PingTCP(addr, Options{})
+				So(pr, ShouldBeNil)
+				te, ok := err.(*TCPError)
+				So(ok, ShouldBeTrue)
+				So(te.Refused, ShouldBeTrue)
+			})
+			Convey("should return error with an invalid address", func() {
+				_, err := PingTCP("127.0.0.1", Options{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
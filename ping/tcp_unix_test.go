@@ -0,0 +1,75 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fullListener returns the address of a listener whose accept queue is full, so
+// dialing it hangs until the dial times out, & a func to close it. addr is ""
+// when the queue couldn't be filled.
+func fullListener() (addr string, cleanup func()) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return "", func() {}
+	}
+	closeFD := func() { syscall.Close(fd) }
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		return "", closeFD
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		return "", closeFD
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return "", closeFD
+	}
+	addr = fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	// nothing accepts, so dial until a connection doesn't fit in the queue
+	var conns []net.Conn
+	for i := 0; i < 16; i++ {
+		c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return addr, func() {
+				for _, c := range conns {
+					c.Close()
+				}
+				closeFD()
+			}
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	return "", closeFD
+}
+
+func Test_tcp_unix_integration(t *testing.T) {
+	Convey("TCPProber", t, func() {
+		Convey("Probe() should stop dialing when ctx is cancelled", func() {
+			addr, cleanup := fullListener()
+			defer cleanup()
+			if addr == "" {
+				return // the accept queue never filled up
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			_, err := (&TCPProber{Addr: addr, Opts: Options{Timeout: 10 * time.Second}}).Probe(ctx)
+			So(err, ShouldEqual, context.Canceled)
+			So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+		})
+	})
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// enableRxTimestamps is only supported on linux, everywhere else the time
+// replies are read is used
+func enableRxTimestamps(c syscall.Conn) error {
+	return errors.New("ping: kernel timestamps are not supported on this platform")
+}
+
+// parseRxTimestamp never finds a timestamp, see enableRxTimestamps
+func parseRxTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
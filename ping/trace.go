@@ -0,0 +1,241 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TraceSeriesPrefix is prepended to hosts when their path is saved, ex: trace:google.com
+const TraceSeriesPrefix = "trace:"
+
+// MaxHops is the TTL at which Trace gives up on reaching a host, Options.TTL overrides it
+var MaxHops = 30
+
+// HopTimeOut is how long Trace waits for each hop to answer, Options.Timeout overrides it
+var HopTimeOut = 1000 * time.Millisecond
+
+// traceSeq is the sequence # of the last echo request sent by Trace, shared
+// by every trace so replies to one can't be mistaken for replies to another
+var traceSeq uint32
+
+// TraceSeries returns the series the path to host is saved to, ex: trace:google.com
+func TraceSeries(host string) string {
+	return TraceSeriesPrefix + host
+}
+
+// HopSeries returns the series the pings to the nth hop to host are saved to,
+// hops start at 1, ex: trace:google.com#hop3
+func HopSeries(host string, hop int) string {
+	return PhaseSeries(TraceSeries(host), fmt.Sprintf("hop%d", hop))
+}
+
+// Hop is one router on the path to a host
+type Hop struct {
+	TTL  int
+	IP   string  // empty when the hop didn't answer
+	Time float64 // ms, -1 when the hop didn't answer
+}
+
+// TraceResponse is the path to a host
+type TraceResponse struct {
+	Host    string
+	IP      string // the address host resolved to
+	Hops    []Hop
+	Reached bool // true when the last hop is the host
+}
+
+// Path returns the address of every hop separated by spaces, * for hops
+// that didn't answer, ex: 192.168.1.1 * 72.14.215.85
+func (tr *TraceResponse) Path() string {
+	ips := make([]string, len(tr.Hops))
+	for i, h := range tr.Hops {
+		ips[i] = h.IP
+		if h.IP == "" {
+			ips[i] = "*"
+		}
+	}
+	return strings.Join(ips, " ")
+}
+
+// Trace finds the path to host by sending echo requests with increasing TTLs
+// and timing the ICMP time exceeded message each router along the way sends
+// back. It stops at the host, a destination unreachable message, or MaxHops.
+// Datagram sockets don't hand us the time exceeded messages, so it needs a
+// raw socket (root or CAP_NET_RAW).
+func Trace(host string, opts Options) (*TraceResponse, error) {
+	return TraceContext(context.Background(), host, opts)
+}
+
+// TraceContext is Trace that closes the socket when ctx is done, ctx's error
+// is returned when it's done before the trace is
+func TraceContext(ctx context.Context, host string, opts Options) (*TraceResponse, error) {
+	ipAddr, err := ResolveIP(host, opts.Family)
+	if err != nil {
+		return nil, err
+	}
+	v6 := isIPv6(ipAddr)
+
+	network, laddr := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, laddr = "ip6:ipv6-icmp", "::"
+	}
+	c, err := net.ListenPacket(network, laddr)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, PermissionError{Err: err}
+		}
+		return nil, err
+	}
+	defer c.Close()
+	defer closeOnDone(ctx, c)()
+
+	tr := &TraceResponse{Host: host, IP: ipAddr.String()}
+	xid := os.Getpid() & 0xffff
+	maxHops := MaxHops
+	if opts.TTL > 0 {
+		maxHops = opts.TTL
+	}
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		hop, last, err := traceHop(c.(*net.IPConn), ipAddr, ttl, xid, opts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		tr.Hops = append(tr.Hops, hop)
+		if last {
+			tr.Reached = hop.IP == ipAddr.String()
+			break
+		}
+	}
+	return tr, nil
+}
+
+// traceHop sends one echo request to ipAddr that expires after ttl hops and
+// waits for the answer, last is true when there's no point going further
+func traceHop(c *net.IPConn, ipAddr *net.IPAddr, ttl, xid int, opts Options) (hop Hop, last bool, err error) {
+	v6 := isIPv6(ipAddr)
+	echoRequest, echoReply, timeExceeded := icmpv4EchoRequest, icmpv4EchoReply, icmpv4TimeExceeded
+	if v6 {
+		echoRequest, echoReply, timeExceeded = icmpv6EchoRequest, icmpv6EchoReply, icmpv6TimeExceeded
+	}
+
+	if err := setTTL(c, ttl, v6); err != nil {
+		return hop, false, err
+	}
+	seq := int(atomic.AddUint32(&traceSeq, 1) & 0xffff)
+	size := opts.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+	b, err := ICMPMsg(echoRequest, 0, xid, seq, make([]byte, size))
+	if err != nil {
+		return hop, false, err
+	}
+
+	start := time.Now()
+	c.SetReadDeadline(start.Add(opts.timeout(HopTimeOut)))
+	if _, err := c.WriteTo(b, ipAddr); err != nil {
+		return hop, false, err
+	}
+
+	hop = Hop{TTL: ttl, Time: -1}
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(rb)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return hop, false, nil // nobody answered, try the next hop
+			}
+			return hop, false, err
+		}
+		end := time.Now()
+
+		// a raw socket sees all ICMP traffic, not just ours, & anybody can send
+		// a malformed packet, drop whatever doesn't answer this request
+		m, echo, err := parseHopReply(rb[:n], echoReply)
+		if err != nil || echo == nil || echo.ID != xid || echo.Seq != seq {
+			continue
+		}
+
+		hop.IP = peer.String()
+		hop.Time = float64(end.Sub(start)) / float64(time.Millisecond)
+		return hop, m.Type != timeExceeded, nil
+	}
+}
+
+// parseHopReply parses b, a packet read by traceHop, & returns the echo request
+// it answers, either w/ an echo reply or an ICMP error quoting it. echo is nil
+// when it answers something else, an error is returned when b is malformed
+func parseHopReply(b []byte, echoReply int) (m *icmpMessage, echo *icmpEcho, err error) {
+	if b, err = ipv4Payload(b); err != nil {
+		return nil, nil, err
+	}
+	if m, err = parseICMPMessage(b); err != nil {
+		return nil, nil, err
+	}
+	switch body := m.Body.(type) {
+	case *icmpEcho:
+		if m.Type == echoReply {
+			echo = body
+		}
+	case *icmpError:
+		if echo, err = body.Echo(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, echo, nil
+}
+
+// TraceProber is a Prober that traces the path to Host, see Trace. Every hop is
+// a child result saved to its own series and the path to the history of Host.
+type TraceProber struct {
+	Host string
+	Opts Options
+}
+
+func (p *TraceProber) Name() string {
+	return TraceSeries(p.Host)
+}
+
+func (p *TraceProber) Probe(ctx context.Context) (*Result, error) {
+	opts, err := contextOptions(ctx, p.Opts)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	tr, err := TraceContext(ctx, p.Host, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Result{
+		Series:      TraceSeries(p.Host),
+		Start:       start,
+		Time:        -1,
+		Status:      StatusLost,
+		Err:         fmt.Errorf("didn't reach %s", tr.IP),
+		Meta:        map[string]string{"hops": strconv.Itoa(len(tr.Hops))},
+		History:     tr.Path(),
+		HistoryName: "path",
+	}
+	for _, h := range tr.Hops {
+		hop := &Result{Series: HopSeries(p.Host, h.TTL), Start: start, Time: h.Time}
+		if h.IP == "" {
+			hop.Status = StatusLost
+		}
+		r.Children = append(r.Children, hop)
+	}
+	if tr.Reached {
+		r.Time, r.Status, r.Err = tr.Hops[len(tr.Hops)-1].Time, StatusOK, nil
+	}
+	return r, nil
+}
@@ -0,0 +1,160 @@
+package ping
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// timeExceeded returns an ICMP time exceeded message quoting an echo request w/ id & seq
+func timeExceeded(v6 bool, id, seq int) []byte {
+	msgType, echoRequest := icmpv4TimeExceeded, icmpv4EchoRequest
+	hdr := make([]byte, 20)
+	hdr[0], hdr[9] = 0x45, 1 // IPv4, 20 byte header, protocol ICMP
+	if v6 {
+		msgType, echoRequest = icmpv6TimeExceeded, icmpv6EchoRequest
+		hdr = make([]byte, 40)
+		hdr[0], hdr[6] = 0x60, 58 // IPv6, next header ICMPv6
+	}
+	echo, _ := ICMPMsg(echoRequest, 0, id, seq, nil)
+	b := append([]byte{byte(msgType), 0, 0, 0, 0, 0, 0, 0}, hdr...)
+	return append(b, echo...)
+}
+
+func Test_trace_unit(t *testing.T) {
+	Convey("trace", t, func() {
+		Convey("HopSeries()", func() {
+			So(HopSeries("google.com", 3), ShouldEqual, "trace:google.com#hop3")
+		})
+
+		Convey("Path()", func() {
+			Convey("should use * for hops that didn't answer", func() {
+				tr := &TraceResponse{Hops: []Hop{{TTL: 1, IP: "192.168.1.1", Time: 1}, {TTL: 2, Time: -1}, {TTL: 3, IP: "8.8.8.8", Time: 12}}}
+				So(tr.Path(), ShouldEqual, "192.168.1.1 * 8.8.8.8")
+			})
+		})
+
+		Convey("parseICMPMessage()", func() {
+			Convey("should find the echo request quoted by an IPv4 time exceeded", func() {
+				m, err := parseICMPMessage(timeExceeded(false, 0x1234, 7))
+				So(err, ShouldBeNil)
+				body, ok := m.Body.(*icmpError)
+				So(ok, ShouldBeTrue)
+				echo, err := body.Echo()
+				So(err, ShouldBeNil)
+				So(echo, ShouldNotBeNil)
+				So(echo.ID, ShouldEqual, 0x1234)
+				So(echo.Seq, ShouldEqual, 7)
+			})
+			Convey("should find the echo request quoted by an IPv6 time exceeded", func() {
+				m, err := parseICMPMessage(timeExceeded(true, 0x1234, 7))
+				So(err, ShouldBeNil)
+				echo, err := m.Body.(*icmpError).Echo()
+				So(err, ShouldBeNil)
+				So(echo, ShouldNotBeNil)
+				So(echo.Seq, ShouldEqual, 7)
+			})
+			Convey("should ignore errors quoting something other than an echo request", func() {
+				b := timeExceeded(false, 0x1234, 7)
+				b[8+9] = 17 // UDP
+				m, err := parseICMPMessage(b)
+				So(err, ShouldBeNil)
+				echo, err := m.Body.(*icmpError).Echo()
+				So(err, ShouldBeNil)
+				So(echo, ShouldBeNil)
+				m, err = parseICMPMessage(b[:20])
+				So(err, ShouldBeNil)
+				echo, err = m.Body.(*icmpError).Echo()
+				So(err, ShouldBeNil)
+				So(echo, ShouldBeNil)
+			})
+			Convey("should return error when the quoted IPv4 header is longer than the quote", func() {
+				b := timeExceeded(false, 0x1234, 7)
+				b[8] = 0x4f // a 60 byte header
+				m, err := parseICMPMessage(b)
+				So(err, ShouldBeNil)
+				_, err = m.Body.(*icmpError).Echo()
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("parseHopReply()", func() {
+			ipv4 := func(b []byte) []byte {
+				hdr := make([]byte, 20)
+				hdr[0], hdr[9] = 0x45, 1 // IPv4, ICMP
+				return append(hdr, b...)
+			}
+			Convey("should return the echo request answered behind an IPv4 header", func() {
+				m, echo, err := parseHopReply(ipv4(timeExceeded(false, 0x1234, 7)), icmpv4EchoReply)
+				So(err, ShouldBeNil)
+				So(m.Type, ShouldEqual, icmpv4TimeExceeded)
+				So(echo.Seq, ShouldEqual, 7)
+
+				reply, _ := ICMPMsg(icmpv4EchoReply, 0, 0x1234, 8, nil)
+				_, echo, err = parseHopReply(ipv4(reply), icmpv4EchoReply)
+				So(err, ShouldBeNil)
+				So(echo.Seq, ShouldEqual, 8)
+			})
+			Convey("should drop truncated packets w/o panicking", func() {
+				reply, _ := ICMPMsg(icmpv4EchoReply, 0, 0x1234, 8, nil)
+				for _, b := range [][]byte{ipv4(reply), ipv4(timeExceeded(false, 0x1234, 7)), timeExceeded(true, 0x1234, 7)} {
+					for n := 0; n < len(b); n++ {
+						So(func() { parseHopReply(b[:n], icmpv4EchoReply) }, ShouldNotPanic)
+						if _, echo, err := parseHopReply(b[:n], icmpv4EchoReply); err == nil {
+							So(echo, ShouldBeNil)
+						}
+					}
+				}
+			})
+			Convey("should return error for bogus IPv4 header lengths", func() {
+				for _, ihl := range []byte{0, 1, 4, 15} {
+					b := ipv4(timeExceeded(false, 0x1234, 7))
+					b[0] = 0x40 | ihl
+					So(func() { parseHopReply(b, icmpv4EchoReply) }, ShouldNotPanic)
+					_, _, err := parseHopReply(b, icmpv4EchoReply)
+					So(err, ShouldNotBeNil)
+
+					b = ipv4(timeExceeded(false, 0x1234, 7))
+					b[20+8] = 0x40 | ihl // the quoted header
+					So(func() { parseHopReply(b, icmpv4EchoReply) }, ShouldNotPanic)
+					_, _, err = parseHopReply(b, icmpv4EchoReply)
+					So(err, ShouldNotBeNil)
+				}
+			})
+		})
+	})
+}
+
+func Test_trace_integration(t *testing.T) {
+	Convey("trace", t, func() {
+		Convey("Trace()", func() {
+			c, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+			if err != nil {
+				Convey("should return PermissionError without a raw socket", func() {
+					_, err := Trace("127.0.0.1", Options{})
+					_, ok := err.(PermissionError)
+					So(ok, ShouldBeTrue)
+				})
+				return
+			}
+			c.Close()
+
+			Convey("should reach 127.0.0.1 in one hop", func() {
+				tr, err := Trace("127.0.0.1", Options{})
+				So(err, ShouldBeNil)
+				So(tr.Reached, ShouldBeTrue)
+				So(len(tr.Hops), ShouldEqual, 1)
+				So(tr.Hops[0].IP, ShouldEqual, "127.0.0.1")
+				So(tr.Hops[0].Time, ShouldBeGreaterThan, 0)
+				So(tr.Path(), ShouldEqual, "127.0.0.1")
+			})
+			Convey("should reach ::1 in one hop", func() {
+				tr, err := Trace("::1", Options{Family: IPv6})
+				So(err, ShouldBeNil)
+				So(tr.Reached, ShouldBeTrue)
+				So(tr.Path(), ShouldEqual, "::1")
+			})
+		})
+	})
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package ping
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// enableRxTTL asks the kernel to pass the TTL (hop limit for IPv6) of every
+// packet c receives, see parseRxTTL
+func enableRxTTL(c syscall.Conn, v6 bool) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+// parseRxTTL returns the TTL of a packet from the control messages read w/ it,
+// false if there isn't one
+func parseRxTTL(oob []byte) (int, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		v4 := m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL
+		v6 := m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_HOPLIMIT
+		if (!v4 && !v6) || len(m.Data) < 4 {
+			continue
+		}
+		return int(*(*int32)(unsafe.Pointer(&m.Data[0]))), true
+	}
+	return 0, false
+}
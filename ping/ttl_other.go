@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// enableRxTTL is only supported on linux, everywhere else only raw IPv4
+// sockets know the TTL of replies, from their IP header
+func enableRxTTL(c syscall.Conn, v6 bool) error {
+	return errors.New("ping: receiving the ttl is not supported on this platform")
+}
+
+// parseRxTTL never finds a TTL, see enableRxTTL
+func parseRxTTL(oob []byte) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuttapp/pinghist/ping"
+)
+
+const (
+	minSaveBackoff     = 1 * time.Second
+	maxSaveBackoff     = 1 * time.Minute
+	maxBufferedResults = 100000 // ~1 day of 1 host pinged every second
+)
+
+// resultWriter saves results to the dal. When a save fails the result is kept
+// in memory along w/ every result after it, and saving them is retried w/
+// backoff as new results arrive, so pings keep being recorded while the db is
+// unavailable (ex: the disk is full). A result is saved in one transaction, so
+// one that fails part way is saved from the start when it's retried. A
+// resultWriter is safe for concurrent use.
+type resultWriter struct {
+	mu       sync.Mutex
+	save     func(*ping.Result) (bool, error) // saveResult, tests replace it
+	errs     *errorLog
+	limit    int            // max # of buffered results, the oldest are dropped
+	buffered []*ping.Result // in the order they arrived
+	dropped  int
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+func newResultWriter(errs *errorLog) *resultWriter {
+	return &resultWriter{save: saveResult, errs: errs, limit: maxBufferedResults}
+}
+
+// Save saves r, or buffers it while saves are failing. changed is true if r was
+// saved and its history changed
+func (w *resultWriter) Save(r *ping.Result) (changed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buffered) == 0 {
+		changed, err := w.save(r)
+		if err == nil {
+			return changed
+		}
+		log.Printf("Couldn't save results, keeping them in memory until they can be: %s", err)
+		w.failed(err)
+		w.buffer(r)
+		return false
+	}
+
+	w.buffer(r)
+	if !time.Now().Before(w.retryAt) {
+		w.flush()
+	}
+	return false
+}
+
+// Close makes a last attempt to save the buffered results, it returns the # of
+// results that couldn't be saved, including the ones that were dropped
+func (w *resultWriter) Close() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+	return len(w.buffered) + w.dropped
+}
+
+// buffer keeps r until it can be saved, w.mu must be held
+func (w *resultWriter) buffer(r *ping.Result) {
+	if len(w.buffered) >= w.limit {
+		w.buffered[0] = nil
+		w.buffered = w.buffered[1:]
+		w.dropped++
+	}
+	w.buffered = append(w.buffered, r)
+}
+
+// flush saves the buffered results in order until one fails, w.mu must be held
+func (w *resultWriter) flush() {
+	n := len(w.buffered)
+	for len(w.buffered) > 0 {
+		if _, err := w.save(w.buffered[0]); err != nil {
+			w.failed(err)
+			return
+		}
+		w.buffered[0] = nil
+		w.buffered = w.buffered[1:]
+	}
+	if n > 0 {
+		log.Printf("Saved %d results kept in memory", n)
+	}
+	w.buffered, w.backoff = nil, 0
+}
+
+// failed records a failed save & backs off the next attempt, w.mu must be held
+func (w *resultWriter) failed(err error) {
+	w.errs.Add("save", err)
+	w.backoff *= 2
+	if w.backoff < minSaveBackoff {
+		w.backoff = minSaveBackoff
+	} else if w.backoff > maxSaveBackoff {
+		w.backoff = maxSaveBackoff
+	}
+	w.retryAt = time.Now().Add(w.backoff)
+}
+
+// errorLog counts the errors pinghist carried on after, to summarize them on exit
+type errorLog struct {
+	mu     sync.Mutex
+	counts map[string]int // by kind: message
+}
+
+func newErrorLog() *errorLog {
+	return &errorLog{counts: map[string]int{}}
+}
+
+// Add counts err, kind is what failed, ex: save
+func (el *errorLog) Add(kind string, err error) {
+	el.mu.Lock()
+	el.counts[kind+": "+err.Error()]++
+	el.mu.Unlock()
+}
+
+// Summary returns a line per distinct error w/ the # of times it happened, most
+// frequent first, "" if there weren't any
+func (el *errorLog) Summary() string {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	msgs := make([]string, 0, len(el.counts))
+	for msg := range el.counts {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if el.counts[msgs[i]] != el.counts[msgs[j]] {
+			return el.counts[msgs[i]] > el.counts[msgs[j]]
+		}
+		return msgs[i] < msgs[j]
+	})
+
+	var b strings.Builder
+	for _, msg := range msgs {
+		fmt.Fprintf(&b, "%6dx %s\n", el.counts[msg], msg)
+	}
+	return b.String()
+}
+
+// fatalError returns true for errors that will fail every probe no matter how
+// long pinghist waits, ex: there's no ping command
+func fatalError(err error) bool {
+	return errors.Is(err, exec.ErrNotFound)
+}
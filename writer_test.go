@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/nuttapp/pinghist/ping"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeStore saves results to saved, failing while down is set
+type fakeStore struct {
+	down  bool
+	saved []*ping.Result
+}
+
+func (s *fakeStore) save(r *ping.Result) (bool, error) {
+	if s.down {
+		return false, errors.New("no space left on device")
+	}
+	s.saved = append(s.saved, r)
+	return true, nil
+}
+
+func Test_writer_unit(t *testing.T) {
+	Convey("writer", t, func() {
+		store := &fakeStore{}
+		errs := newErrorLog()
+		w := newResultWriter(errs)
+		w.save = store.save
+		result := func(i int) *ping.Result {
+			return &ping.Result{Series: "10.0.0.1", Start: time.Unix(int64(i), 0), Time: float64(i)}
+		}
+
+		Convey("Save()", func() {
+			Convey("should save results right away", func() {
+				So(w.Save(result(1)), ShouldBeTrue)
+				So(len(store.saved), ShouldEqual, 1)
+				So(w.Close(), ShouldEqual, 0)
+			})
+			Convey("should keep results while saves fail & save them in order once they work", func() {
+				store.down = true
+				So(w.Save(result(1)), ShouldBeFalse)
+				So(w.Save(result(2)), ShouldBeFalse)
+				So(len(w.buffered), ShouldEqual, 2)
+				So(w.backoff, ShouldEqual, minSaveBackoff)
+
+				store.down = false
+				w.Save(result(3))
+				So(len(store.saved), ShouldEqual, 0) // still backing off
+
+				w.retryAt = time.Time{}
+				w.Save(result(4))
+				So(len(store.saved), ShouldEqual, 4)
+				for i, r := range store.saved {
+					So(r.Time, ShouldEqual, i+1)
+				}
+				So(w.buffered, ShouldBeEmpty)
+				So(w.backoff, ShouldEqual, 0)
+			})
+			Convey("should back off up to maxSaveBackoff", func() {
+				store.down = true
+				for i := 0; i < 10; i++ {
+					w.retryAt = time.Time{}
+					w.Save(result(i))
+				}
+				So(w.backoff, ShouldEqual, maxSaveBackoff)
+			})
+			Convey("should drop the oldest results past the limit", func() {
+				store.down = true
+				w.limit = 2
+				for i := 1; i <= 3; i++ {
+					w.Save(result(i))
+				}
+				So(w.buffered[0].Time, ShouldEqual, 2)
+				So(w.Close(), ShouldEqual, 3)
+
+				store.down = false
+				So(w.Close(), ShouldEqual, 1)
+				So(len(store.saved), ShouldEqual, 2)
+			})
+		})
+
+		Convey("errorLog", func() {
+			Convey("should count errors, most frequent first", func() {
+				So(errs.Summary(), ShouldEqual, "")
+				errs.Add("google.com", errors.New("no such host"))
+				errs.Add("save", errors.New("disk full"))
+				errs.Add("save", errors.New("disk full"))
+				So(errs.Summary(), ShouldEqual, "     2x save: disk full\n     1x google.com: no such host\n")
+			})
+		})
+
+		Convey("fatalError()", func() {
+			So(fatalError(fmt.Errorf("ping.Ping: %w", &exec.Error{Name: "ping", Err: exec.ErrNotFound})), ShouldBeTrue)
+			So(fatalError(errors.New("no such host")), ShouldBeFalse)
+		})
+	})
+}